@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"internal/pb"
+
+	"golang.org/x/term"
+)
+
+// detachKey leaves an attached task running, like the escape key of telnet
+const detachKey = 0x1d // Ctrl-]
+
+// attachTask connects the terminal to an interactive task until the task
+// finishes or the user detaches
+func attachTask(client pb.TaskServiceClient, id int64) {
+	var restore func()
+	stdin := int(os.Stdin.Fd())
+	if term.IsTerminal(stdin) {
+		state, err := term.MakeRaw(stdin)
+		if err != nil {
+			log.Fatalf("could not set up the terminal: %v", err)
+		}
+		restore = func() { term.Restore(stdin, state) }
+	}
+
+	detached, err := streamTerminal(client, id)
+	if restore != nil {
+		restore()
+	}
+	if err != nil {
+		log.Fatalf("could not attach to task: %v", err)
+	}
+	if detached {
+		fmt.Printf("\nDetached from task %d\n", id)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := client.ReadTask(ctx, &pb.ReadTaskRequest{Id: id})
+	if err != nil {
+		log.Fatalf("could not read task: %v", err)
+	}
+	fmt.Printf("\nTask %d %s with exit code %d\n", id, res.Task.Status, res.Task.ReturnCode)
+}
+
+// streamTerminal copies stdin to the task and its output to stdout. It
+// reports whether the user detached before the task finished.
+func streamTerminal(client pb.TaskServiceClient, id int64) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.AttachTask(ctx)
+	if err != nil {
+		return false, err
+	}
+	var mu sync.Mutex
+	send := func(req *pb.AttachTaskRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return stream.Send(req)
+	}
+	if err := send(&pb.AttachTaskRequest{Id: id, Resize: terminalSize()}); err != nil {
+		return false, err
+	}
+
+	detached := make(chan struct{})
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := os.Stdin.Read(buf)
+			input := buf[:n]
+			if i := bytes.IndexByte(input, detachKey); i >= 0 {
+				if i > 0 {
+					send(&pb.AttachTaskRequest{Input: bytes.Clone(input[:i])})
+				}
+				close(detached)
+				cancel()
+				return
+			}
+			if len(input) > 0 && send(&pb.AttachTaskRequest{Input: bytes.Clone(input)}) != nil {
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-winch:
+				if size := terminalSize(); size != nil {
+					send(&pb.AttachTaskRequest{Resize: size})
+				}
+			}
+		}
+	}()
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			select {
+			case <-detached:
+				return true, nil
+			default:
+				return false, err
+			}
+		}
+		os.Stdout.Write(chunk.Data)
+	}
+}
+
+// terminalSize returns the size of the local terminal, or nil if stdout is
+// not a terminal
+func terminalSize() *pb.TerminalSize {
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return nil
+	}
+	return &pb.TerminalSize{Rows: uint32(rows), Cols: uint32(cols)}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -12,43 +13,70 @@ import (
 
 	"internal/pb"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
 	address = "localhost:50052"
 )
 
-func listTasks(client pb.TaskServiceClient, n int) {
+func newTask(client pb.TaskServiceClient, task *pb.Task, force bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	req := &pb.ReadTaskListRequest{Count: int64(n)}
-	res, err := client.ReadTaskList(ctx, req)
+	req := &pb.CreateTaskRequest{Task: task, Force: force}
+	res, err := client.CreateTask(ctx, req)
 	if err != nil {
-		log.Fatalf("could not list tasks: %v", err)
+		fatalTaskProblems("could not create task", err)
 	}
 
-	tasks, err := json.MarshalIndent(res.Tasks, "", "  ")
-	if err != nil {
-		log.Fatalf("could not marshal tasks: %v", err)
+	if res.Task.NotBefore != nil {
+		fmt.Printf("Created task with ID: %d, starting at %v\n", res.Task.Id, res.Task.NotBefore.AsTime().Local())
+		return
 	}
-
-	fmt.Println(string(tasks))
+	fmt.Printf("Created task with ID: %d\n", res.Task.Id)
 }
 
-func newTask(client pb.TaskServiceClient, commandline string, workingDir string) {
+// verifyTask prints the problems the server finds in the task
+func verifyTask(client pb.TaskServiceClient, task *pb.Task) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	task := &pb.Task{WorkingDirectory: workingDir, Commandline: commandline}
-	req := &pb.CreateTaskRequest{Task: task}
-	res, err := client.CreateTask(ctx, req)
+	res, err := client.VerifyTask(ctx, &pb.VerifyTaskRequest{Task: task})
 	if err != nil {
-		log.Fatalf("could not create task: %v", err)
+		log.Fatalf("could not verify task: %v", err)
 	}
 
-	fmt.Printf("Created task with ID: %d\n", res.Task.Id)
+	if len(res.Problems) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+	for _, p := range res.Problems {
+		fmt.Printf("%s: %s\n", p.Field, p.Description)
+	}
+	os.Exit(1)
+}
+
+// fatalTaskProblems exits with the error, listing the problems found by
+// verifying the task if that is why it was refused
+func fatalTaskProblems(message string, err error) {
+	for _, detail := range status.Convert(err).Details() {
+		badRequest, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		fmt.Printf("%s, the task failed verification:\n", message)
+		for _, v := range badRequest.FieldViolations {
+			fmt.Printf("  %s: %s\n", v.Field, v.Description)
+		}
+		fmt.Println("use --force to create it anyway")
+		os.Exit(1)
+	}
+	log.Fatalf("%s: %v", message, err)
 }
 
 func showTask(client pb.TaskServiceClient, id int64, onlyOutput, onlyStatus, onlyExitCode bool) {
@@ -76,23 +104,98 @@ func showTask(client pb.TaskServiceClient, id int64, onlyOutput, onlyStatus, onl
 	}
 }
 
-func printTask(client pb.TaskServiceClient, id int64) {
+func printTask(client pb.TaskServiceClient, req *pb.StreamTaskOutputRequest) {
+	stream, err := client.StreamTaskOutput(context.Background(), req)
+	if err != nil {
+		log.Fatalf("could not read task output: %v", err)
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("could not read task output: %v", err)
+		}
+		os.Stdout.Write(chunk.Data)
+	}
+}
+
+func reprioritizeTask(client pb.TaskServiceClient, id int64, priority int) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	req := &pb.ReadTaskRequest{Id: id}
-	res, err := client.ReadTask(ctx, req)
+	req := &pb.ReprioritizeTaskRequest{Id: id, Priority: int32(priority)}
+	res, err := client.ReprioritizeTask(ctx, req)
 	if err != nil {
-		log.Fatalf("could not read task: %v", err)
+		log.Fatalf("could not reprioritize task: %v", err)
 	}
 
-	outputPath := res.Task.Output
-	content, err := os.ReadFile(outputPath)
+	fmt.Printf("Task %d now has priority %d\n", res.Task.Id, res.Task.Priority)
+}
+
+func cancelTask(client pb.TaskServiceClient, id int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	req := &pb.CancelTaskRequest{Id: id}
+	res, err := client.CancelTask(ctx, req)
 	if err != nil {
-		log.Fatalf("could not read file: %v", err)
+		log.Fatalf("could not cancel task: %v", err)
 	}
 
-	fmt.Print(string(content))
+	fmt.Printf("Cancelling task %d, status: %s\n", res.Task.Id, res.Task.Status)
+}
+
+func listWorkers(client pb.TaskServiceClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.ListWorkers(ctx, &pb.ListWorkersRequest{})
+	if err != nil {
+		log.Fatalf("could not list workers: %v", err)
+	}
+
+	workers, err := json.MarshalIndent(res.Workers, "", "  ")
+	if err != nil {
+		log.Fatalf("could not marshal workers: %v", err)
+	}
+
+	fmt.Println(string(workers))
+}
+
+func watchTasks(client pb.TaskServiceClient, id int64) {
+	req := &pb.WatchTasksRequest{}
+	if id >= 0 {
+		req.Ids = []int64{id}
+	}
+
+	for {
+		stream, err := client.WatchTasks(context.Background(), req)
+		if err != nil {
+			log.Fatalf("could not watch tasks: %v", err)
+		}
+
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				if status.Code(err) == codes.Aborted {
+					// the server dropped us, resume from the last event we saw
+					log.Printf("resuming from sequence %d", req.SinceSequence)
+					break
+				}
+				log.Fatalf("could not receive task event: %v", err)
+			}
+			req.SinceSequence = event.Sequence
+
+			e, err := json.Marshal(event)
+			if err != nil {
+				log.Fatalf("could not marshal event: %v", err)
+			}
+			fmt.Println(string(e))
+		}
+	}
 }
 
 func main() {
@@ -108,20 +211,49 @@ func main() {
 	newCmd := flag.NewFlagSet("new", flag.ExitOnError)
 	showCmd := flag.NewFlagSet("show", flag.ExitOnError)
 	catCmd := flag.NewFlagSet("cat", flag.ExitOnError)
+	watchCmd := flag.NewFlagSet("watch", flag.ExitOnError)
+	cancelCmd := flag.NewFlagSet("cancel", flag.ExitOnError)
+	workersCmd := flag.NewFlagSet("workers", flag.ExitOnError)
+	prioCmd := flag.NewFlagSet("prio", flag.ExitOnError)
+	attachCmd := flag.NewFlagSet("attach", flag.ExitOnError)
+	scheduleCmd := flag.NewFlagSet("schedule", flag.ExitOnError)
+	favCmd := flag.NewFlagSet("fav", flag.ExitOnError)
+	pipelineCmd := flag.NewFlagSet("pipeline", flag.ExitOnError)
+	rerunCmd := flag.NewFlagSet("rerun", flag.ExitOnError)
+	pinCmd := flag.NewFlagSet("pin", flag.ExitOnError)
+	pruneCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+	searchCmd := flag.NewFlagSet("search", flag.ExitOnError)
 	flagSets := map[string]*flag.FlagSet{
-		"list": listCmd,
-		"new":  newCmd,
-		"show": showCmd,
-		"cat":  catCmd,
+		"list":     listCmd,
+		"new":      newCmd,
+		"show":     showCmd,
+		"cat":      catCmd,
+		"watch":    watchCmd,
+		"cancel":   cancelCmd,
+		"workers":  workersCmd,
+		"prio":     prioCmd,
+		"attach":   attachCmd,
+		"schedule": scheduleCmd,
+		"fav":      favCmd,
+		"pipeline": pipelineCmd,
+		"rerun":    rerunCmd,
+		"pin":      pinCmd,
+		"prune":    pruneCmd,
+		"search":   searchCmd,
 	}
 
-	listN := listCmd.Int("n", 10, "Number of tasks to list")
+	listFlags := addListFlags(listCmd)
 
 	cwd, err := os.Getwd()
 	if err != nil {
 		log.Fatalf("could not get current working directory: %v", err)
 	}
-	newWorkingDir := newCmd.String("w", cwd, "Working directory")
+	newFlags := addTaskFlags(newCmd, cwd)
+	newAt := newCmd.String("at", "", "Do not start the task before this time, e.g. 23:00 or '2024-06-01 23:00'")
+	newIn := newCmd.Duration("in", 0, "Do not start the task before this much time has passed, e.g. 2h")
+	newForce := newCmd.Bool("force", false, "Create the task even if it fails verification")
+	newDryRun := newCmd.Bool("dry-run", false, "Only verify the task without creating it")
+	newAfter := newCmd.String("after", "", "Comma separated IDs of the tasks that must succeed before this one starts")
 
 	showID := showCmd.Int64("i", -1, "Task ID")
 	showOutput := showCmd.Bool("o", false, "Only print output path")
@@ -130,16 +262,60 @@ func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
 		fmt.Println("Commands:")
-		fmt.Println("  list -n <number>       List tasks")
-		fmt.Println("  new -w <directory>     Create a new task")
+		fmt.Println("  list [-n <number>] [-s <status>,...] [-e <exit_code>,...] [-w <directory>] [-c <text>] [--since 2h] [--until <time>] [--order newest|oldest|priority|longest] [--page <token>] List tasks")
+		fmt.Println("  new -w <directory> [-p <priority>] [-t <timeout>] [-e KEY=VAL] [--shell bash] [--argv] [--requeue] [--retries N] [--interactive] [--max-output-mb N] [--at 23:00 | --in 2h] [--after <task_id>,...] [--force] [--dry-run] Create a new task")
 		fmt.Println("  show -i <task_id>     Show task details")
-		fmt.Println("  cat -i <task_id>      Print the task output")
+		fmt.Println("  cat -i <task_id> [-f] [--stdout | --stderr] [-T] Print the task output")
+		fmt.Println("  watch [-i <task_id>]  Print task events as they happen")
+		fmt.Println("  cancel -i <task_id>   Cancel a new or running task")
+		fmt.Println("  rerun -i <task_id> [-w <directory>] [-p <priority>] [-t <timeout>] [-e KEY=VAL] [--at 23:00 | --in 2h] [--after <task_id>,...] [--force] [<commandline>] Run a copy of a task, the flags override its settings")
+		fmt.Println("  workers               Show what the runner workers are doing")
+		fmt.Println("  prio -i <task_id> -p <priority> Change the priority of a queued task")
+		fmt.Println("  attach -i <task_id>   Type into an interactive task, Ctrl-] detaches")
+		fmt.Println("  schedule add -c <cron> [-n <name>] [--catch-up skip|once|all] [new flags] <commandline> Run a task on a schedule")
+		fmt.Println("  schedule list          List schedules")
+		fmt.Println("  schedule rm|pause|resume -i <schedule_id> Delete, pause or resume a schedule")
+		fmt.Println("  fav add|edit <name> [new flags] <commandline> Save a favourite command, {{name}} is a placeholder")
+		fmt.Println("  fav list               List favourite commands")
+		fmt.Println("  fav rm <name>          Delete a favourite command")
+		fmt.Println("  fav run <name> [-p NAME=VALUE] [--force] Run a favourite command")
+		fmt.Println("  pipeline -f <file.json> [--force] Create the tasks of a pipeline, see CreatePipelineRequest")
+		fmt.Println("  pipeline -i <pipeline_id> Show a pipeline and its tasks")
+		fmt.Println("  pin -i <task_id> [--off] Keep a task when pruning, or stop keeping it")
+		fmt.Println("  prune [--dry-run] [--max-age 720h] [--max-count N] [--max-size-mb N] Remove old finished tasks and their output, by the policy of the server unless limits are given")
+		fmt.Println("  search [-n <number>] \"<pattern>\" Search the commandline and output of finished tasks, e.g. 'undefined OR panic', '\"exit status\"', 'warn*'")
 		for _, subCmd := range flagSets {
 			subCmd.PrintDefaults()
 		}
 	}
 
 	catId := catCmd.Int64("i", -1, "Task ID")
+	catFollow := catCmd.Bool("f", false, "Keep printing output until the task finishes")
+	catStdout := catCmd.Bool("stdout", false, "Only print what the task wrote to stdout")
+	catStderr := catCmd.Bool("stderr", false, "Only print what the task wrote to stderr")
+	catTimestamps := catCmd.Bool("T", false, "Prefix every line with the time it was written")
+
+	watchID := watchCmd.Int64("i", -1, "Only watch the task with this ID")
+
+	cancelID := cancelCmd.Int64("i", -1, "Task ID")
+
+	prioID := prioCmd.Int64("i", -1, "Task ID")
+	prioPriority := prioCmd.Int("p", 0, "Priority, higher runs first")
+
+	attachID := attachCmd.Int64("i", -1, "Task ID")
+
+	pipelineFile := pipelineCmd.String("f", "", "JSON file with the pipeline to create")
+	pipelineForce := pipelineCmd.Bool("force", false, "Create the tasks even if they fail verification")
+	pipelineID := pipelineCmd.Int64("i", -1, "Pipeline ID")
+
+	rerunFlags := addRerunFlags(rerunCmd)
+
+	pinID := pinCmd.Int64("i", -1, "Task ID")
+	pinOff := pinCmd.Bool("off", false, "Unpin the task")
+
+	pruneFlags := addPruneFlags(pruneCmd)
+
+	searchLimit := searchCmd.Int("n", 0, "Print at most this many tasks, 20 by default")
 
 	if len(os.Args) < 2 {
 		flag.Usage()
@@ -153,21 +329,81 @@ func main() {
 	switch os.Args[1] {
 	case "list":
 		listCmd.Parse(os.Args[2:])
-		listTasks(client, *listN)
+		listTasks(client, listFlags.request())
 	case "new":
 		newCmd.Parse(os.Args[2:])
-		commandline := newCmd.Args()
-		if len(commandline) == 0 {
-			fmt.Println("expected commandline arguments for new task")
+		task := newFlags.task(newCmd.Args())
+		notBefore, err := notBeforeTime(*newAt, *newIn, time.Now())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if !notBefore.IsZero() {
+			task.NotBefore = timestamppb.New(notBefore)
+		}
+		task.DependsOn, err = parseTaskIDs(*newAfter)
+		if err != nil {
+			fmt.Println(err)
 			os.Exit(1)
 		}
-		newTask(client, strings.Join(commandline, " "), *newWorkingDir)
+		if *newDryRun {
+			verifyTask(client, task)
+			return
+		}
+		newTask(client, task, *newForce)
 	case "show":
 		showCmd.Parse(os.Args[2:])
 		showTask(client, *showID, *showOutput, *showStatus, *showExitCode)
 	case "cat":
 		catCmd.Parse(os.Args[2:])
-		printTask(client, *catId)
+		req := &pb.StreamTaskOutputRequest{Id: *catId, Follow: *catFollow, Timestamps: *catTimestamps}
+		switch {
+		case *catStdout && *catStderr:
+			// both streams are the merged output
+		case *catStdout:
+			req.Stream = pb.OutputStream_STDOUT
+		case *catStderr:
+			req.Stream = pb.OutputStream_STDERR
+		}
+		printTask(client, req)
+	case "watch":
+		watchCmd.Parse(os.Args[2:])
+		watchTasks(client, *watchID)
+	case "cancel":
+		cancelCmd.Parse(os.Args[2:])
+		cancelTask(client, *cancelID)
+	case "rerun":
+		rerunCmd.Parse(os.Args[2:])
+		rerunTask(client, rerunFlags.request(rerunCmd))
+	case "workers":
+		workersCmd.Parse(os.Args[2:])
+		listWorkers(client)
+	case "prio":
+		prioCmd.Parse(os.Args[2:])
+		reprioritizeTask(client, *prioID, *prioPriority)
+	case "attach":
+		attachCmd.Parse(os.Args[2:])
+		attachTask(client, *attachID)
+	case "schedule":
+		scheduleCommand(pb.NewScheduleServiceClient(conn), os.Args[2:], cwd)
+	case "fav":
+		favCommand(pb.NewTemplateServiceClient(conn), os.Args[2:], cwd)
+	case "pipeline":
+		pipelineCmd.Parse(os.Args[2:])
+		if len(*pipelineFile) > 0 {
+			createPipeline(client, *pipelineFile, cwd, *pipelineForce)
+		} else {
+			showPipeline(client, *pipelineID)
+		}
+	case "pin":
+		pinCmd.Parse(os.Args[2:])
+		pinTask(client, *pinID, !*pinOff)
+	case "prune":
+		pruneCmd.Parse(os.Args[2:])
+		pruneTasks(client, pruneFlags.request(pruneCmd))
+	case "search":
+		searchCmd.Parse(os.Args[2:])
+		searchTasks(client, strings.Join(searchCmd.Args(), " "), *searchLimit)
 	default:
 		printHelp(flagSets)
 	}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"internal/pb"
+)
+
+// favCommand runs the fav subcommands: add, edit, list, rm and run. The name
+// of the favourite comes before the flags.
+func favCommand(client pb.TemplateServiceClient, args []string, cwd string) {
+	if len(args) == 0 {
+		fmt.Println("expected 'add', 'edit', 'list', 'rm' or 'run' fav subcommands")
+		os.Exit(1)
+	}
+	if args[0] == "list" {
+		listFavs(client)
+		return
+	}
+	if len(args) < 2 {
+		fmt.Printf("expected the name of the favourite after 'fav %s'\n", args[0])
+		os.Exit(1)
+	}
+	name := args[1]
+
+	switch args[0] {
+	case "add", "edit":
+		cmd := flag.NewFlagSet("fav "+args[0], flag.ExitOnError)
+		taskFlags := addTaskFlags(cmd, cwd)
+		cmd.Parse(args[2:])
+		template := &pb.Template{Name: name, Task: taskFlags.task(cmd.Args())}
+		saveFav(client, template, args[0] == "edit")
+	case "rm":
+		deleteFav(client, name)
+	case "run":
+		cmd := flag.NewFlagSet("fav run", flag.ExitOnError)
+		params := envFlag{}
+		cmd.Var(params, "p", "Set a placeholder NAME=VALUE, may be repeated")
+		force := cmd.Bool("force", false, "Create the task even if it fails verification")
+		cmd.Parse(args[2:])
+		runFav(client, name, params, *force)
+	default:
+		fmt.Printf("unknown fav subcommand %q\n", args[0])
+		os.Exit(1)
+	}
+}
+
+func saveFav(client pb.TemplateServiceClient, template *pb.Template, replace bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var res *pb.TemplateResponse
+	var err error
+	if replace {
+		res, err = client.UpdateTemplate(ctx, &pb.UpdateTemplateRequest{Template: template})
+	} else {
+		res, err = client.CreateTemplate(ctx, &pb.CreateTemplateRequest{Template: template})
+	}
+	if err != nil {
+		log.Fatalf("could not save favourite: %v", err)
+	}
+
+	fmt.Printf("Saved favourite %s, parameters: %v\n", res.Template.Name, res.Template.Parameters)
+}
+
+func listFavs(client pb.TemplateServiceClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.ListTemplates(ctx, &pb.ListTemplatesRequest{})
+	if err != nil {
+		log.Fatalf("could not list favourites: %v", err)
+	}
+
+	templates, err := json.MarshalIndent(res.Templates, "", "  ")
+	if err != nil {
+		log.Fatalf("could not marshal favourites: %v", err)
+	}
+
+	fmt.Println(string(templates))
+}
+
+func deleteFav(client pb.TemplateServiceClient, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.DeleteTemplate(ctx, &pb.DeleteTemplateRequest{Name: name})
+	if err != nil {
+		log.Fatalf("could not delete favourite: %v", err)
+	}
+
+	fmt.Printf("Deleted favourite %s\n", res.Template.Name)
+}
+
+func runFav(client pb.TemplateServiceClient, name string, params map[string]string, force bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.RunTemplate(ctx, &pb.RunTemplateRequest{Name: name, Parameters: params, Force: force})
+	if err != nil {
+		fatalTaskProblems("could not run favourite", err)
+	}
+
+	fmt.Printf("Created task with ID: %d\n", res.Task.Id)
+}
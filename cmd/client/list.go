@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// listOrders are the values of list --order
+var listOrders = map[string]pb.TaskOrder{
+	"newest":   pb.TaskOrder_NEWEST_FIRST,
+	"oldest":   pb.TaskOrder_OLDEST_FIRST,
+	"priority": pb.TaskOrder_HIGHEST_PRIORITY_FIRST,
+	"longest":  pb.TaskOrder_LONGEST_FIRST,
+}
+
+type listFlags struct {
+	count         *int
+	statuses      *string
+	exitCodes     *string
+	workingDir    *string
+	command       *string
+	since         *string
+	until         *string
+	finishedSince *string
+	finishedUntil *string
+	order         *string
+	page          *string
+}
+
+func addListFlags(cmd *flag.FlagSet) *listFlags {
+	f := &listFlags{}
+	f.count = cmd.Int("n", 10, "Number of tasks to list, 0 for all")
+	f.statuses = cmd.String("s", "", "Comma separated statuses, e.g. running,finished")
+	f.exitCodes = cmd.String("e", "", "Comma separated exit codes of finished tasks")
+	f.workingDir = cmd.String("w", "", "Working directory")
+	f.command = cmd.String("c", "", "Text the commandline contains")
+	f.since = cmd.String("since", "", "Tasks created since this time, or this long ago, e.g. 2h")
+	f.until = cmd.String("until", "", "Tasks created before this time, or this long ago")
+	f.finishedSince = cmd.String("finished-since", "", "Tasks finished since this time, or this long ago")
+	f.finishedUntil = cmd.String("finished-until", "", "Tasks finished before this time, or this long ago")
+	f.order = cmd.String("order", "newest", "Order: newest, oldest, priority or longest")
+	f.page = cmd.String("page", "", "Page token printed by the previous list")
+	return f
+}
+
+// request builds the ReadTaskList request from the flags. It exits if the
+// flags are invalid.
+func (f *listFlags) request() *pb.ReadTaskListRequest {
+	req := &pb.ReadTaskListRequest{PageSize: int32(*f.count), PageToken: *f.page, Filter: &pb.TaskFilter{
+		WorkingDirectory:    *f.workingDir,
+		CommandlineContains: *f.command,
+	}}
+	for _, s := range strings.Split(*f.statuses, ",") {
+		if len(s) == 0 {
+			continue
+		}
+		status, ok := pb.TaskStatus_value[strings.ToUpper(strings.TrimSpace(s))]
+		if !ok {
+			fmt.Printf("unknown status %q\n", s)
+			os.Exit(1)
+		}
+		req.Filter.Statuses = append(req.Filter.Statuses, pb.TaskStatus(status))
+	}
+	for _, code := range strings.Split(*f.exitCodes, ",") {
+		if len(code) == 0 {
+			continue
+		}
+		c, err := strconv.Atoi(strings.TrimSpace(code))
+		if err != nil {
+			fmt.Printf("invalid exit code %q\n", code)
+			os.Exit(1)
+		}
+		req.Filter.ReturnCodes = append(req.Filter.ReturnCodes, int32(c))
+	}
+
+	now := time.Now()
+	times := []struct {
+		name  string
+		value string
+		field **timestamppb.Timestamp
+	}{
+		{"since", *f.since, &req.Filter.CreatedAfter},
+		{"until", *f.until, &req.Filter.CreatedBefore},
+		{"finished-since", *f.finishedSince, &req.Filter.FinishedAfter},
+		{"finished-until", *f.finishedUntil, &req.Filter.FinishedBefore},
+	}
+	for _, t := range times {
+		if len(t.value) == 0 {
+			continue
+		}
+		parsed, err := pastTime(t.value, now)
+		if err != nil {
+			fmt.Printf("invalid --%s: %v\n", t.name, err)
+			os.Exit(1)
+		}
+		*t.field = timestamppb.New(parsed)
+	}
+
+	order, ok := listOrders[*f.order]
+	if !ok {
+		fmt.Printf("unknown order %q\n", *f.order)
+		os.Exit(1)
+	}
+	req.OrderBy = order
+	return req
+}
+
+// pastTime parses a duration ago, e.g. 2h, or a time as accepted by --at
+// with a date
+func pastTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range append(notBeforeTimeLayouts, "2006-01-02") {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q, expected e.g. 2h or '2024-06-01 23:00'", value)
+}
+
+// listTasks prints a page of tasks, and the flag to get the next page if
+// there is one
+func listTasks(client pb.TaskServiceClient, req *pb.ReadTaskListRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.ReadTaskList(ctx, req)
+	if err != nil {
+		log.Fatalf("could not list tasks: %v", err)
+	}
+
+	tasks, err := json.MarshalIndent(res.Tasks, "", "  ")
+	if err != nil {
+		log.Fatalf("could not marshal tasks: %v", err)
+	}
+
+	fmt.Println(string(tasks))
+	if len(res.NextPageToken) > 0 {
+		fmt.Fprintf(os.Stderr, "next page: --page %s\n", res.NextPageToken)
+	}
+}
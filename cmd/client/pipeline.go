@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"internal/pb"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// createPipeline creates the pipeline described by a JSON file holding a
+// CreatePipelineRequest, e.g.
+//
+//	{"name": "release", "tasks": [
+//	  {"name": "build", "task": {"commandline": "make"}},
+//	  {"name": "test", "task": {"commandline": "make test"}, "depends_on": ["build"]}
+//	]}
+//
+// Tasks without a working directory run in cwd.
+func createPipeline(client pb.TaskServiceClient, file string, cwd string, force bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("could not read pipeline: %v", err)
+	}
+	req := &pb.CreatePipelineRequest{}
+	if err := protojson.Unmarshal(data, req); err != nil {
+		log.Fatalf("could not parse pipeline %s: %v", file, err)
+	}
+	req.Force = req.Force || force
+	for _, t := range req.Tasks {
+		if t.Task != nil && len(t.Task.WorkingDirectory) == 0 {
+			t.Task.WorkingDirectory = cwd
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.CreatePipeline(ctx, req)
+	if err != nil {
+		fatalTaskProblems("could not create pipeline", err)
+	}
+
+	fmt.Printf("Created pipeline with ID: %d\n", res.Pipeline.Id)
+	for _, t := range req.Tasks {
+		fmt.Printf("  %s: task %d\n", t.Name, res.Pipeline.TaskIds[t.Name])
+	}
+}
+
+func showPipeline(client pb.TaskServiceClient, id int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.ReadPipeline(ctx, &pb.ReadPipelineRequest{Id: id})
+	if err != nil {
+		log.Fatalf("could not show pipeline: %v", err)
+	}
+
+	pipeline, err := json.MarshalIndent(res.Pipeline, "", "  ")
+	if err != nil {
+		log.Fatalf("could not marshal pipeline: %v", err)
+	}
+	fmt.Println(string(pipeline))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// pruneFlags override the retention policy of the server. The policy of the
+// server applies when none of them is given.
+type pruneFlags struct {
+	dryRun   *bool
+	maxAge   *time.Duration
+	maxCount *int
+	maxSize  *int64
+}
+
+func addPruneFlags(cmd *flag.FlagSet) *pruneFlags {
+	return &pruneFlags{
+		dryRun:   cmd.Bool("dry-run", false, "Only print the tasks that would be removed"),
+		maxAge:   cmd.Duration("max-age", 0, "Remove the tasks that finished longer ago than this, e.g. 720h"),
+		maxCount: cmd.Int("max-count", 0, "Keep at most this many finished tasks"),
+		maxSize:  cmd.Int64("max-size-mb", 0, "Remove the oldest finished tasks while the task output takes more MiB than this"),
+	}
+}
+
+func (f *pruneFlags) request(cmd *flag.FlagSet) *pb.PruneTasksRequest {
+	req := &pb.PruneTasksRequest{DryRun: *f.dryRun}
+	cmd.Visit(func(fl *flag.Flag) {
+		if fl.Name != "dry-run" {
+			req.Policy = &pb.RetentionPolicy{
+				MaxAge:        durationpb.New(*f.maxAge),
+				MaxCount:      int32(*f.maxCount),
+				MaxTotalBytes: *f.maxSize << 20,
+			}
+		}
+	})
+	return req
+}
+
+func pruneTasks(client pb.TaskServiceClient, req *pb.PruneTasksRequest) {
+	// pruning stats the output of every task
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := client.PruneTasks(ctx, req)
+	if err != nil {
+		log.Fatalf("could not prune tasks: %v", err)
+	}
+
+	verb := "Removed"
+	if req.DryRun {
+		verb = "Would remove"
+	}
+	for _, p := range res.Tasks {
+		fmt.Printf("%s task %d (%s), %d bytes: %s\n", verb, p.Task.Id, p.Task.Commandline, p.OutputBytes, p.Reason)
+	}
+	fmt.Printf("%s %d tasks, %d bytes of output\n", verb, len(res.Tasks), res.FreedBytes)
+}
+
+func pinTask(client pb.TaskServiceClient, id int64, pinned bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.PinTask(ctx, &pb.PinTaskRequest{Id: id, Pinned: pinned})
+	if err != nil {
+		log.Fatalf("could not pin task: %v", err)
+	}
+
+	if res.Task.Pinned {
+		fmt.Printf("Pinned task %d, it is kept when pruning\n", res.Task.Id)
+		return
+	}
+	fmt.Printf("Unpinned task %d\n", res.Task.Id)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// rerunFlags override the settings copied from the task being rerun. Only
+// the flags given on the commandline are sent as overrides.
+type rerunFlags struct {
+	id         *int64
+	workingDir *string
+	priority   *int
+	timeout    *time.Duration
+	env        envFlag
+	at         *string
+	in         *time.Duration
+	after      *string
+	force      *bool
+}
+
+func addRerunFlags(cmd *flag.FlagSet) *rerunFlags {
+	f := &rerunFlags{env: envFlag{}}
+	f.id = cmd.Int64("i", -1, "ID of the task to rerun")
+	f.workingDir = cmd.String("w", "", "Run in this working directory instead")
+	f.priority = cmd.Int("p", 0, "Run with this priority instead")
+	f.timeout = cmd.Duration("t", 0, "Run with this timeout instead, 0 for none")
+	cmd.Var(f.env, "e", "Run with these environment variables KEY=VAL instead, may be repeated")
+	f.at = cmd.String("at", "", "Do not start the task before this time, e.g. 23:00")
+	f.in = cmd.Duration("in", 0, "Do not start the task before this much time has passed, e.g. 2h")
+	f.after = cmd.String("after", "", "Comma separated IDs of the tasks that must succeed before this one starts")
+	f.force = cmd.Bool("force", false, "Create the task even if it fails verification")
+	return f
+}
+
+// request builds the RerunTask request from the flags that were set. The
+// remaining arguments replace the commandline.
+func (f *rerunFlags) request(cmd *flag.FlagSet) *pb.RerunTaskRequest {
+	overrides := &pb.Task{}
+	mask := &fieldmaskpb.FieldMask{}
+	var err error
+	cmd.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "w":
+			overrides.WorkingDirectory = *f.workingDir
+			mask.Paths = append(mask.Paths, "working_directory")
+		case "p":
+			overrides.Priority = int32(*f.priority)
+			mask.Paths = append(mask.Paths, "priority")
+		case "t":
+			if *f.timeout > 0 {
+				overrides.Timeout = durationpb.New(*f.timeout)
+			}
+			mask.Paths = append(mask.Paths, "timeout")
+		case "e":
+			overrides.Env = f.env
+			mask.Paths = append(mask.Paths, "env")
+		case "after":
+			overrides.DependsOn, err = parseTaskIDs(*f.after)
+			mask.Paths = append(mask.Paths, "depends_on")
+		}
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	notBefore, err := notBeforeTime(*f.at, *f.in, time.Now())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !notBefore.IsZero() {
+		overrides.NotBefore = timestamppb.New(notBefore)
+		mask.Paths = append(mask.Paths, "not_before")
+	}
+	if cmd.NArg() > 0 {
+		overrides.Commandline = strings.Join(cmd.Args(), " ")
+		mask.Paths = append(mask.Paths, "commandline")
+	}
+
+	return &pb.RerunTaskRequest{Id: *f.id, Overrides: overrides, UpdateMask: mask, Force: *f.force}
+}
+
+func rerunTask(client pb.TaskServiceClient, req *pb.RerunTaskRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.RerunTask(ctx, req)
+	if err != nil {
+		fatalTaskProblems("could not rerun task", err)
+	}
+
+	fmt.Printf("Created task with ID: %d, a rerun of task %d\n", res.Task.Id, res.Task.RerunOf)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"internal/pb"
+)
+
+var catchUpPolicies = map[string]pb.CatchUpPolicy{
+	"skip": pb.CatchUpPolicy_SKIP_MISSED,
+	"once": pb.CatchUpPolicy_RUN_ONCE,
+	"all":  pb.CatchUpPolicy_RUN_ALL,
+}
+
+// scheduleCommand runs the schedule subcommands: add, list, rm, pause and
+// resume
+func scheduleCommand(client pb.ScheduleServiceClient, args []string, cwd string) {
+	if len(args) == 0 {
+		fmt.Println("expected 'add', 'list', 'rm', 'pause' or 'resume' schedule subcommands")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "add":
+		addCmd := flag.NewFlagSet("schedule add", flag.ExitOnError)
+		cron := addCmd.String("c", "", "Cron expression, e.g. '0 3 * * *', '@daily' or '@every 1h'")
+		name := addCmd.String("n", "", "Name of the schedule")
+		catchUp := addCmd.String("catch-up", "skip", "Runs missed while the server was down: skip, once or all")
+		taskFlags := addTaskFlags(addCmd, cwd)
+		addCmd.Parse(args[1:])
+
+		policy, ok := catchUpPolicies[*catchUp]
+		if !ok {
+			fmt.Printf("unknown catch-up policy %q\n", *catchUp)
+			os.Exit(1)
+		}
+		schedule := &pb.Schedule{
+			Name:          *name,
+			Cron:          *cron,
+			TaskTemplate:  taskFlags.task(addCmd.Args()),
+			CatchUpPolicy: policy,
+		}
+		addSchedule(client, schedule)
+	case "list":
+		listCmd := flag.NewFlagSet("schedule list", flag.ExitOnError)
+		listCmd.Parse(args[1:])
+		listSchedules(client)
+	case "rm", "pause", "resume":
+		idCmd := flag.NewFlagSet("schedule "+args[0], flag.ExitOnError)
+		id := idCmd.Int64("i", -1, "Schedule ID")
+		idCmd.Parse(args[1:])
+		changeSchedule(client, args[0], *id)
+	default:
+		fmt.Printf("unknown schedule subcommand %q\n", args[0])
+		os.Exit(1)
+	}
+}
+
+func addSchedule(client pb.ScheduleServiceClient, schedule *pb.Schedule) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.CreateSchedule(ctx, &pb.CreateScheduleRequest{Schedule: schedule})
+	if err != nil {
+		log.Fatalf("could not create schedule: %v", err)
+	}
+
+	fmt.Printf("Created schedule with ID: %d, next run at %v\n", res.Schedule.Id, res.Schedule.NextRunTime.AsTime().Local())
+}
+
+func listSchedules(client pb.ScheduleServiceClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := client.ListSchedules(ctx, &pb.ListSchedulesRequest{})
+	if err != nil {
+		log.Fatalf("could not list schedules: %v", err)
+	}
+
+	schedules, err := json.MarshalIndent(res.Schedules, "", "  ")
+	if err != nil {
+		log.Fatalf("could not marshal schedules: %v", err)
+	}
+
+	fmt.Println(string(schedules))
+}
+
+func changeSchedule(client pb.ScheduleServiceClient, action string, id int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var res *pb.ScheduleResponse
+	var err error
+	switch action {
+	case "rm":
+		res, err = client.DeleteSchedule(ctx, &pb.DeleteScheduleRequest{Id: id})
+	case "pause":
+		res, err = client.PauseSchedule(ctx, &pb.PauseScheduleRequest{Id: id})
+	case "resume":
+		res, err = client.ResumeSchedule(ctx, &pb.ResumeScheduleRequest{Id: id})
+	}
+	if err != nil {
+		log.Fatalf("could not %s schedule: %v", action, err)
+	}
+
+	switch action {
+	case "rm":
+		fmt.Printf("Deleted schedule %d\n", res.Schedule.Id)
+	case "pause":
+		fmt.Printf("Paused schedule %d\n", res.Schedule.Id)
+	case "resume":
+		fmt.Printf("Resumed schedule %d, next run at %v\n", res.Schedule.Id, res.Schedule.NextRunTime.AsTime().Local())
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"internal/pb"
+)
+
+// searchTasks prints the tasks whose commandline or output matches the query,
+// newest first, each followed by the lines of its output that match
+func searchTasks(client pb.TaskServiceClient, query string, limit int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := client.SearchTasks(ctx, &pb.SearchTasksRequest{Query: query, Limit: int32(limit)})
+	if err != nil {
+		log.Fatalf("could not search tasks: %v", err)
+	}
+
+	for _, result := range res.Results {
+		task := result.Task
+		fmt.Printf("task %d (%s, exit code %d): %s\n", task.Id, task.Status, task.ReturnCode, task.Commandline)
+		for _, line := range result.Lines {
+			fmt.Printf("  %6d: %s\n", line.Number, line.Text)
+		}
+	}
+	if len(res.Results) == 0 {
+		fmt.Printf("No task matches %q\n", query)
+	}
+}
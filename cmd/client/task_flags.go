@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// envFlag collects repeated -e KEY=VAL flags
+type envFlag map[string]string
+
+func (e envFlag) String() string {
+	pairs := make([]string, 0, len(e))
+	for k, v := range e {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (e envFlag) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if !ok || len(k) == 0 {
+		return fmt.Errorf("expected KEY=VAL, got %q", value)
+	}
+	e[k] = v
+	return nil
+}
+
+// taskFlags are the flags describing a task, shared by the commands that
+// create tasks
+type taskFlags struct {
+	workingDir  *string
+	priority    *int
+	timeout     *time.Duration
+	env         envFlag
+	clearEnv    *bool
+	shell       *string
+	argv        *bool
+	requeue     *bool
+	retries     *int
+	backoff     *time.Duration
+	retryOn     *string
+	interactive *bool
+	maxOutput   *int64
+}
+
+func addTaskFlags(cmd *flag.FlagSet, cwd string) *taskFlags {
+	f := &taskFlags{env: envFlag{}}
+	f.workingDir = cmd.String("w", cwd, "Working directory")
+	f.priority = cmd.Int("p", 0, "Priority, higher runs first")
+	f.timeout = cmd.Duration("t", 0, "Kill the task if it runs longer than this, e.g. 30m")
+	cmd.Var(f.env, "e", "Set an environment variable KEY=VAL, may be repeated")
+	f.clearEnv = cmd.Bool("clear-env", false, "Do not inherit the server's environment")
+	f.shell = cmd.String("shell", "sh", "Shell to run the command with: sh or bash")
+	f.argv = cmd.Bool("argv", false, "Execute the arguments directly without a shell")
+	f.requeue = cmd.Bool("requeue", false, "Run the task again if the server stops while it is running")
+	f.retries = cmd.Int("retries", 0, "Number of times a failing task is retried")
+	f.backoff = cmd.Duration("backoff", 0, "Delay before the first retry, doubled after each retry")
+	f.retryOn = cmd.String("retry-on", "", "Comma separated exit codes to retry, any non-zero exit code when empty")
+	f.interactive = cmd.Bool("interactive", false, "Run the task in a terminal that can be attached to")
+	f.maxOutput = cmd.Int64("max-output-mb", 0, "Keep only the head and tail of the output beyond this many MiB, the server's limit when 0")
+	return f
+}
+
+// task builds the task described by the flags, running commandline. It exits
+// if the flags are invalid.
+func (f *taskFlags) task(commandline []string) *pb.Task {
+	if len(commandline) == 0 {
+		fmt.Println("expected commandline arguments for new task")
+		os.Exit(1)
+	}
+	task := &pb.Task{
+		WorkingDirectory: *f.workingDir,
+		Commandline:      strings.Join(commandline, " "),
+		Priority:         int32(*f.priority),
+		Env:              f.env,
+		ClearEnv:         *f.clearEnv,
+		Interactive:      *f.interactive,
+		MaxOutputBytes:   *f.maxOutput << 20,
+	}
+	if *f.requeue {
+		task.RecoveryPolicy = pb.RecoveryPolicy_REQUEUE
+	}
+	if *f.retries > 0 {
+		task.RetryPolicy = &pb.RetryPolicy{
+			MaxAttempts:       int32(*f.retries + 1),
+			Backoff:           durationpb.New(*f.backoff),
+			BackoffMultiplier: 2,
+		}
+		for _, code := range strings.Split(*f.retryOn, ",") {
+			if len(code) == 0 {
+				continue
+			}
+			c, err := strconv.Atoi(strings.TrimSpace(code))
+			if err != nil {
+				fmt.Printf("invalid exit code %q\n", code)
+				os.Exit(1)
+			}
+			task.RetryPolicy.RetryOnExitCodes = append(task.RetryPolicy.RetryOnExitCodes, int32(c))
+		}
+	}
+	if *f.timeout > 0 {
+		task.Timeout = durationpb.New(*f.timeout)
+	}
+	if *f.argv {
+		task.Shell = pb.Shell_EXEC
+		task.Argv = commandline
+	} else {
+		shell, ok := pb.Shell_value[strings.ToUpper(*f.shell)]
+		if !ok || pb.Shell(shell) == pb.Shell_EXEC {
+			fmt.Printf("unknown shell %q\n", *f.shell)
+			os.Exit(1)
+		}
+		task.Shell = pb.Shell(shell)
+	}
+	return task
+}
+
+// notBeforeTimeLayouts are the layouts accepted by --at, in local time
+var notBeforeTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04"}
+
+// notBeforeTime returns the time a new task may start given --at and --in,
+// or the zero time if neither is set. A time of day without a date is the
+// next time the clock shows it.
+func notBeforeTime(at string, in time.Duration, now time.Time) (time.Time, error) {
+	if len(at) > 0 && in != 0 {
+		return time.Time{}, fmt.Errorf("--at and --in cannot be used together")
+	}
+	if in < 0 {
+		return time.Time{}, fmt.Errorf("--in must not be negative")
+	}
+	if in > 0 {
+		return now.Add(in), nil
+	}
+	if len(at) == 0 {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		clock, err := time.ParseInLocation(layout, at, now.Location())
+		if err != nil {
+			continue
+		}
+		t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, now.Location())
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, at); err == nil {
+		return t, nil
+	}
+	for _, layout := range notBeforeTimeLayouts {
+		if t, err := time.ParseInLocation(layout, at, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse --at %q, expected e.g. 23:00 or '2024-06-01 23:00'", at)
+}
+
+// parseTaskIDs parses the comma separated task IDs given to --after
+func parseTaskIDs(ids string) ([]int64, error) {
+	var parsed []int64
+	for _, id := range strings.Split(ids, ",") {
+		if len(strings.TrimSpace(id)) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid task ID %q", id)
+		}
+		parsed = append(parsed, n)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"internal/pb"
+	"internal/service"
+)
+
+var (
+	jsonMarshaler   = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+	jsonUnmarshaler = protojson.UnmarshalOptions{}
+)
+
+// httpGateway exposes the TaskService as REST endpoints. Requests and
+// responses are the protojson form of the gRPC messages.
+//
+// The gateway has no authentication, so the endpoints that change tasks only
+// accept JSON from the same origin: a web page on another site can send a
+// form or text/plain POST to it, but not a JSON one without a CORS preflight,
+// which is never allowed. A site whose name resolves to the server, e.g. by
+// DNS rebinding, is its own origin, so the handler also checks the Host of
+// every request, see allowedHosts.
+type httpGateway struct {
+	tasks *service.TaskServiceServer
+}
+
+func newHTTPGateway(tasks *service.TaskServiceServer) http.Handler {
+	g := &httpGateway{tasks: tasks}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tasks", g.listTasks)
+	mux.HandleFunc("POST /api/tasks", sameOriginJSON(g.createTask))
+	mux.HandleFunc("GET /api/tasks/{id}", g.readTask)
+	mux.HandleFunc("DELETE /api/tasks/{id}", sameOriginJSON(g.deleteTask))
+	mux.HandleFunc("GET /api/tasks/{id}/output", g.streamOutput)
+	mux.HandleFunc("POST /api/tasks/{id}/cancel", sameOriginJSON(g.cancelTask))
+	mux.HandleFunc("POST /api/tasks/{id}/rerun", sameOriginJSON(g.rerunTask))
+	mux.HandleFunc("GET /api/tasks/{id}/ws", g.taskSocket)
+	mux.HandleFunc("GET /api/search", g.searchTasks)
+	return mux
+}
+
+// sameOriginJSON rejects the requests that are not JSON or that come from
+// another origin
+func sameOriginJSON(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkOrigin(r); err != nil {
+			writeError(w, status.Error(codes.PermissionDenied, err.Error()))
+			return
+		}
+		contentType := r.Header.Get("Content-Type")
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+			writeError(w, status.Errorf(codes.InvalidArgument, "expected Content-Type application/json, but got %q", contentType))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// checkOrigin rejects cross-site requests from browsers. Clients that send
+// no origin are accepted.
+func checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host != r.Host {
+		return fmt.Errorf("origin %s does not match host %s", origin, r.Host)
+	}
+	return nil
+}
+
+// allowedHosts rejects the requests to a host other than the loopback
+// interface or one of hosts, which may include a port
+func allowedHosts(handler http.Handler, hosts []string) http.Handler {
+	allowed := map[string]bool{"localhost": true, "127.0.0.1": true, "::1": true}
+	for _, host := range hosts {
+		allowed[hostname(host)] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[hostname(r.Host)] {
+			writeError(w, status.Errorf(codes.PermissionDenied, "host %q is not allowed, see --allowed-hosts", r.Host))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// hostname returns the lower-case host of host[:port], without the brackets
+// of an IPv6 address
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// GET /api/tasks?page_size=N&page_token=T&status=RUNNING,FINISHED&exit_code=1
+// &working_directory=D&command=S&created_after=T&created_before=T
+// &finished_after=T&finished_before=T&order_by=OLDEST_FIRST
+//
+// Times are RFC 3339. count=N is accepted for page_size.
+func (g *httpGateway) listTasks(w http.ResponseWriter, r *http.Request) {
+	req, err := listTasksRequest(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := g.tasks.ReadTaskList(r.Context(), req)
+	writeResponse(w, res, err)
+}
+
+func listTasksRequest(query url.Values) (*pb.ReadTaskListRequest, error) {
+	req := &pb.ReadTaskListRequest{Filter: &pb.TaskFilter{}, PageToken: query.Get("page_token")}
+	for _, name := range []string{"count", "page_size"} {
+		if value := query.Get(name); len(value) > 0 {
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q", name, value)
+			}
+			req.PageSize = int32(n)
+		}
+	}
+	for _, value := range queryList(query, "status") {
+		s, ok := pb.TaskStatus_value[strings.ToUpper(value)]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid status %q", value)
+		}
+		req.Filter.Statuses = append(req.Filter.Statuses, pb.TaskStatus(s))
+	}
+	for _, value := range queryList(query, "exit_code") {
+		code, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid exit_code %q", value)
+		}
+		req.Filter.ReturnCodes = append(req.Filter.ReturnCodes, int32(code))
+	}
+	req.Filter.WorkingDirectory = query.Get("working_directory")
+	req.Filter.CommandlineContains = query.Get("command")
+	times := map[string]**timestamppb.Timestamp{
+		"created_after":   &req.Filter.CreatedAfter,
+		"created_before":  &req.Filter.CreatedBefore,
+		"finished_after":  &req.Filter.FinishedAfter,
+		"finished_before": &req.Filter.FinishedBefore,
+	}
+	for name, field := range times {
+		if value := query.Get(name); len(value) > 0 {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q, expected RFC 3339", name, value)
+			}
+			*field = timestamppb.New(t)
+		}
+	}
+	if value := query.Get("order_by"); len(value) > 0 {
+		order, ok := pb.TaskOrder_value[strings.ToUpper(value)]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid order_by %q", value)
+		}
+		req.OrderBy = pb.TaskOrder(order)
+	}
+	return req, nil
+}
+
+// queryList returns the values of a parameter given several times or comma
+// separated
+func queryList(query url.Values, name string) []string {
+	var values []string
+	for _, value := range query[name] {
+		for _, v := range strings.Split(value, ",") {
+			if len(v) > 0 {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// POST /api/tasks?force=true with a Task as the body
+func (g *httpGateway) createTask(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "could not read body: %v", err))
+		return
+	}
+	task := &pb.Task{}
+	if err := jsonUnmarshaler.Unmarshal(body, task); err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "invalid task: %v", err))
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	res, err := g.tasks.CreateTask(r.Context(), &pb.CreateTaskRequest{Task: task, Force: force})
+	writeResponse(w, res, err)
+}
+
+// GET /api/tasks/{id}
+func (g *httpGateway) readTask(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	res, err := g.tasks.ReadTask(r.Context(), &pb.ReadTaskRequest{Id: id})
+	writeResponse(w, res, err)
+}
+
+// DELETE /api/tasks/{id}
+func (g *httpGateway) deleteTask(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	res, err := g.tasks.DeleteTask(r.Context(), &pb.DeleteTaskRequest{Id: id})
+	writeResponse(w, res, err)
+}
+
+// POST /api/tasks/{id}/cancel
+func (g *httpGateway) cancelTask(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	res, err := g.tasks.CancelTask(r.Context(), &pb.CancelTaskRequest{Id: id})
+	writeResponse(w, res, err)
+}
+
+// POST /api/tasks/{id}/rerun?force=true with an optional RerunTaskRequest as
+// the body to override settings
+func (g *httpGateway) rerunTask(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "could not read body: %v", err))
+		return
+	}
+	req := &pb.RerunTaskRequest{}
+	if len(body) > 0 {
+		if err := jsonUnmarshaler.Unmarshal(body, req); err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid rerun request: %v", err))
+			return
+		}
+	}
+	req.Id = id
+	req.Force = req.Force || r.URL.Query().Get("force") == "true"
+
+	res, err := g.tasks.RerunTask(r.Context(), req)
+	writeResponse(w, res, err)
+}
+
+// GET /api/search?q=Q&limit=N
+func (g *httpGateway) searchTasks(w http.ResponseWriter, r *http.Request) {
+	req := &pb.SearchTasksRequest{Query: r.URL.Query().Get("q")}
+	if value := r.URL.Query().Get("limit"); len(value) > 0 {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid limit %q", value))
+			return
+		}
+		req.Limit = int32(n)
+	}
+
+	res, err := g.tasks.SearchTasks(r.Context(), req)
+	writeResponse(w, res, err)
+}
+
+// GET /api/tasks/{id}/output?offset=N&follow=true&stream=stderr&timestamps=true
+// streams the output as text
+func (g *httpGateway) streamOutput(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	req := &pb.StreamTaskOutputRequest{Id: id}
+	query := r.URL.Query()
+	if offset := query.Get("offset"); len(offset) > 0 {
+		n, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid offset %q", offset))
+			return
+		}
+		req.Offset = n
+	}
+	if follow := query.Get("follow"); len(follow) > 0 {
+		b, err := strconv.ParseBool(follow)
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid follow %q", follow))
+			return
+		}
+		req.Follow = b
+	}
+	if name := query.Get("stream"); len(name) > 0 {
+		value, ok := pb.OutputStream_value[strings.ToUpper(name)]
+		if !ok {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid stream %q, expected stdout, stderr or merged", name))
+			return
+		}
+		req.Stream = pb.OutputStream(value)
+	}
+	if timestamps := query.Get("timestamps"); len(timestamps) > 0 {
+		b, err := strconv.ParseBool(timestamps)
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid timestamps %q", timestamps))
+			return
+		}
+		req.Timestamps = b
+	}
+
+	stream := &httpOutputStream{ctx: r.Context(), w: w}
+	err := g.tasks.StreamTaskOutput(req, stream)
+	if err != nil && !stream.started {
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("HTTP: output of task %d interrupted: %v", id, err)
+	}
+}
+
+// httpOutputStream adapts an HTTP response to the server side of the
+// StreamTaskOutput RPC. Only Send and Context are used by the service.
+type httpOutputStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *httpOutputStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *httpOutputStream) Send(chunk *pb.TaskOutputChunk) error {
+	if !s.started {
+		s.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		s.w.Header().Set("X-Content-Type-Options", "nosniff")
+		s.started = true
+	}
+	if _, err := s.w.Write(chunk.Data); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "invalid task ID %q", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+func writeResponse(w http.ResponseWriter, res proto.Message, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := jsonMarshaler.Marshal(res)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// writeError writes the gRPC status of err as JSON with the matching HTTP
+// status code
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	body, merr := jsonMarshaler.Marshal(st.Proto())
+	if merr != nil {
+		log.Printf("HTTP: failed to marshal error %v: %v", err, merr)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusFromCode(st.Code()))
+	w.Write(body)
+}
+
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Canceled:
+		return 499 // client closed request
+	default:
+		return http.StatusInternalServerError
+	}
+}
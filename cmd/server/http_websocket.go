@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"internal/pb"
+)
+
+// GET /api/tasks/{id}/ws opens a WebSocket following the task. The output is
+// sent as binary messages as the task writes it, and status changes as text
+// messages holding a TaskEvent. The current state of the task is sent first
+// as an UPDATED event with sequence 0. The socket is closed once the task is
+// final and all of its output was sent; errors are sent as a status before
+// closing.
+//
+// For interactive tasks the socket is attached to the terminal: binary
+// messages from the browser are typed into it, and text messages holding an
+// AttachTaskRequest resize it.
+func (g *httpGateway) taskSocket(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := g.tasks.ReadTask(r.Context(), &pb.ReadTaskRequest{Id: id}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: checkSameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			g.serveTaskSocket(ws, id)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// checkSameOrigin rejects cross-site WebSocket connections from browsers
+func checkSameOrigin(config *websocket.Config, r *http.Request) error {
+	return checkOrigin(r)
+}
+
+func (g *httpGateway) serveTaskSocket(ws *websocket.Conn, id int64) {
+	defer ws.Close()
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+	conn := &taskSocketConn{ws: ws}
+
+	go func() {
+		err := g.tasks.WatchTasks(&pb.WatchTasksRequest{Ids: []int64{id}}, &socketEventStream{ctx: ctx, conn: conn})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("WebSocket: events of task %d interrupted: %v", id, err)
+		}
+	}()
+
+	task, err := g.sendTaskState(ctx, conn, id)
+	if err != nil {
+		conn.sendError(err)
+		return
+	}
+	if task.GetInteractive() {
+		err = g.tasks.AttachTask(&socketAttachStream{
+			socketOutputStream: socketOutputStream{ctx: ctx, conn: conn},
+			cancel:             cancel,
+			id:                 id,
+		})
+	} else {
+		// nothing is sent to other tasks, reading only notices when the
+		// browser leaves
+		go func() {
+			defer cancel()
+			var msg []byte
+			for websocket.Message.Receive(ws, &msg) == nil {
+			}
+		}()
+		err = g.tasks.StreamTaskOutput(&pb.StreamTaskOutputRequest{Id: id, Follow: true}, &socketOutputStream{ctx: ctx, conn: conn})
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("WebSocket: output of task %d interrupted: %v", id, err)
+		conn.sendError(err)
+		return
+	}
+	// the final state, in case its event raced with the end of the output
+	if _, err := g.sendTaskState(ctx, conn, id); err != nil {
+		conn.sendError(err)
+	}
+}
+
+func (g *httpGateway) sendTaskState(ctx context.Context, conn *taskSocketConn, id int64) (*pb.Task, error) {
+	res, err := g.tasks.ReadTask(ctx, &pb.ReadTaskRequest{Id: id})
+	if err != nil {
+		return nil, err
+	}
+	err = conn.sendText(&pb.TaskEvent{
+		Type: pb.TaskEventType_UPDATED,
+		Task: res.GetTask(),
+		Time: timestamppb.Now(),
+	})
+	return res.GetTask(), err
+}
+
+// taskSocketConn serializes the writes of the output and event streams
+type taskSocketConn struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+func (c *taskSocketConn) sendBinary(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return websocket.Message.Send(c.ws, data)
+}
+
+func (c *taskSocketConn) sendText(msg proto.Message) error {
+	body, err := jsonMarshaler.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return websocket.Message.Send(c.ws, string(body))
+}
+
+func (c *taskSocketConn) sendError(err error) {
+	if err := c.sendText(status.Convert(err).Proto()); err != nil {
+		log.Printf("WebSocket: failed to send error: %v", err)
+	}
+}
+
+// socketOutputStream adapts the socket to the server side of the
+// StreamTaskOutput RPC
+type socketOutputStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	conn *taskSocketConn
+}
+
+func (s *socketOutputStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *socketOutputStream) Send(chunk *pb.TaskOutputChunk) error {
+	return s.conn.sendBinary(chunk.GetData())
+}
+
+// socketEventStream adapts the socket to the server side of the WatchTasks
+// RPC
+type socketEventStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	conn *taskSocketConn
+}
+
+func (s *socketEventStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *socketEventStream) Send(event *pb.TaskEvent) error {
+	return s.conn.sendText(event)
+}
+
+// socketAttachStream adapts the socket to the server side of the AttachTask
+// RPC
+type socketAttachStream struct {
+	socketOutputStream
+	cancel  func()
+	id      int64
+	started bool
+}
+
+// socketMessage is a message received from the browser
+type socketMessage struct {
+	binary bool
+	data   []byte
+}
+
+var socketMessageCodec = websocket.Codec{
+	Marshal: func(v any) ([]byte, byte, error) {
+		return nil, 0, fmt.Errorf("socketMessageCodec is only used to receive")
+	},
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		msg := v.(*socketMessage)
+		msg.binary = payloadType == websocket.BinaryFrame
+		msg.data = data
+		return nil
+	},
+}
+
+func (s *socketAttachStream) Recv() (*pb.AttachTaskRequest, error) {
+	if !s.started {
+		s.started = true
+		return &pb.AttachTaskRequest{Id: s.id}, nil
+	}
+
+	for {
+		var msg socketMessage
+		if err := socketMessageCodec.Receive(s.conn.ws, &msg); err != nil {
+			// the browser left
+			s.cancel()
+			return nil, err
+		}
+		if msg.binary {
+			return &pb.AttachTaskRequest{Input: msg.data}, nil
+		}
+		req := &pb.AttachTaskRequest{}
+		if err := jsonUnmarshaler.Unmarshal(msg.data, req); err != nil {
+			log.Printf("WebSocket: ignoring invalid message for task %d: %v", s.id, err)
+			continue
+		}
+		return req, nil
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"internal/db"
+)
+
+// migrateCommand runs `server migrate [--status] [--to N]`, which migrates
+// the database without starting the server
+func migrateCommand(dbPath string, args []string) {
+	cmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	showStatus := cmd.Bool("status", false, "Print the schema version and the migrations without applying any")
+	to := cmd.Int("to", db.LatestSchemaVersion(), "Schema version to migrate to")
+	cmd.Parse(args)
+
+	if _, err := os.Stat(dbPath); err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	taskDB, err := db.NewTaskDatabase(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer taskDB.Uninit()
+
+	if *showStatus {
+		printMigrationStatus(taskDB, dbPath)
+		return
+	}
+
+	from, err := taskDB.SchemaVersion()
+	if err != nil {
+		log.Fatalf("Failed to read the schema version: %v", err)
+	}
+	if err := taskDB.Migrate(*to); err != nil {
+		log.Fatalf("Failed to migrate: %v", err)
+	}
+	if from == *to {
+		fmt.Printf("%s is at schema version %d already\n", dbPath, from)
+		return
+	}
+	fmt.Printf("Migrated %s from schema version %d to %d\n", dbPath, from, *to)
+}
+
+func printMigrationStatus(taskDB db.TaskDatabase, dbPath string) {
+	version, err := taskDB.SchemaVersion()
+	if err != nil {
+		log.Fatalf("Failed to read the schema version: %v", err)
+	}
+	statuses, err := taskDB.MigrationStatus()
+	if err != nil {
+		log.Fatalf("Failed to read the migrations: %v", err)
+	}
+
+	fmt.Printf("%s: schema version %d, latest %d\n", dbPath, version, db.LatestSchemaVersion())
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied {
+			applied = "applied " + s.AppliedTime.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("  %3d  %-40s %s\n", s.Version, s.Description, applied)
+	}
+}
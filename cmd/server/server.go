@@ -1,18 +1,26 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"internal/db"
 	"internal/pb"
 	"internal/runner"
+	"internal/scheduler"
 	"internal/service"
+	"internal/verifier"
 )
 
 const (
@@ -24,8 +32,25 @@ const (
 )
 
 func main() {
+	cancelGracePeriod := flag.Duration("cancel-grace", runner.DefaultCancelGracePeriod, "Time between SIGTERM and SIGKILL when cancelling a task")
+	maxConcurrency := flag.Int("workers", runner.DefaultMaxConcurrency, "Maximum number of tasks running at the same time")
+	maxOutput := flag.Int64("max-output-mb", 0, "Keep only the head and tail of the output of a task beyond this many MiB, 0 for no limit; tasks may set their own limit")
+	compressOutput := flag.Bool("compress-output", false, "Gzip the output of the tasks once they finish")
+	httpAddr := flag.String("http", "127.0.0.1:8080", "Address of the HTTP gateway and web UI, empty to disable them; anyone who can reach it can run commands")
+	allowedHostList := flag.String("allowed-hosts", "", "Comma-separated host names the HTTP gateway answers to besides localhost, e.g. when --http listens on another interface")
+	retainAge := flag.Duration("retain-age", 0, "Remove the tasks that finished longer ago than this, 0 to keep them")
+	retainCount := flag.Int("retain-count", 0, "Keep at most this many finished tasks, 0 for no limit")
+	retainSize := flag.Int64("retain-size-mb", 0, "Remove the oldest finished tasks while the task output takes more MiB than this, 0 for no limit")
+	pruneInterval := flag.Duration("prune-interval", time.Hour, "Time between two applications of the retention policy")
+	flag.Parse()
+
 	tmpDir := filepath.Join(os.Getenv("HOME"), tmpDir)
 
+	if flag.Arg(0) == "migrate" {
+		migrateCommand(filepath.Join(tmpDir, dbPath), flag.Args()[1:])
+		return
+	}
+
 	// Initialize the database
 	taskDB, err := db.NewTaskDatabase(filepath.Join(tmpDir, dbPath))
 	if err != nil {
@@ -37,11 +62,24 @@ func main() {
 	}
 	defer taskDB.Uninit()
 
+	// Initialize the runner service
+	runnerDaemon := runner.NewRunnerDaemon(taskDB, runner.RunnerOptions{
+		CancelGracePeriod: *cancelGracePeriod,
+		MaxConcurrency:    *maxConcurrency,
+		MaxOutputBytes:    *maxOutput << 20,
+		CompressOutput:    *compressOutput,
+	})
+
+	// Reconcile the tasks that were running when the server stopped
+	err = runnerDaemon.Recover()
+	if err != nil {
+		log.Fatalf("Failed to recover tasks: %v", err)
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	// Initialize the runner service
-	runnerDaemon := runner.NewRunnerDaemon(taskDB)
+	// Start the runner service
 	go func() {
 		defer runnerDaemon.Close()
 		defer wg.Done()
@@ -50,16 +88,41 @@ func main() {
 		log.Printf("Runner service stopped")
 	}()
 
+	// Start the scheduler
+	taskScheduler := scheduler.NewScheduler(taskDB)
+	go func() {
+		log.Printf("Scheduler started")
+		taskScheduler.Run()
+		log.Printf("Scheduler stopped")
+	}()
+
 	// Initialize the gRPC server
 	server := grpc.NewServer()
 	taskService := service.NewTaskServiceServer(taskDB)
+	taskService.SetRunner(runnerDaemon)
+	taskService.SetVerifier(verifier.Default())
+	taskService.SetRetention(&pb.RetentionPolicy{
+		MaxAge:        durationpb.New(*retainAge),
+		MaxCount:      int32(*retainCount),
+		MaxTotalBytes: *retainSize << 20,
+	}, runnerDaemon.OutputDir())
+	scheduleService := service.NewScheduleServiceServer(taskDB)
+	scheduleService.SetScheduler(taskScheduler)
+	templateService := service.NewTemplateServiceServer(taskDB, taskService)
 
 	// create a listner to receive task update events
 	taskListener := runner.NewTaskListener(runnerDaemon.IncomingChan)
 	taskService.RegisterListener(taskListener)
 
-	// Register the TaskServiceServer with the gRPC server
+	// tasks whose parents finished while the server was stopped
+	taskService.ResolveWaitingTasks()
+	// tasks that finished before their output was searchable
+	go taskService.IndexTaskOutputs()
+
+	// Register the services with the gRPC server
 	pb.RegisterTaskServiceServer(server, taskService)
+	pb.RegisterScheduleServiceServer(server, scheduleService)
+	pb.RegisterTemplateServiceServer(server, templateService)
 
 	// Start the gRPC server
 	listener, err := net.Listen(protocol, listenAddr)
@@ -67,6 +130,7 @@ func main() {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 	go func() {
+		defer taskScheduler.Close()
 		defer func() { runnerDaemon.ExitChan <- true }()
 		defer wg.Done()
 		log.Printf("gRPC server is listening on %s", listenAddr)
@@ -75,9 +139,43 @@ func main() {
 		}
 	}()
 
+	// Start the HTTP gateway and the web UI
+	if len(*httpAddr) > 0 {
+		var hosts []string
+		if len(*allowedHostList) > 0 {
+			hosts = strings.Split(*allowedHostList, ",")
+		}
+		go func() {
+			log.Printf("HTTP gateway is listening on %s", *httpAddr)
+			err := http.ListenAndServe(*httpAddr, newHTTPHandler(newHTTPGateway(taskService), hosts))
+			if err != nil {
+				log.Fatalf("Failed to serve HTTP: %v", err)
+			}
+		}()
+	}
+
 	go func() {
 		for t := range runnerDaemon.TaskChan {
 			log.Printf("Updated: ID: %d, CMD: %s, Status %s\n", t.Id, t.Commandline, t.GetStatus().String())
+			taskService.NotifyTaskUpdated(t)
+		}
+	}()
+
+	// Apply the retention policy
+	if *pruneInterval > 0 {
+		go func() {
+			for range time.Tick(*pruneInterval) {
+				if _, err := taskService.PruneTasks(context.Background(), &pb.PruneTasksRequest{}); err != nil {
+					log.Printf("Failed to prune tasks: %v", err)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for t := range taskScheduler.TaskChan {
+			log.Printf("Scheduled: ID: %d, CMD: %s, Schedule %d\n", t.Id, t.Commandline, t.ScheduleId)
+			taskService.NotifyTaskCreated(t)
 		}
 	}()
 
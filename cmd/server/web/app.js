@@ -0,0 +1,391 @@
+'use strict';
+
+const FINAL_STATUSES = ['FINISHED', 'CANCELLED', 'TIMED_OUT', 'INTERRUPTED', 'SKIPPED'];
+const REFRESH_INTERVAL = 2000;
+
+// the settings copied from a task when it is run again
+// the input sent to interactive tasks for keys that do not produce text
+const KEY_INPUT = {
+  Enter: '\r',
+  Backspace: '\x7f',
+  Tab: '\t',
+  Escape: '\x1b',
+  ArrowUp: '\x1b[A',
+  ArrowDown: '\x1b[B',
+  ArrowRight: '\x1b[C',
+  ArrowLeft: '\x1b[D',
+  Home: '\x1b[H',
+  End: '\x1b[F',
+  Delete: '\x1b[3~',
+};
+
+let selectedTaskId = null;
+let outputSocket = null;
+
+async function api(method, path, body) {
+  const options = { method: method, headers: {} };
+  if (method !== 'GET') {
+    // the gateway only accepts JSON for the requests changing tasks
+    options.headers['Content-Type'] = 'application/json';
+  }
+  if (body !== undefined) {
+    options.body = JSON.stringify(body);
+  }
+  const res = await fetch(path, options);
+  const data = await res.json();
+  if (!res.ok) {
+    const err = new Error(data.message || res.statusText);
+    err.details = data.details || [];
+    throw err;
+  }
+  return data;
+}
+
+// verificationProblems returns the problems listed in the error of a task
+// that failed verification
+function verificationProblems(err) {
+  const problems = [];
+  for (const detail of err.details || []) {
+    if (detail['@type'] === 'type.googleapis.com/google.rpc.BadRequest') {
+      for (const v of detail.field_violations || detail.fieldViolations || []) {
+        problems.push(`${v.field}: ${v.description}`);
+      }
+    }
+  }
+  return problems;
+}
+
+function showError(err) {
+  const el = document.getElementById('error');
+  el.textContent = err ? err.message : '';
+  el.hidden = !err;
+}
+
+function isFinal(task) {
+  return FINAL_STATUSES.includes(task.status);
+}
+
+function formatTime(ts) {
+  return ts ? new Date(ts).toLocaleString() : '';
+}
+
+// statusText shows when a task waiting for its not_before time will start,
+// and which tasks a WAITING task waits for
+function statusText(task) {
+  if (task.status === 'NEW' && task.not_before && new Date(task.not_before) > new Date()) {
+    return `${task.status} (at ${formatTime(task.not_before)})`;
+  }
+  if (task.status === 'WAITING' && task.depends_on && task.depends_on.length > 0) {
+    return `${task.status} (on ${task.depends_on.map((id) => '#' + id).join(', ')})`;
+  }
+  return task.status;
+}
+
+function button(label, onClick) {
+  const b = document.createElement('button');
+  b.textContent = label;
+  b.addEventListener('click', (e) => {
+    e.stopPropagation();
+    onClick().catch(showError);
+  });
+  return b;
+}
+
+function cell(text, className) {
+  const td = document.createElement('td');
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function renderTasks(tasks) {
+  const rows = document.getElementById('task-rows');
+  rows.replaceChildren();
+  tasks.sort((a, b) => Number(b.id) - Number(a.id));
+  for (const task of tasks) {
+    const tr = document.createElement('tr');
+    if (task.id === selectedTaskId) {
+      tr.className = 'selected';
+    }
+    tr.appendChild(cell(task.id));
+    tr.appendChild(cell(statusText(task), 'status ' + task.status));
+    tr.appendChild(cell(isFinal(task) ? task.return_code : ''));
+    tr.appendChild(cell(task.commandline, 'command'));
+    tr.appendChild(cell(task.working_directory, 'directory'));
+    tr.appendChild(cell(formatTime(task.create_time)));
+
+    const actions = cell('', 'actions');
+    actions.appendChild(button('Output', () => showOutput(task.id)));
+    if (!isFinal(task)) {
+      actions.appendChild(button('Cancel', () => cancelTask(task.id)));
+    }
+    actions.appendChild(button('Rerun', () => rerunTask(task.id)));
+    actions.appendChild(button('Delete', () => deleteTask(task.id)));
+    tr.appendChild(actions);
+
+    tr.addEventListener('click', () => showOutput(task.id).catch(showError));
+    rows.appendChild(tr);
+  }
+}
+
+async function refresh() {
+  const res = await api('GET', '/api/tasks');
+  renderTasks(res.tasks);
+}
+
+// renderSearchResults lists the tasks matching a search with the lines of
+// their output that match
+function renderSearchResults(query, results) {
+  const list = document.getElementById('search-results');
+  list.replaceChildren();
+  list.hidden = false;
+  if (results.length === 0) {
+    const li = document.createElement('li');
+    li.textContent = `No task matches ${query}`;
+    list.appendChild(li);
+    return;
+  }
+  for (const result of results) {
+    const task = result.task;
+    const li = document.createElement('li');
+    const title = document.createElement('a');
+    title.href = '#';
+    title.textContent = `#${task.id} ${task.commandline}`;
+    title.addEventListener('click', (e) => {
+      e.preventDefault();
+      showOutput(task.id).catch(showError);
+    });
+    const status = document.createElement('span');
+    status.className = 'status ' + task.status;
+    status.textContent = ` ${task.status} (exit code ${task.return_code})`;
+    li.append(title, status);
+    for (const line of result.lines) {
+      const pre = document.createElement('pre');
+      pre.textContent = `${String(line.number).padStart(6)}: ${line.text}`;
+      li.appendChild(pre);
+    }
+    list.appendChild(li);
+  }
+}
+
+async function searchTasks(query) {
+  if (query.trim() === '') {
+    document.getElementById('search-results').hidden = true;
+    return;
+  }
+  const res = await api('GET', '/api/search?q=' + encodeURIComponent(query));
+  renderSearchResults(query, res.results);
+}
+
+// postTask posts to an endpoint creating a task. If the task fails
+// verification, the user is asked whether to create it anyway.
+async function postTask(path, body) {
+  try {
+    return await api('POST', path, body);
+  } catch (err) {
+    const problems = verificationProblems(err);
+    if (problems.length === 0 || !confirm(`The task failed verification:\n\n${problems.join('\n')}\n\nCreate it anyway?`)) {
+      throw err;
+    }
+    return await api('POST', path + '?force=true', body);
+  }
+}
+
+async function createTask(task) {
+  const res = await postTask('/api/tasks', task);
+  await refresh();
+  await showOutput(res.task.id);
+}
+
+async function cancelTask(id) {
+  await api('POST', `/api/tasks/${id}/cancel`);
+  await refresh();
+}
+
+async function rerunTask(id) {
+  const res = await postTask(`/api/tasks/${id}/rerun`);
+  await refresh();
+  await showOutput(res.task.id);
+}
+
+async function deleteTask(id) {
+  if (!confirm(`Delete task ${id}?`)) {
+    return;
+  }
+  await api('DELETE', `/api/tasks/${id}`);
+  if (id === selectedTaskId) {
+    closeOutput();
+  }
+  await refresh();
+}
+
+function closeOutput() {
+  if (outputSocket) {
+    outputSocket.onclose = null;
+    outputSocket.close();
+    outputSocket = null;
+  }
+  selectedTaskId = null;
+  document.getElementById('output').hidden = true;
+  document.getElementById('output-text').classList.remove('interactive');
+}
+
+function showStatus(task) {
+  const status = document.getElementById('output-status');
+  status.textContent = isFinal(task) ? `${task.status} (exit code ${task.return_code})` : task.status;
+  status.className = 'status ' + task.status;
+
+  const text = document.getElementById('output-text');
+  const interactive = task.interactive && !isFinal(task);
+  if (interactive && !text.classList.contains('interactive')) {
+    text.focus();
+    sendTerminalSize();
+  }
+  text.classList.toggle('interactive', interactive);
+}
+
+// appendTerminal appends output to the terminal view. Escape sequences are
+// dropped and backspaces applied, which is enough for prompts and shells.
+function appendTerminal(text, output) {
+  output = output.replace(/\x1b\[[0-?]*[ -\/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]/g, '');
+  let content = text.textContent;
+  for (const c of output) {
+    if (c === '\b') {
+      content = content.slice(0, -1);
+    } else if (c !== '\r') {
+      content += c;
+    }
+  }
+  text.textContent = content;
+}
+
+// keyInput returns what typing the key sends to an interactive task
+function keyInput(e) {
+  if (e.ctrlKey && e.key.length === 1) {
+    const code = e.key.toUpperCase().charCodeAt(0);
+    if (code >= 64 && code < 96) {
+      return String.fromCharCode(code - 64);
+    }
+  }
+  if (KEY_INPUT[e.key]) {
+    return KEY_INPUT[e.key];
+  }
+  if (e.key.length === 1 && !e.metaKey) {
+    return e.key;
+  }
+  return null;
+}
+
+function sendTerminalSize() {
+  if (!outputSocket || outputSocket.readyState !== WebSocket.OPEN) {
+    return;
+  }
+  const text = document.getElementById('output-text');
+  const probe = document.createElement('span');
+  probe.textContent = 'X';
+  text.appendChild(probe);
+  const rect = probe.getBoundingClientRect();
+  probe.remove();
+  const style = getComputedStyle(text);
+  const width = text.clientWidth - parseFloat(style.paddingLeft) - parseFloat(style.paddingRight);
+  const height = text.clientHeight - parseFloat(style.paddingTop) - parseFloat(style.paddingBottom);
+  const resize = {
+    rows: Math.max(1, Math.floor(height / rect.height)),
+    cols: Math.max(1, Math.floor(width / rect.width)),
+  };
+  outputSocket.send(JSON.stringify({ resize: resize }));
+}
+
+// showOutput opens a terminal view on the task. The server pushes the output
+// as it is written and every status change until the task is finished.
+async function showOutput(id) {
+  closeOutput();
+  selectedTaskId = id;
+
+  const section = document.getElementById('output');
+  const text = document.getElementById('output-text');
+  document.getElementById('output-task-id').textContent = id;
+  document.getElementById('output-status').textContent = '';
+  text.textContent = '';
+  section.hidden = false;
+
+  const scheme = location.protocol === 'https:' ? 'wss' : 'ws';
+  const socket = new WebSocket(`${scheme}://${location.host}/api/tasks/${id}/ws`);
+  socket.binaryType = 'arraybuffer';
+  outputSocket = socket;
+  const decoder = new TextDecoder();
+
+  socket.onmessage = (e) => {
+    if (typeof e.data !== 'string') {
+      const atBottom = text.scrollTop + text.clientHeight >= text.scrollHeight - 4;
+      appendTerminal(text, decoder.decode(new Uint8Array(e.data), { stream: true }));
+      if (atBottom) {
+        text.scrollTop = text.scrollHeight;
+      }
+      return;
+    }
+    const msg = JSON.parse(e.data);
+    if (msg.task) {
+      // a TaskEvent
+      showStatus(msg.task);
+      refresh().catch(showError);
+    } else {
+      // a status describing why the stream ended
+      showError(new Error(msg.message));
+    }
+  };
+  socket.onclose = () => {
+    if (outputSocket === socket) {
+      outputSocket = null;
+    }
+  };
+}
+
+document.getElementById('output-text').addEventListener('keydown', (e) => {
+  const text = e.currentTarget;
+  if (!text.classList.contains('interactive') || !outputSocket) {
+    return;
+  }
+  const input = keyInput(e);
+  if (input === null) {
+    return;
+  }
+  e.preventDefault();
+  outputSocket.send(new TextEncoder().encode(input));
+});
+
+document.getElementById('output-text').addEventListener('paste', (e) => {
+  const text = e.currentTarget;
+  if (!text.classList.contains('interactive') || !outputSocket) {
+    return;
+  }
+  e.preventDefault();
+  outputSocket.send(new TextEncoder().encode(e.clipboardData.getData('text')));
+});
+
+window.addEventListener('resize', sendTerminalSize);
+
+document.getElementById('new-task-form').addEventListener('submit', (e) => {
+  e.preventDefault();
+  showError(null);
+  const task = {
+    commandline: document.getElementById('commandline').value,
+    working_directory: document.getElementById('working-directory').value,
+    interactive: document.getElementById('interactive').checked,
+  };
+  localStorage.setItem('working_directory', task.working_directory);
+  createTask(task).catch(showError);
+});
+
+document.getElementById('search-form').addEventListener('submit', (e) => {
+  e.preventDefault();
+  showError(null);
+  searchTasks(document.getElementById('search-query').value).catch(showError);
+});
+
+document.getElementById('working-directory').value = localStorage.getItem('working_directory') || '';
+
+refresh().catch(showError);
+setInterval(() => refresh().catch(showError), REFRESH_INTERVAL);
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// newWebUI serves the browser UI. The UI talks to the HTTP gateway, which is
+// mounted under /api/ by newHTTPHandler.
+func newWebUI() http.Handler {
+	root, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
+
+// newHTTPHandler combines the HTTP gateway and the browser UI, answering
+// only to localhost and the hosts given
+func newHTTPHandler(gateway http.Handler, hosts []string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/", gateway)
+	mux.Handle("/", newWebUI())
+	return allowedHosts(mux, hosts)
+}
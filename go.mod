@@ -10,19 +10,32 @@ replace internal/service => ./internal/service
 
 replace internal/runner => ./internal/runner
 
+replace internal/scheduler => ./internal/scheduler
+
+replace internal/verifier => ./internal/verifier
+
+replace internal/outputlog => ./internal/outputlog
+
 require (
+	golang.org/x/net v0.29.0
+	golang.org/x/term v0.24.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240903143218-8af14fe29dc1
 	google.golang.org/grpc v1.68.1
+	google.golang.org/protobuf v1.36.1
 	internal/db v1.0.0
 	internal/pb v1.0.0
-	internal/service v1.0.0
 	internal/runner v1.0.0
+	internal/scheduler v1.0.0
+	internal/service v1.0.0
+	internal/verifier v1.0.0
 )
 
 require (
+	github.com/creack/pty v1.1.24 // indirect
 	github.com/mattn/go-sqlite3 v1.14.16 // indirect
-	golang.org/x/net v0.29.0 // indirect
+	github.com/robfig/cron/v3 v3.0.1 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/text v0.18.0 // indirect
-	google.golang.org/genproto/googleapis/rpc v0.0.0-20240903143218-8af14fe29dc1 // indirect
-	google.golang.org/protobuf v1.36.1 // indirect
+	internal/outputlog v1.0.0 // indirect
+	mvdan.cc/sh/v3 v3.7.0 // indirect
 )
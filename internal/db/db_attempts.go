@@ -0,0 +1,151 @@
+package db
+
+import (
+	"fmt"
+	"internal/pb"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	SQL_CREATE_ATTEMPTS_TABLE = `CREATE TABLE IF NOT EXISTS task_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		attempt INTEGER NOT NULL,
+		status INTEGER,
+		return_code INTEGER,
+		output TEXT,
+		start_time DATETIME,
+		finish_time DATETIME,
+		execution_time INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS task_attempts_task_id ON task_attempts (task_id, attempt);`
+
+	SQL_INSERT_TASK_ATTEMPT = `INSERT INTO task_attempts (task_id, attempt, status, return_code, output, start_time, finish_time, execution_time)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	SQL_QUERY_TASK_ATTEMPTS = `SELECT id, task_id, attempt, status, return_code, output, start_time, finish_time, execution_time
+	FROM task_attempts WHERE task_id = ? ORDER BY attempt, id`
+
+	SQL_DELETE_TASK_ATTEMPTS = `DELETE FROM task_attempts WHERE task_id = ?`
+)
+
+type taskAttempt struct {
+	ID            int64
+	TaskID        int64
+	Attempt       int32
+	Status        pb.TaskStatus
+	ReturnCode    int32
+	Output        string
+	StartTime     time.Time
+	FinishTime    time.Time
+	ExecutionTime time.Duration
+}
+
+func (a *taskAttempt) ToProto() *pb.TaskAttempt {
+	pbAttempt := &pb.TaskAttempt{
+		Id:         a.ID,
+		TaskId:     a.TaskID,
+		Attempt:    a.Attempt,
+		Status:     a.Status,
+		ReturnCode: a.ReturnCode,
+		Output:     a.Output,
+	}
+
+	if !a.StartTime.IsZero() {
+		pbAttempt.StartTime = timestamppb.New(a.StartTime)
+	}
+	if !a.FinishTime.IsZero() {
+		pbAttempt.FinishTime = timestamppb.New(a.FinishTime)
+	}
+	if a.ExecutionTime != 0 {
+		pbAttempt.ExecutionTime = durationpb.New(a.ExecutionTime)
+	}
+
+	return pbAttempt
+}
+
+func TaskAttemptFromProto(pbAttempt *pb.TaskAttempt) *taskAttempt {
+	a := &taskAttempt{
+		ID:         pbAttempt.Id,
+		TaskID:     pbAttempt.TaskId,
+		Attempt:    pbAttempt.Attempt,
+		Status:     pbAttempt.Status,
+		ReturnCode: pbAttempt.ReturnCode,
+		Output:     pbAttempt.Output,
+	}
+
+	if pbAttempt.StartTime != nil {
+		a.StartTime = pbAttempt.StartTime.AsTime()
+	}
+	if pbAttempt.FinishTime != nil {
+		a.FinishTime = pbAttempt.FinishTime.AsTime()
+	}
+	if pbAttempt.ExecutionTime != nil {
+		a.ExecutionTime = pbAttempt.ExecutionTime.AsDuration()
+	}
+
+	return a
+}
+
+// CreateTaskAttempt records a finished execution of a task
+func (database *TaskDatabaseImpl) CreateTaskAttempt(pbAttempt *pb.TaskAttempt) (*pb.TaskAttempt, error) {
+	a := TaskAttemptFromProto(pbAttempt)
+	result, err := database.db.Exec(SQL_INSERT_TASK_ATTEMPT,
+		a.TaskID,
+		a.Attempt,
+		a.Status,
+		a.ReturnCode,
+		a.Output,
+		a.StartTime,
+		a.FinishTime,
+		a.ExecutionTime,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("CreateTaskAttempt: %v", err)
+	}
+
+	a.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("CreateTaskAttempt: get last insert ID: %v", err)
+	}
+
+	return a.ToProto(), nil
+}
+
+// GetTaskAttempts returns the recorded attempts of a task, oldest first
+func (database *TaskDatabaseImpl) GetTaskAttempts(taskID int64) ([]*pb.TaskAttempt, error) {
+	rows, err := database.db.Query(SQL_QUERY_TASK_ATTEMPTS, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("GetTaskAttempts: %v", err)
+	}
+	defer rows.Close()
+
+	var attempts []*pb.TaskAttempt
+	for rows.Next() {
+		var a taskAttempt
+		err := rows.Scan(
+			&a.ID,
+			&a.TaskID,
+			&a.Attempt,
+			&a.Status,
+			&a.ReturnCode,
+			&a.Output,
+			&a.StartTime,
+			&a.FinishTime,
+			&a.ExecutionTime,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("GetTaskAttempts: %v", err)
+		}
+		attempts = append(attempts, a.ToProto())
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetTaskAttempts: %v", err)
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	SQL_CREATE_SCHEMA_VERSION_TABLE = `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT '',
+		applied_time DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	SQL_QUERY_SCHEMA_VERSION = `SELECT COALESCE(MAX(version), 0) FROM schema_version`
+
+	SQL_QUERY_APPLIED_MIGRATIONS = `SELECT version, applied_time FROM schema_version`
+
+	SQL_INSERT_SCHEMA_VERSION = `INSERT INTO schema_version (version, description) VALUES (?, ?)`
+
+	// SQL_CREATE_ORIGINAL_TASKS_TABLE is the first schema of the tasks table,
+	// which later migrations add columns to
+	SQL_CREATE_ORIGINAL_TASKS_TABLE = `CREATE TABLE IF NOT EXISTS tasks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		status INTEGER,
+		commandline TEXT,
+		return_code INTEGER,
+		start_time DATETIME,
+		finish_time DATETIME,
+		execution_time INTEGER,
+		working_directory TEXT,
+		output TEXT,
+		create_time DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+)
+
+// migrationStep changes the schema inside the transaction of its migration.
+// Steps must be idempotent: databases created before the schema_version
+// table existed have no version recorded, so every migration is applied to
+// them on top of the tables they already have.
+type migrationStep func(tx *sql.Tx) error
+
+type migration struct {
+	description string
+	steps       []migrationStep
+}
+
+// migrations are the versions of the schema; version N is migrations[N-1].
+// A migration must not change once released: change the schema by
+// appending a migration.
+var migrations = []migration{
+	{"create the tasks table", []migrationStep{
+		execSQL(SQL_CREATE_ORIGINAL_TASKS_TABLE),
+	}},
+	{"add task priorities", []migrationStep{
+		addColumn("tasks", "priority", "INTEGER NOT NULL DEFAULT 0"),
+		execSQL(`CREATE INDEX IF NOT EXISTS tasks_queue ON tasks (status, priority DESC, create_time, id);`),
+	}},
+	{"add task timeouts", []migrationStep{
+		addColumn("tasks", "timeout", "INTEGER NOT NULL DEFAULT 0"),
+	}},
+	{"add task environments and shells", []migrationStep{
+		addColumn("tasks", "env", "TEXT NOT NULL DEFAULT '{}'"),
+		addColumn("tasks", "clear_env", "INTEGER NOT NULL DEFAULT 0"),
+		addColumn("tasks", "shell", "INTEGER NOT NULL DEFAULT 0"),
+		addColumn("tasks", "argv", "TEXT NOT NULL DEFAULT '[]'"),
+	}},
+	{"add recovery policies and pids", []migrationStep{
+		addColumn("tasks", "recovery_policy", "INTEGER NOT NULL DEFAULT 0"),
+		addColumn("tasks", "pid", "INTEGER NOT NULL DEFAULT 0"),
+	}},
+	{"add retries and task attempts", []migrationStep{
+		addColumn("tasks", "retry_policy", "TEXT NOT NULL DEFAULT ''"),
+		addColumn("tasks", "attempt", "INTEGER NOT NULL DEFAULT 0"),
+		execSQL(SQL_CREATE_ATTEMPTS_TABLE),
+	}},
+	{"add interactive tasks", []migrationStep{
+		addColumn("tasks", "interactive", "INTEGER NOT NULL DEFAULT 0"),
+	}},
+	{"add schedules", []migrationStep{
+		addColumn("tasks", "schedule_id", "INTEGER NOT NULL DEFAULT 0"),
+		execSQL(SQL_CREATE_SCHEDULES_TABLE),
+	}},
+	{"add delayed tasks", []migrationStep{
+		addColumn("tasks", "not_before", "DATETIME"),
+	}},
+	{"add templates", []migrationStep{
+		execSQL(SQL_CREATE_TEMPLATES_TABLE),
+	}},
+	{"add task dependencies and pipelines", []migrationStep{
+		addColumn("tasks", "depends_on", "TEXT NOT NULL DEFAULT '[]'"),
+		addColumn("tasks", "pipeline_id", "INTEGER NOT NULL DEFAULT 0"),
+		execSQL(SQL_CREATE_PIPELINES_TABLE),
+		execSQL(`CREATE INDEX IF NOT EXISTS tasks_pipeline ON tasks (pipeline_id);`),
+	}},
+	{"add reruns", []migrationStep{
+		addColumn("tasks", "rerun_of", "INTEGER NOT NULL DEFAULT 0"),
+	}},
+	{"add indexes for task queries", []migrationStep{
+		execSQL(SQL_CREATE_QUERY_INDEXES),
+	}},
+	{"add pinned tasks", []migrationStep{
+		addColumn("tasks", "pinned", "INTEGER NOT NULL DEFAULT 0"),
+	}},
+	{"add output size limits", []migrationStep{
+		addColumn("tasks", "max_output_bytes", "INTEGER NOT NULL DEFAULT 0"),
+	}},
+	{"add the output search index", []migrationStep{
+		execSQL(SQL_CREATE_OUTPUT_INDEX),
+	}},
+}
+
+func execSQL(query string) migrationStep {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// addColumn adds a column to a table unless it has it already
+func addColumn(table, column, definition string) migrationStep {
+	return func(tx *sql.Tx) error {
+		exists, err := hasColumn(tx, table, column)
+		if err != nil || exists {
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+		return err
+	}
+}
+
+func hasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// LatestSchemaVersion is the version Init migrates the database to
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// MigrationStatus describes a migration and whether the database has it
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     bool
+	// AppliedTime is zero if the migration is not applied
+	AppliedTime time.Time
+}
+
+// SchemaVersion returns the version of the schema of the database, 0 if no
+// migration was applied
+func (database *TaskDatabaseImpl) SchemaVersion() (int, error) {
+	if _, err := database.db.Exec(SQL_CREATE_SCHEMA_VERSION_TABLE); err != nil {
+		return 0, fmt.Errorf("SchemaVersion: %v", err)
+	}
+	var version int
+	if err := database.db.QueryRow(SQL_QUERY_SCHEMA_VERSION).Scan(&version); err != nil {
+		return 0, fmt.Errorf("SchemaVersion: %v", err)
+	}
+	return version, nil
+}
+
+// MigrationStatus lists every migration known to this build
+func (database *TaskDatabaseImpl) MigrationStatus() ([]MigrationStatus, error) {
+	if _, err := database.db.Exec(SQL_CREATE_SCHEMA_VERSION_TABLE); err != nil {
+		return nil, fmt.Errorf("MigrationStatus: %v", err)
+	}
+	rows, err := database.db.Query(SQL_QUERY_APPLIED_MIGRATIONS)
+	if err != nil {
+		return nil, fmt.Errorf("MigrationStatus: %v", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedTime time.Time
+		if err := rows.Scan(&version, &appliedTime); err != nil {
+			return nil, fmt.Errorf("MigrationStatus: %v", err)
+		}
+		applied[version] = appliedTime
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("MigrationStatus: %v", err)
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for i, m := range migrations {
+		t, ok := applied[i+1]
+		statuses = append(statuses, MigrationStatus{Version: i + 1, Description: m.description, Applied: ok, AppliedTime: t})
+	}
+	return statuses, nil
+}
+
+// Migrate applies the migrations up to the version, each in a transaction of
+// its own. Migrations cannot be reverted.
+func (database *TaskDatabaseImpl) Migrate(to int) error {
+	if to < 0 || to > len(migrations) {
+		return fmt.Errorf("Migrate: unknown schema version %d, the latest is %d", to, len(migrations))
+	}
+	current, err := database.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("Migrate: the database has schema version %d, newer than the latest known version %d", current, len(migrations))
+	}
+	if current > to {
+		return fmt.Errorf("Migrate: the database has schema version %d, migrations to older versions are not supported", current)
+	}
+
+	for version := current + 1; version <= to; version++ {
+		if err := database.applyMigration(version); err != nil {
+			return fmt.Errorf("Migrate: version %d (%s): %v", version, migrations[version-1].description, err)
+		}
+	}
+	return nil
+}
+
+func (database *TaskDatabaseImpl) applyMigration(version int) error {
+	m := migrations[version-1]
+	tx, err := database.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, step := range m.steps {
+		if err := step(tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(SQL_INSERT_SCHEMA_VERSION, version, m.description); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,128 @@
+package db_test
+
+import (
+	"database/sql"
+	"db"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"internal/pb"
+)
+
+// newFixtureDatabase creates a database from a SQL file in testdata
+func newFixtureDatabase(t *testing.T, fixture string) db.TaskDatabase {
+	script, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("cannot read fixture: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("cannot open fixture database: %v", err)
+	}
+	if _, err := raw.Exec(string(script)); err != nil {
+		t.Fatalf("cannot load fixture: %v", err)
+	}
+	raw.Close()
+
+	database, err := db.NewTaskDatabase(path)
+	if err != nil {
+		t.Fatalf("db.NewTaskDatabase() should not return error, but got %v", err)
+	}
+	t.Cleanup(func() { database.Uninit() })
+	return database
+}
+
+func TestMigrateOriginalSchema(t *testing.T) {
+	database := newFixtureDatabase(t, "original_schema.sql")
+
+	version, err := database.SchemaVersion()
+	if err != nil || version != 0 {
+		t.Fatalf("expect an unversioned database, but got version %d, %v", version, err)
+	}
+
+	if err := database.Migrate(3); err != nil {
+		t.Fatalf("expect to migrate to version 3, but got error: %v", err)
+	}
+	statuses, err := database.MigrationStatus()
+	if err != nil {
+		t.Fatalf("expect the migration status, but got error: %v", err)
+	}
+	if len(statuses) != db.LatestSchemaVersion() {
+		t.Fatalf("expect %d migrations, but got %d", db.LatestSchemaVersion(), len(statuses))
+	}
+	for _, s := range statuses {
+		if s.Applied != (s.Version <= 3) {
+			t.Errorf("expect only the migrations up to 3 to be applied, but got %+v", s)
+		}
+	}
+
+	if err := database.Init(); err != nil {
+		t.Fatalf("expect Init() to migrate to the latest version, but got error: %v", err)
+	}
+	version, err = database.SchemaVersion()
+	if err != nil || version != db.LatestSchemaVersion() {
+		t.Errorf("expect version %d, but got %d, %v", db.LatestSchemaVersion(), version, err)
+	}
+
+	old, err := database.GetTask(1)
+	if err != nil {
+		t.Fatalf("expect to read a task of the original schema, but got error: %v", err)
+	}
+	if old.Commandline != "make test" || old.Status != pb.TaskStatus_FINISHED || old.ExecutionTime.AsDuration().Seconds() != 3 {
+		t.Errorf("expect the task to be kept, but got %v", old)
+	}
+	if old.Priority != 0 || len(old.Env) != 0 || old.Shell != pb.Shell_SH || old.NotBefore != nil || len(old.DependsOn) != 0 {
+		t.Errorf("expect the new columns to have their defaults, but got %v", old)
+	}
+	next, err := database.ClaimNextTask()
+	if err != nil || next.Id != 2 {
+		t.Errorf("expect to claim the NEW task of the original schema, but got %v, %v", next, err)
+	}
+	created, err := database.CreateTask(&pb.Task{Commandline: "make", Priority: 1, DependsOn: []int64{1}})
+	if err != nil || created.Id != 3 {
+		t.Errorf("expect to create a task after the migration, but got %v, %v", created, err)
+	}
+
+	if err := database.Migrate(1); err == nil {
+		t.Error("expect an error when migrating to an older version")
+	}
+	if err := database.Migrate(db.LatestSchemaVersion() + 1); err == nil {
+		t.Error("expect an error when migrating to an unknown version")
+	}
+}
+
+// Databases created by builds that had all the tables but no schema_version
+// are migrated over the tables they have
+func TestMigrateUnversionedSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	database, err := db.NewTaskDatabase(path)
+	if err != nil {
+		t.Fatalf("db.NewTaskDatabase() should not return error, but got %v", err)
+	}
+	defer database.Uninit()
+	if err := database.Init(); err != nil {
+		t.Fatalf("Init() should not return error, but got %v", err)
+	}
+	if _, err := database.CreateTask(&pb.Task{Commandline: "true", Priority: 2}); err != nil {
+		t.Fatalf("should create task but got error: %v", err)
+	}
+
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	defer raw.Close()
+	if _, err := raw.Exec("DROP TABLE schema_version"); err != nil {
+		t.Fatalf("cannot drop schema_version: %v", err)
+	}
+
+	if err := database.Init(); err != nil {
+		t.Fatalf("expect Init() to migrate an unversioned database, but got error: %v", err)
+	}
+	task, err := database.GetTask(1)
+	if err != nil || task.Priority != 2 {
+		t.Errorf("expect the task to be kept, but got %v, %v", task, err)
+	}
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"internal/pb"
 
@@ -12,12 +13,43 @@ import (
 type TaskDatabase interface {
 	Init() error
 	Uninit() error
+	SchemaVersion() (int, error)
+	MigrationStatus() ([]MigrationStatus, error)
+	Migrate(to int) error
 	GetTasks() ([]*pb.Task, error)
+	GetTasksByStatus(status pb.TaskStatus) ([]*pb.Task, error)
+	QueryTasks(query TaskQuery) ([]*pb.Task, error)
 	GetTask(id int64) (*pb.Task, error)
 	DeleteTask(id int64) error
 	CreateTask(task *pb.Task) (*pb.Task, error)
 	UpdateTask(task *pb.Task) (*pb.Task, error)
-	GetLatestTask() (*pb.Task, error)
+	GetNextTask() (*pb.Task, error)
+	UpdateTaskStatus(id int64, from pb.TaskStatus, to pb.TaskStatus) (bool, error)
+	UpdateTaskPriority(id int64, priority int32) (bool, error)
+	UpdateTaskPinned(id int64, pinned bool) error
+	ClaimNextTask() (*pb.Task, error)
+	GetNextNotBefore() (time.Time, error)
+	CreateTaskAttempt(attempt *pb.TaskAttempt) (*pb.TaskAttempt, error)
+	GetTaskAttempts(taskID int64) ([]*pb.TaskAttempt, error)
+	CreateSchedule(schedule *pb.Schedule) (*pb.Schedule, error)
+	GetSchedules() ([]*pb.Schedule, error)
+	GetSchedule(id int64) (*pb.Schedule, error)
+	UpdateSchedule(schedule *pb.Schedule) (*pb.Schedule, error)
+	UpdateScheduleRunTimes(id int64, next time.Time, last time.Time) error
+	UpdateSchedulePaused(id int64, paused bool) error
+	UpdateScheduleResumed(id int64, next time.Time) error
+	DeleteSchedule(id int64) error
+	CreateTemplate(template *pb.Template) (*pb.Template, error)
+	GetTemplates() ([]*pb.Template, error)
+	GetTemplate(name string) (*pb.Template, error)
+	UpdateTemplate(template *pb.Template) (*pb.Template, error)
+	DeleteTemplate(name string) error
+	CreatePipeline(name string, tasks []*pb.PipelineTask) (*pb.Pipeline, error)
+	GetPipeline(id int64) (*pb.Pipeline, error)
+	GetTasksByPipeline(pipelineID int64) ([]*pb.Task, error)
+	IndexTaskOutput(id int64, commandline string, output string) error
+	GetUnindexedTasks() ([]*pb.Task, error)
+	SearchTasks(query string, limit int) ([]*pb.TaskSearchResult, error)
 }
 
 type TaskDatabaseImpl struct {
@@ -35,6 +67,9 @@ func NewTaskDatabase(dbPath string) (TaskDatabase, error) {
 	if err != nil {
 		return nil, err
 	}
+	// SQLite allows a single writer; sharing one connection avoids "database
+	// is locked" errors when several runners update tasks at the same time
+	db.SetMaxOpenConns(1)
 	return &TaskDatabaseImpl{db}, nil
 }
 
@@ -43,20 +78,9 @@ func NewMockTaskDatabase() (TaskDatabase, error) {
 }
 
 const (
-	SQL_CREATE_TABLE = `CREATE TABLE IF NOT EXISTS tasks (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		status INTEGER,
-		commandline TEXT,
-		return_code INTEGER,
-		start_time DATETIME,
-		finish_time DATETIME,
-		execution_time INTEGER,
-		working_directory TEXT,
-		output TEXT,
-		create_time DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	SQL_QUERY_ONE_TASK = `SELECT
+	// SQL_TASK_COLUMNS lists the columns read into a task, in the order of
+	// task.fields()
+	SQL_TASK_COLUMNS = `
 		id,
 		status,
 		commandline,
@@ -66,22 +90,37 @@ const (
 		execution_time,
 		working_directory,
 		create_time,
-		output
+		output,
+		priority,
+		timeout,
+		env,
+		clear_env,
+		shell,
+		argv,
+		recovery_policy,
+		pid,
+		retry_policy,
+		attempt,
+		interactive,
+		schedule_id,
+		not_before,
+		depends_on,
+		pipeline_id,
+		rerun_of,
+		pinned,
+		max_output_bytes`
+
+	// SQL_QUEUE_ORDER is the order in which NEW tasks are dequeued: higher
+	// priority first, then oldest first
+	SQL_QUEUE_ORDER = `ORDER BY priority DESC, create_time ASC, id ASC`
+
+	SQL_QUERY_ONE_TASK = `SELECT` + SQL_TASK_COLUMNS + `
 	FROM tasks WHERE id = ?`
 
-	SQL_QUERY_TASKS = `SELECT
-		id,
-		status,
-		commandline,
-		return_code,
-		start_time,
-		finish_time,
-		execution_time,
-		working_directory,
-		create_time,
-		output
+	SQL_QUERY_TASKS = `SELECT` + SQL_TASK_COLUMNS + `
 	FROM tasks`
 
+	// the columns written from a task, in the order of task.values()
 	SQL_UPDATE_TASK = `UPDATE tasks SET
 		status = ?,
 		commandline = ?,
@@ -90,28 +129,59 @@ const (
 		finish_time = ?,
 		execution_time = ?,
 		working_directory = ?,
-		output = ?
+		output = ?,
+		priority = ?,
+		timeout = ?,
+		env = ?,
+		clear_env = ?,
+		shell = ?,
+		argv = ?,
+		recovery_policy = ?,
+		pid = ?,
+		retry_policy = ?,
+		attempt = ?,
+		interactive = ?,
+		schedule_id = ?,
+		not_before = ?,
+		depends_on = ?,
+		pipeline_id = ?,
+		rerun_of = ?,
+		max_output_bytes = ?
 	WHERE id = ?`
-	SQL_DELETE_TASK = `DELETE FROM tasks WHERE id = ?`
+	SQL_UPDATE_TASK_STATUS   = `UPDATE tasks SET status = ? WHERE id = ? AND status = ?`
+	SQL_UPDATE_TASK_PRIORITY = `UPDATE tasks SET priority = ? WHERE id = ? AND status = ?`
+	SQL_UPDATE_TASK_PINNED   = `UPDATE tasks SET pinned = ? WHERE id = ?`
+	SQL_DELETE_TASK          = `DELETE FROM tasks WHERE id = ?`
 
-	SQL_INSERT_TASK = `INSERT INTO tasks (status, commandline, return_code, start_time, finish_time, execution_time, working_directory, output)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	SQL_INSERT_TASK = `INSERT INTO tasks (status, commandline, return_code, start_time, finish_time, execution_time, working_directory, output, priority, timeout, env, clear_env, shell, argv, recovery_policy, pid, retry_policy, attempt, interactive, schedule_id, not_before, depends_on, pipeline_id, rerun_of, max_output_bytes)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	SQL_QUERY_LATEST_TASK = `SELECT id, status, commandline, return_code, start_time, finish_time, execution_time, working_directory, create_time, output
-	FROM tasks 
-	WHERE status = ? 
-	ORDER BY create_time DESC 
+	SQL_QUERY_TASKS_BY_STATUS = `SELECT` + SQL_TASK_COLUMNS + `
+	FROM tasks WHERE status = ? ORDER BY id`
+
+	// SQL_STARTABLE selects the tasks whose not_before time has come. Times
+	// are stored in UTC, so they compare as strings.
+	SQL_STARTABLE = `(not_before IS NULL OR not_before <= ?)`
+
+	SQL_QUERY_NEXT_TASK = `SELECT` + SQL_TASK_COLUMNS + `
+	FROM tasks
+	WHERE status = ? AND ` + SQL_STARTABLE + `
+	` + SQL_QUEUE_ORDER + `
 	LIMIT 1`
+
+	SQL_CLAIM_NEXT_TASK = `UPDATE tasks SET status = ?
+	WHERE id = (SELECT id FROM tasks WHERE status = ? AND ` + SQL_STARTABLE + ` ` + SQL_QUEUE_ORDER + ` LIMIT 1)
+	AND status = ?
+	RETURNING` + SQL_TASK_COLUMNS
+
+	SQL_QUERY_NEXT_NOT_BEFORE = `SELECT not_before FROM tasks
+	WHERE status = ? AND not_before > ?
+	ORDER BY not_before LIMIT 1`
 )
 
+// Init migrates the database to the latest schema version
 func (database *TaskDatabaseImpl) Init() error {
-	// Create a table
-	_, err := database.db.Exec(SQL_CREATE_TABLE)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return database.Migrate(LatestSchemaVersion())
 }
 
 func (database *TaskDatabaseImpl) Uninit() error {
@@ -120,8 +190,17 @@ func (database *TaskDatabaseImpl) Uninit() error {
 }
 
 func (database *TaskDatabaseImpl) GetTasks() ([]*pb.Task, error) {
+	return database.queryTasks(SQL_QUERY_TASKS)
+}
+
+// GetTasksByStatus returns the tasks with the given status in ID order
+func (database *TaskDatabaseImpl) GetTasksByStatus(status pb.TaskStatus) ([]*pb.Task, error) {
+	return database.queryTasks(SQL_QUERY_TASKS_BY_STATUS, status)
+}
+
+func (database *TaskDatabaseImpl) queryTasks(query string, args ...any) ([]*pb.Task, error) {
 	// Execute the query
-	rows, err := database.db.Query(SQL_QUERY_TASKS)
+	rows, err := database.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -131,18 +210,7 @@ func (database *TaskDatabaseImpl) GetTasks() ([]*pb.Task, error) {
 	var tasks []*pb.Task
 	for rows.Next() {
 		var t task
-		err := rows.Scan(
-			&t.ID,
-			&t.Status,
-			&t.Commandline,
-			&t.ReturnCode,
-			&t.StartTime,
-			&t.FinishTime,
-			&t.ExecutionTime,
-			&t.WorkingDirectory,
-			&t.CreateTime,
-			&t.Output,
-		)
+		err := rows.Scan(t.fields()...)
 		if err != nil {
 			return nil, err
 		}
@@ -162,18 +230,11 @@ func (database *TaskDatabaseImpl) GetTask(id int64) (*pb.Task, error) {
 	var t task
 
 	// Query the task
-	err := database.db.QueryRow(SQL_QUERY_ONE_TASK, id).Scan(
-		&t.ID,
-		&t.Status,
-		&t.Commandline,
-		&t.ReturnCode,
-		&t.StartTime,
-		&t.FinishTime,
-		&t.ExecutionTime,
-		&t.WorkingDirectory,
-		&t.CreateTime,
-		&t.Output)
+	err := database.db.QueryRow(SQL_QUERY_ONE_TASK, id).Scan(t.fields()...)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &ErrNoRows{}
+		}
 		return nil, err
 	}
 	return t.ToProto(), nil
@@ -184,22 +245,21 @@ func (database *TaskDatabaseImpl) DeleteTask(id int64) error {
 	if err != nil {
 		return fmt.Errorf("DeleteTask: %v", err)
 	}
+	_, err = database.db.Exec(SQL_DELETE_TASK_ATTEMPTS, id)
+	if err != nil {
+		return fmt.Errorf("DeleteTask: delete attempts: %v", err)
+	}
+	_, err = database.db.Exec(SQL_DELETE_OUTPUT_INDEX, id)
+	if err != nil {
+		return fmt.Errorf("DeleteTask: delete output index: %v", err)
+	}
 
 	return nil
 }
 
 func (database *TaskDatabaseImpl) CreateTask(pbTask *pb.Task) (*pb.Task, error) {
 	t := TaskFromProto(pbTask)
-	result, err := database.db.Exec(SQL_INSERT_TASK,
-		t.Status,
-		t.Commandline,
-		t.ReturnCode,
-		t.StartTime,
-		t.FinishTime,
-		t.ExecutionTime,
-		t.WorkingDirectory,
-		t.Output,
-	)
+	result, err := database.db.Exec(SQL_INSERT_TASK, t.values()...)
 	if err != nil {
 		return nil, fmt.Errorf("CreateTask: %v", err)
 	}
@@ -216,17 +276,7 @@ func (database *TaskDatabaseImpl) CreateTask(pbTask *pb.Task) (*pb.Task, error)
 
 func (database *TaskDatabaseImpl) UpdateTask(pbTask *pb.Task) (*pb.Task, error) {
 	t := TaskFromProto(pbTask)
-	result, err := database.db.Exec(SQL_UPDATE_TASK,
-		t.Status,
-		t.Commandline,
-		t.ReturnCode,
-		t.StartTime,
-		t.FinishTime,
-		t.ExecutionTime,
-		t.WorkingDirectory,
-		t.Output,
-		t.ID,
-	)
+	result, err := database.db.Exec(SQL_UPDATE_TASK, append(t.values(), t.ID)...)
 	if err != nil {
 		return nil, fmt.Errorf("UpdateTask: %v", err)
 	}
@@ -243,26 +293,94 @@ func (database *TaskDatabaseImpl) UpdateTask(pbTask *pb.Task) (*pb.Task, error)
 	return t.ToProto(), nil
 }
 
-func (database *TaskDatabaseImpl) GetLatestTask() (*pb.Task, error) {
+// GetNextTask returns the NEW task that will be dequeued next without
+// claiming it. Tasks whose not_before time has not come are skipped.
+func (database *TaskDatabaseImpl) GetNextTask() (*pb.Task, error) {
+	var t task
+	err := database.db.QueryRow(SQL_QUERY_NEXT_TASK, pb.TaskStatus_NEW, time.Now().UTC()).Scan(t.fields()...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &ErrNoRows{} // No task found
+		}
+		return nil, fmt.Errorf("GetNextTask: %v", err)
+	}
+
+	return t.ToProto(), nil
+}
+
+// UpdateTaskStatus changes the status of the task only if it currently has the
+// status `from`. It reports whether the task was updated.
+func (database *TaskDatabaseImpl) UpdateTaskStatus(id int64, from pb.TaskStatus, to pb.TaskStatus) (bool, error) {
+	result, err := database.db.Exec(SQL_UPDATE_TASK_STATUS, to, id, from)
+	if err != nil {
+		return false, fmt.Errorf("UpdateTaskStatus: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("UpdateTaskStatus: get rows affected: %v", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// ClaimNextTask atomically marks the next NEW task as RUNNING and returns it,
+// so that concurrent runners never pick the same task. Tasks whose not_before
+// time has not come are skipped.
+func (database *TaskDatabaseImpl) ClaimNextTask() (*pb.Task, error) {
 	var t task
-	err := database.db.QueryRow(SQL_QUERY_LATEST_TASK, pb.TaskStatus_NEW).Scan(
-		&t.ID,
-		&t.Status,
-		&t.Commandline,
-		&t.ReturnCode,
-		&t.StartTime,
-		&t.FinishTime,
-		&t.ExecutionTime,
-		&t.WorkingDirectory,
-		&t.CreateTime,
-		&t.Output,
-	)
+	err := database.db.QueryRow(SQL_CLAIM_NEXT_TASK, pb.TaskStatus_RUNNING, pb.TaskStatus_NEW, time.Now().UTC(), pb.TaskStatus_NEW).Scan(t.fields()...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, &ErrNoRows{} // No task found
 		}
-		return nil, fmt.Errorf("GetLatestTask: %v", err)
+		return nil, fmt.Errorf("ClaimNextTask: %v", err)
 	}
 
 	return t.ToProto(), nil
 }
+
+// GetNextNotBefore returns the earliest time a NEW task that is not startable
+// yet may be started
+func (database *TaskDatabaseImpl) GetNextNotBefore() (time.Time, error) {
+	var notBefore time.Time
+	err := database.db.QueryRow(SQL_QUERY_NEXT_NOT_BEFORE, pb.TaskStatus_NEW, time.Now().UTC()).Scan(&notBefore)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, &ErrNoRows{}
+		}
+		return time.Time{}, fmt.Errorf("GetNextNotBefore: %v", err)
+	}
+	return notBefore, nil
+}
+
+// UpdateTaskPriority changes the priority of a task that is still waiting in
+// the queue. It reports whether the task was updated.
+func (database *TaskDatabaseImpl) UpdateTaskPriority(id int64, priority int32) (bool, error) {
+	result, err := database.db.Exec(SQL_UPDATE_TASK_PRIORITY, priority, id, pb.TaskStatus_NEW)
+	if err != nil {
+		return false, fmt.Errorf("UpdateTaskPriority: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("UpdateTaskPriority: get rows affected: %v", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// UpdateTaskPinned pins or unpins a task whatever its status
+func (database *TaskDatabaseImpl) UpdateTaskPinned(id int64, pinned bool) error {
+	result, err := database.db.Exec(SQL_UPDATE_TASK_PINNED, pinned, id)
+	if err != nil {
+		return fmt.Errorf("UpdateTaskPinned: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("UpdateTaskPinned: get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return &ErrNoRows{}
+	}
+	return nil
+}
@@ -5,9 +5,15 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
@@ -129,3 +135,494 @@ func TestSmokeTest(t *testing.T) {
 	}
 
 }
+
+func newTestDatabase(t *testing.T) db.TaskDatabase {
+	database, err := db.NewTaskDatabase(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("db.NewTaskDatabase() should not return error, but got %v", err)
+	}
+	if err := database.Init(); err != nil {
+		t.Fatalf("db.Init() should not return error, but got %v", err)
+	}
+	t.Cleanup(func() { database.Uninit() })
+	return database
+}
+
+func TestUpdateTaskStatus(t *testing.T) {
+	database := newTestDatabase(t)
+
+	task, err := database.CreateTask(&pb.Task{Status: pb.TaskStatus_NEW, Commandline: "ls"})
+	if err != nil {
+		t.Fatalf("should create task but got error: %v", err)
+	}
+
+	updated, err := database.UpdateTaskStatus(task.Id, pb.TaskStatus_RUNNING, pb.TaskStatus_CANCELLED)
+	if err != nil {
+		t.Fatalf("expect to update task status, but got error: %v", err)
+	}
+	if updated {
+		t.Error("expect the task not to be updated from a status it does not have")
+	}
+
+	updated, err = database.UpdateTaskStatus(task.Id, pb.TaskStatus_NEW, pb.TaskStatus_CANCELLED)
+	if err != nil {
+		t.Fatalf("expect to update task status, but got error: %v", err)
+	}
+	if !updated {
+		t.Error("expect the task to be updated")
+	}
+
+	task, err = database.GetTask(task.Id)
+	if err != nil {
+		t.Fatalf("expect to get a task, but got error: %v", err)
+	}
+	if task.Status != pb.TaskStatus_CANCELLED {
+		t.Errorf("expect status to be CANCELLED, but got %s", task.Status.String())
+	}
+}
+
+func TestUpdateTaskPinned(t *testing.T) {
+	database := newTestDatabase(t)
+
+	task, err := database.CreateTask(&pb.Task{Status: pb.TaskStatus_RUNNING, Commandline: "ls"})
+	if err != nil {
+		t.Fatalf("should create task but got error: %v", err)
+	}
+
+	if err := database.UpdateTaskPinned(task.Id, true); err != nil {
+		t.Fatalf("expect to pin the task, but got error: %v", err)
+	}
+	// the runner updates the task it started with, which is not pinned
+	task.Status = pb.TaskStatus_FINISHED
+	if _, err := database.UpdateTask(task); err != nil {
+		t.Fatalf("expect to update the task, but got error: %v", err)
+	}
+
+	task, err = database.GetTask(task.Id)
+	if err != nil {
+		t.Fatalf("expect to get a task, but got error: %v", err)
+	}
+	if !task.Pinned || task.Status != pb.TaskStatus_FINISHED {
+		t.Errorf("expect a pinned FINISHED task, but got pinned %v, %s", task.Pinned, task.Status)
+	}
+
+	if _, ok := database.UpdateTaskPinned(task.Id+1, true).(*db.ErrNoRows); !ok {
+		t.Error("expect ErrNoRows when pinning a task that does not exist")
+	}
+}
+
+func TestUpdateSchedulePaused(t *testing.T) {
+	database := newTestDatabase(t)
+
+	schedule, err := database.CreateSchedule(&pb.Schedule{Name: "nightly", Cron: "0 3 * * *", TaskTemplate: &pb.Task{Commandline: "make"}})
+	if err != nil {
+		t.Fatalf("should create schedule but got error: %v", err)
+	}
+	// the scheduler records a run while the schedule is being paused
+	next := time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC)
+	if err := database.UpdateScheduleRunTimes(schedule.Id, next, next.Add(-24*time.Hour)); err != nil {
+		t.Fatalf("expect to record a run, but got error: %v", err)
+	}
+	if err := database.UpdateSchedulePaused(schedule.Id, true); err != nil {
+		t.Fatalf("expect to pause the schedule, but got error: %v", err)
+	}
+
+	schedule, err = database.GetSchedule(schedule.Id)
+	if err != nil {
+		t.Fatalf("expect to get the schedule, but got error: %v", err)
+	}
+	if !schedule.Paused || !schedule.NextRunTime.AsTime().Equal(next) {
+		t.Errorf("expect a paused schedule next running at %v, but got paused %v, %v", next, schedule.Paused, schedule.NextRunTime.AsTime())
+	}
+
+	if _, ok := database.UpdateSchedulePaused(schedule.Id+1, true).(*db.ErrNoRows); !ok {
+		t.Error("expect ErrNoRows when pausing a schedule that does not exist")
+	}
+
+	// the schedule is renamed while it is being resumed
+	schedule.Name = "nightly build"
+	if _, err := database.UpdateSchedule(schedule); err != nil {
+		t.Fatalf("expect to update the schedule, but got error: %v", err)
+	}
+	resumed := next.Add(48 * time.Hour)
+	if err := database.UpdateScheduleResumed(schedule.Id, resumed); err != nil {
+		t.Fatalf("expect to resume the schedule, but got error: %v", err)
+	}
+
+	schedule, err = database.GetSchedule(schedule.Id)
+	if err != nil {
+		t.Fatalf("expect to get the schedule, but got error: %v", err)
+	}
+	if schedule.Paused || schedule.Name != "nightly build" || !schedule.NextRunTime.AsTime().Equal(resumed) {
+		t.Errorf("expect nightly build resumed at %v, but got %s paused %v, %v", resumed, schedule.Name, schedule.Paused, schedule.NextRunTime.AsTime())
+	}
+
+	if _, ok := database.UpdateScheduleResumed(schedule.Id+1, resumed).(*db.ErrNoRows); !ok {
+		t.Error("expect ErrNoRows when resuming a schedule that does not exist")
+	}
+}
+
+func TestClaimNextTask(t *testing.T) {
+	database := newTestDatabase(t)
+
+	_, err := database.ClaimNextTask()
+	if _, ok := err.(*db.ErrNoRows); !ok {
+		t.Fatalf("expect ErrNoRows from an empty database, but got %v", err)
+	}
+
+	const count = 20
+	for i := 0; i < count; i++ {
+		if _, err := database.CreateTask(&pb.Task{Status: pb.TaskStatus_NEW, Commandline: "ls"}); err != nil {
+			t.Fatalf("should create task but got error: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	claimed := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, err := database.ClaimNextTask()
+				if err != nil {
+					if _, ok := err.(*db.ErrNoRows); !ok {
+						t.Errorf("expect to claim a task, but got error: %v", err)
+					}
+					return
+				}
+				if task.Status != pb.TaskStatus_RUNNING {
+					t.Errorf("expect status to be RUNNING, but got %s", task.Status.String())
+				}
+				mu.Lock()
+				if claimed[task.Id] {
+					t.Errorf("task %d was claimed twice", task.Id)
+				}
+				claimed[task.Id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != count {
+		t.Errorf("expect %d claimed tasks, but got %d", count, len(claimed))
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	database := newTestDatabase(t)
+
+	for _, priority := range []int32{0, 0, 5, 0, 5} {
+		if _, err := database.CreateTask(&pb.Task{Status: pb.TaskStatus_NEW, Commandline: "ls", Priority: priority}); err != nil {
+			t.Fatalf("should create task but got error: %v", err)
+		}
+	}
+
+	updated, err := database.UpdateTaskPriority(4, 10)
+	if err != nil || !updated {
+		t.Fatalf("expect to update the priority, but got %v, %v", updated, err)
+	}
+
+	next, err := database.GetNextTask()
+	if err != nil {
+		t.Fatalf("expect to get the next task, but got error: %v", err)
+	}
+	if next.Id != 4 {
+		t.Errorf("expect task 4 to be next, but got %d", next.Id)
+	}
+
+	for _, expected := range []int64{4, 3, 5, 1, 2} {
+		task, err := database.ClaimNextTask()
+		if err != nil {
+			t.Fatalf("expect to claim a task, but got error: %v", err)
+		}
+		if task.Id != expected {
+			t.Errorf("expect task %d to be claimed, but got %d", expected, task.Id)
+		}
+	}
+
+	updated, err = database.UpdateTaskPriority(4, 1)
+	if err != nil {
+		t.Fatalf("expect no error, but got %v", err)
+	}
+	if updated {
+		t.Error("expect a running task not to be reprioritized")
+	}
+}
+
+func TestNotBefore(t *testing.T) {
+	database := newTestDatabase(t)
+
+	later := time.Now().Add(time.Hour).Truncate(time.Second)
+	soon := time.Now().Add(time.Minute).Truncate(time.Second)
+	for _, notBefore := range []time.Time{later, time.Now().Add(-time.Minute), soon} {
+		_, err := database.CreateTask(&pb.Task{Status: pb.TaskStatus_NEW, Commandline: "ls", NotBefore: timestamppb.New(notBefore)})
+		if err != nil {
+			t.Fatalf("should create task but got error: %v", err)
+		}
+	}
+
+	task, err := database.GetTask(1)
+	if err != nil {
+		t.Fatalf("expect to get task 1, but got error: %v", err)
+	}
+	if !task.NotBefore.AsTime().Equal(later) {
+		t.Errorf("expect not_before %v, but got %v", later, task.NotBefore.AsTime())
+	}
+
+	task, err = database.ClaimNextTask()
+	if err != nil {
+		t.Fatalf("expect to claim a task, but got error: %v", err)
+	}
+	if task.Id != 2 {
+		t.Errorf("expect task 2 to be claimed, but got %d", task.Id)
+	}
+	if _, err := database.ClaimNextTask(); err == nil {
+		t.Error("expect tasks whose time has not come not to be claimed")
+	}
+
+	next, err := database.GetNextNotBefore()
+	if err != nil {
+		t.Fatalf("expect to get the next not_before time, but got error: %v", err)
+	}
+	if !next.Equal(soon) {
+		t.Errorf("expect the next not_before time to be %v, but got %v", soon, next)
+	}
+}
+
+func TestTaskAttempts(t *testing.T) {
+	database := newTestDatabase(t)
+
+	task, err := database.CreateTask(&pb.Task{
+		Status:      pb.TaskStatus_NEW,
+		Commandline: "false",
+		RetryPolicy: &pb.RetryPolicy{MaxAttempts: 3, RetryOnExitCodes: []int32{1}},
+	})
+	if err != nil {
+		t.Fatalf("should create task but got error: %v", err)
+	}
+
+	task, err = database.GetTask(task.Id)
+	if err != nil {
+		t.Fatalf("expect to get a task, but got error: %v", err)
+	}
+	if task.RetryPolicy.GetMaxAttempts() != 3 || len(task.RetryPolicy.GetRetryOnExitCodes()) != 1 {
+		t.Errorf("expect the retry policy to be stored, but got %v", task.RetryPolicy)
+	}
+
+	for i := int32(1); i <= 2; i++ {
+		_, err := database.CreateTaskAttempt(&pb.TaskAttempt{TaskId: task.Id, Attempt: i, Status: pb.TaskStatus_FINISHED, ReturnCode: 1})
+		if err != nil {
+			t.Fatalf("expect to create an attempt, but got error: %v", err)
+		}
+	}
+
+	attempts, err := database.GetTaskAttempts(task.Id)
+	if err != nil {
+		t.Fatalf("expect to get attempts, but got error: %v", err)
+	}
+	if len(attempts) != 2 || attempts[0].Attempt != 1 || attempts[1].Attempt != 2 {
+		t.Errorf("expect attempts 1 and 2, but got %v", attempts)
+	}
+
+	if err := database.DeleteTask(task.Id); err != nil {
+		t.Fatalf("expect to delete a task, but got error: %v", err)
+	}
+	attempts, err = database.GetTaskAttempts(task.Id)
+	if err != nil {
+		t.Fatalf("expect to get attempts, but got error: %v", err)
+	}
+	if len(attempts) != 0 {
+		t.Errorf("expect the attempts to be deleted with the task, but got %d", len(attempts))
+	}
+}
+
+func TestCreatePipeline(t *testing.T) {
+	database := newTestDatabase(t)
+
+	pipeline, err := database.CreatePipeline("release", []*pb.PipelineTask{
+		{Name: "build", Task: &pb.Task{Status: pb.TaskStatus_NEW, Commandline: "make"}},
+		{Name: "lint", Task: &pb.Task{Status: pb.TaskStatus_NEW, Commandline: "make lint"}},
+		{Name: "test", Task: &pb.Task{Status: pb.TaskStatus_WAITING, Commandline: "make test"}, DependsOn: []string{"build", "lint"}},
+	})
+	if err != nil {
+		t.Fatalf("expect to create a pipeline, but got error: %v", err)
+	}
+	if len(pipeline.TaskIds) != 3 || len(pipeline.Tasks) != 3 {
+		t.Fatalf("expect 3 tasks in the pipeline, but got %v", pipeline)
+	}
+
+	test, err := database.GetTask(pipeline.TaskIds["test"])
+	if err != nil {
+		t.Fatalf("expect to get a task, but got error: %v", err)
+	}
+	if test.PipelineId != pipeline.Id || test.Status != pb.TaskStatus_WAITING {
+		t.Errorf("expect a WAITING task of pipeline %d, but got %v", pipeline.Id, test)
+	}
+	if len(test.DependsOn) != 2 || test.DependsOn[0] != pipeline.TaskIds["build"] || test.DependsOn[1] != pipeline.TaskIds["lint"] {
+		t.Errorf("expect the task to depend on build and lint %v, but got %v", pipeline.TaskIds, test.DependsOn)
+	}
+
+	read, err := database.GetPipeline(pipeline.Id)
+	if err != nil {
+		t.Fatalf("expect to get the pipeline, but got error: %v", err)
+	}
+	if read.Name != "release" || read.TaskIds["lint"] != pipeline.TaskIds["lint"] {
+		t.Errorf("expect the pipeline to be stored, but got %v", read)
+	}
+	tasks, err := database.GetTasksByPipeline(pipeline.Id)
+	if err != nil || len(tasks) != 3 {
+		t.Errorf("expect 3 tasks in the pipeline, but got %d, %v", len(tasks), err)
+	}
+
+	// a task depending on a task that comes after it fails the whole pipeline
+	_, err = database.CreatePipeline("broken", []*pb.PipelineTask{
+		{Name: "first", Task: &pb.Task{Commandline: "true"}},
+		{Name: "second", Task: &pb.Task{Commandline: "true"}, DependsOn: []string{"third"}},
+		{Name: "third", Task: &pb.Task{Commandline: "true"}},
+	})
+	if err == nil {
+		t.Fatal("expect an error for a task depending on a later task")
+	}
+	all, err := database.GetTasks()
+	if err != nil {
+		t.Fatalf("expect to get tasks, but got error: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("expect no task of a failed pipeline to be created, but got %d tasks", len(all))
+	}
+	if _, err := database.GetPipeline(pipeline.Id + 1); err == nil {
+		t.Error("expect no failed pipeline to be created")
+	}
+}
+
+func TestQueryTasks(t *testing.T) {
+	database := newTestDatabase(t)
+
+	finished := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tasks := []*pb.Task{
+		{Status: pb.TaskStatus_FINISHED, Commandline: "make test", WorkingDirectory: "/src", Priority: 1, ReturnCode: 0,
+			FinishTime: timestamppb.New(finished), ExecutionTime: durationpb.New(3 * time.Second)},
+		{Status: pb.TaskStatus_FINISHED, Commandline: "make lint", WorkingDirectory: "/src", Priority: 2, ReturnCode: 2,
+			FinishTime: timestamppb.New(finished.Add(time.Hour)), ExecutionTime: durationpb.New(time.Second)},
+		{Status: pb.TaskStatus_NEW, Commandline: "echo 100%", WorkingDirectory: "/tmp", Priority: 2},
+		{Status: pb.TaskStatus_RUNNING, Commandline: "MAKE all", WorkingDirectory: "/src"},
+	}
+	for i, task := range tasks {
+		created, err := database.CreateTask(task)
+		if err != nil {
+			t.Fatalf("should create task but got error: %v", err)
+		}
+		tasks[i] = created
+	}
+	ids := func(tasks []*pb.Task) []int64 {
+		ids := make([]int64, 0, len(tasks))
+		for _, task := range tasks {
+			ids = append(ids, task.Id)
+		}
+		return ids
+	}
+	id := func(i int) int64 { return tasks[i].Id }
+
+	queries := []struct {
+		name  string
+		query db.TaskQuery
+		want  []int64
+	}{
+		{"all", db.TaskQuery{}, []int64{id(3), id(2), id(1), id(0)}},
+		{"oldest first", db.TaskQuery{Order: pb.TaskOrder_OLDEST_FIRST, Limit: 2}, []int64{id(0), id(1)}},
+		{"statuses", db.TaskQuery{Filter: &pb.TaskFilter{Statuses: []pb.TaskStatus{pb.TaskStatus_NEW, pb.TaskStatus_RUNNING}}}, []int64{id(3), id(2)}},
+		{"exit code", db.TaskQuery{Filter: &pb.TaskFilter{ReturnCodes: []int32{0}}}, []int64{id(0)}},
+		{"working directory", db.TaskQuery{Filter: &pb.TaskFilter{WorkingDirectory: "/tmp"}}, []int64{id(2)}},
+		{"commandline", db.TaskQuery{Filter: &pb.TaskFilter{CommandlineContains: "make"}}, []int64{id(3), id(1), id(0)}},
+		{"like wildcards", db.TaskQuery{Filter: &pb.TaskFilter{CommandlineContains: "0%"}}, []int64{id(2)}},
+		{"finished after", db.TaskQuery{Filter: &pb.TaskFilter{FinishedAfter: timestamppb.New(finished.Add(time.Minute))}}, []int64{id(1)}},
+		{"finished before", db.TaskQuery{Filter: &pb.TaskFilter{FinishedBefore: timestamppb.New(finished.Add(time.Minute))}}, []int64{id(0)}},
+		{"created", db.TaskQuery{Filter: &pb.TaskFilter{
+			CreatedAfter:  timestamppb.New(time.Now().Add(-time.Minute)),
+			CreatedBefore: timestamppb.New(time.Now().Add(time.Minute)),
+		}}, []int64{id(3), id(2), id(1), id(0)}},
+		{"created later", db.TaskQuery{Filter: &pb.TaskFilter{CreatedAfter: timestamppb.New(time.Now().Add(time.Minute))}}, []int64{}},
+		{"priority", db.TaskQuery{Order: pb.TaskOrder_HIGHEST_PRIORITY_FIRST}, []int64{id(1), id(2), id(0), id(3)}},
+		{"priority after", db.TaskQuery{Order: pb.TaskOrder_HIGHEST_PRIORITY_FIRST, After: &db.TaskCursor{Key: 2, ID: id(1)}}, []int64{id(2), id(0), id(3)}},
+		{"longest", db.TaskQuery{Order: pb.TaskOrder_LONGEST_FIRST, Limit: 2}, []int64{id(0), id(1)}},
+		{"newest after", db.TaskQuery{After: &db.TaskCursor{ID: id(2)}}, []int64{id(1), id(0)}},
+	}
+	for _, q := range queries {
+		got, err := database.QueryTasks(q.query)
+		if err != nil {
+			t.Errorf("%s: expect to query tasks, but got error: %v", q.name, err)
+			continue
+		}
+		if fmt.Sprint(ids(got)) != fmt.Sprint(q.want) {
+			t.Errorf("%s: expect tasks %v, but got %v", q.name, q.want, ids(got))
+		}
+	}
+
+	cursor := db.CursorOf(tasks[1], pb.TaskOrder_HIGHEST_PRIORITY_FIRST)
+	if cursor.Key != 2 || cursor.ID != id(1) {
+		t.Errorf("expect the cursor of priority 2 and ID %d, but got %v", id(1), cursor)
+	}
+}
+
+func TestSearchTasks(t *testing.T) {
+	database := newTestDatabase(t)
+
+	outputs := []string{
+		"compiling main.go\nmain.go:3: undefined: fmt\nmain.go:9: undefined: os\n",
+		"ok\tweb_console\t0.2s\n",
+		"",
+	}
+	var tasks []*pb.Task
+	for i, output := range outputs {
+		task, err := database.CreateTask(&pb.Task{Status: pb.TaskStatus_FINISHED, Commandline: fmt.Sprintf("go build %d", i)})
+		if err != nil {
+			t.Fatalf("should create task but got error: %v", err)
+		}
+		tasks = append(tasks, task)
+		if i < 2 {
+			if err := database.IndexTaskOutput(task.Id, task.Commandline, output); err != nil {
+				t.Fatalf("expect to index the output, but got error: %v", err)
+			}
+		}
+	}
+
+	unindexed, err := database.GetUnindexedTasks()
+	if err != nil || len(unindexed) != 1 || unindexed[0].Id != tasks[2].Id {
+		t.Errorf("expect task %d to be unindexed, but got %v, %v", tasks[2].Id, unindexed, err)
+	}
+
+	results, err := database.SearchTasks("undefined", 10)
+	if err != nil {
+		t.Fatalf("expect to search tasks, but got error: %v", err)
+	}
+	if len(results) != 1 || results[0].Task.Id != tasks[0].Id {
+		t.Fatalf("expect task %d to match, but got %v", tasks[0].Id, results)
+	}
+	lines := results[0].Lines
+	if len(lines) != 2 || lines[0].Number != 2 || lines[0].Text != "main.go:3: undefined: fmt" || lines[1].Number != 3 {
+		t.Errorf("expect lines 2 and 3 to match, but got %v", lines)
+	}
+
+	// newest first, and the commandline is searched too
+	results, err = database.SearchTasks("go", 10)
+	if err != nil || len(results) != 2 || results[0].Task.Id != tasks[1].Id {
+		t.Errorf("expect both tasks to match, newest first, but got %v, %v", results, err)
+	}
+
+	if _, err := database.SearchTasks(`"undefined`, 10); err == nil {
+		t.Error("expect an error for a malformed query")
+	} else if _, ok := err.(*db.ErrInvalidQuery); !ok {
+		t.Errorf("expect ErrInvalidQuery, but got %v", err)
+	}
+
+	if err := database.DeleteTask(tasks[0].Id); err != nil {
+		t.Fatalf("expect to delete the task, but got error: %v", err)
+	}
+	results, err = database.SearchTasks("undefined", 10)
+	if err != nil || len(results) != 0 {
+		t.Errorf("expect the deleted task not to match, but got %v, %v", results, err)
+	}
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"internal/pb"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	SQL_CREATE_PIPELINES_TABLE = `CREATE TABLE IF NOT EXISTS pipelines (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL DEFAULT '',
+		task_ids TEXT NOT NULL DEFAULT '{}',
+		create_time DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	SQL_QUERY_ONE_PIPELINE = `SELECT id, name, task_ids, create_time FROM pipelines WHERE id = ?`
+
+	SQL_INSERT_PIPELINE = `INSERT INTO pipelines (name) VALUES (?)`
+
+	SQL_UPDATE_PIPELINE_TASK_IDS = `UPDATE pipelines SET task_ids = ? WHERE id = ?`
+
+	SQL_QUERY_TASKS_BY_PIPELINE = `SELECT` + SQL_TASK_COLUMNS + `
+	FROM tasks WHERE pipeline_id = ? ORDER BY id`
+)
+
+// taskIDs maps the names of the tasks of a pipeline to their IDs, stored as a
+// JSON object
+type taskIDs map[string]int64
+
+func (m taskIDs) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]int64(m))
+	return string(b), err
+}
+
+func (m *taskIDs) Scan(src any) error {
+	return scanJSON(src, (*map[string]int64)(m))
+}
+
+// CreatePipeline stores the tasks of a pipeline in a single transaction. A
+// task must come after the tasks it depends on, whose IDs are added to its
+// depends_on. The returned pipeline holds the tasks as stored.
+func (database *TaskDatabaseImpl) CreatePipeline(name string, tasks []*pb.PipelineTask) (*pb.Pipeline, error) {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("CreatePipeline: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(SQL_INSERT_PIPELINE, name)
+	if err != nil {
+		return nil, fmt.Errorf("CreatePipeline: %v", err)
+	}
+	pipelineID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("CreatePipeline: get last insert ID: %v", err)
+	}
+
+	ids := make(taskIDs, len(tasks))
+	created := make([]*pb.Task, 0, len(tasks))
+	for _, pipelineTask := range tasks {
+		pbTask := proto.Clone(pipelineTask.GetTask()).(*pb.Task)
+		pbTask.PipelineId = pipelineID
+		for _, parent := range pipelineTask.GetDependsOn() {
+			id, ok := ids[parent]
+			if !ok {
+				return nil, fmt.Errorf("CreatePipeline: task %q depends on %q, which does not come before it", pipelineTask.GetName(), parent)
+			}
+			pbTask.DependsOn = append(pbTask.DependsOn, id)
+		}
+
+		t := TaskFromProto(pbTask)
+		result, err := tx.Exec(SQL_INSERT_TASK, t.values()...)
+		if err != nil {
+			return nil, fmt.Errorf("CreatePipeline: create task %q: %v", pipelineTask.GetName(), err)
+		}
+		t.ID, err = result.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("CreatePipeline: get last insert ID: %v", err)
+		}
+		ids[pipelineTask.GetName()] = t.ID
+		created = append(created, t.ToProto())
+	}
+
+	_, err = tx.Exec(SQL_UPDATE_PIPELINE_TASK_IDS, ids, pipelineID)
+	if err != nil {
+		return nil, fmt.Errorf("CreatePipeline: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("CreatePipeline: %v", err)
+	}
+
+	pipeline, err := database.GetPipeline(pipelineID)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.Tasks = created
+	return pipeline, nil
+}
+
+// GetPipeline returns the pipeline without its tasks
+func (database *TaskDatabaseImpl) GetPipeline(id int64) (*pb.Pipeline, error) {
+	var p pb.Pipeline
+	var ids taskIDs
+	var createTime time.Time
+	err := database.db.QueryRow(SQL_QUERY_ONE_PIPELINE, id).Scan(&p.Id, &p.Name, &ids, &createTime)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &ErrNoRows{}
+		}
+		return nil, fmt.Errorf("GetPipeline: %v", err)
+	}
+	p.TaskIds = ids
+	if !createTime.IsZero() {
+		p.CreateTime = timestamppb.New(createTime)
+	}
+	return &p, nil
+}
+
+// GetTasksByPipeline returns the tasks of a pipeline in ID order
+func (database *TaskDatabaseImpl) GetTasksByPipeline(pipelineID int64) ([]*pb.Task, error) {
+	return database.queryTasks(SQL_QUERY_TASKS_BY_PIPELINE, pipelineID)
+}
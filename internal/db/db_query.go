@@ -0,0 +1,178 @@
+package db
+
+import (
+	"fmt"
+	"internal/pb"
+	"strings"
+	"time"
+)
+
+// SQL_CREATE_QUERY_INDEXES are the indexes serving the filters and orders of
+// QueryTasks. Filters by status use tasks_queue.
+const SQL_CREATE_QUERY_INDEXES = `
+	CREATE INDEX IF NOT EXISTS tasks_working_directory ON tasks (working_directory, id);
+	CREATE INDEX IF NOT EXISTS tasks_create_time ON tasks (create_time);
+	CREATE INDEX IF NOT EXISTS tasks_finish_time ON tasks (finish_time);
+	CREATE INDEX IF NOT EXISTS tasks_priority ON tasks (priority DESC, id);
+	CREATE INDEX IF NOT EXISTS tasks_execution_time ON tasks (execution_time DESC, id DESC);`
+
+// sqliteTimeLayout is the layout of CURRENT_TIMESTAMP, in which create_time
+// is stored
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// TaskQuery selects a page of tasks. The zero value selects every task, the
+// newest first.
+type TaskQuery struct {
+	Filter *pb.TaskFilter
+	Order  pb.TaskOrder
+	// Limit is the maximum number of tasks returned, no limit when 0
+	Limit int
+	// After is the position of the last task of the previous page, nil for
+	// the first page
+	After *TaskCursor
+}
+
+// TaskCursor is the position of a task in the order of a query: the sort key
+// of the order, if any, and the task ID breaking ties
+type TaskCursor struct {
+	Key int64 `json:"k,omitempty"`
+	ID  int64 `json:"id"`
+}
+
+// CursorOf returns the position of the task in the order
+func CursorOf(task *pb.Task, order pb.TaskOrder) TaskCursor {
+	cursor := TaskCursor{ID: task.Id}
+	switch order {
+	case pb.TaskOrder_HIGHEST_PRIORITY_FIRST:
+		cursor.Key = int64(task.Priority)
+	case pb.TaskOrder_LONGEST_FIRST:
+		cursor.Key = int64(task.GetExecutionTime().AsDuration())
+	}
+	return cursor
+}
+
+// taskOrder is how an order sorts and pages in SQL
+type taskOrder struct {
+	orderBy string
+	// after selects the tasks after a cursor, from its key and ID
+	after func(cursor TaskCursor) (string, []any)
+}
+
+var taskOrders = map[pb.TaskOrder]taskOrder{
+	pb.TaskOrder_NEWEST_FIRST: {
+		orderBy: "id DESC",
+		after: func(c TaskCursor) (string, []any) {
+			return "id < ?", []any{c.ID}
+		},
+	},
+	pb.TaskOrder_OLDEST_FIRST: {
+		orderBy: "id ASC",
+		after: func(c TaskCursor) (string, []any) {
+			return "id > ?", []any{c.ID}
+		},
+	},
+	pb.TaskOrder_HIGHEST_PRIORITY_FIRST: {
+		orderBy: "priority DESC, id ASC",
+		after: func(c TaskCursor) (string, []any) {
+			return "(priority < ? OR (priority = ? AND id > ?))", []any{c.Key, c.Key, c.ID}
+		},
+	},
+	pb.TaskOrder_LONGEST_FIRST: {
+		orderBy: "execution_time DESC, id DESC",
+		after: func(c TaskCursor) (string, []any) {
+			return "(execution_time < ? OR (execution_time = ? AND id < ?))", []any{c.Key, c.Key, c.ID}
+		},
+	},
+}
+
+// QueryTasks returns the tasks matching the filter of the query in its order
+func (database *TaskDatabaseImpl) QueryTasks(query TaskQuery) ([]*pb.Task, error) {
+	order, ok := taskOrders[query.Order]
+	if !ok {
+		return nil, fmt.Errorf("QueryTasks: unknown order %s", query.Order)
+	}
+
+	where, args := taskFilterSQL(query.Filter)
+	if query.After != nil {
+		cond, condArgs := order.after(*query.After)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	sql := `SELECT` + SQL_TASK_COLUMNS + `
+	FROM tasks`
+	if len(where) > 0 {
+		sql += "\n\tWHERE " + strings.Join(where, " AND ")
+	}
+	sql += "\n\tORDER BY " + order.orderBy
+	if query.Limit > 0 {
+		sql += "\n\tLIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	tasks, err := database.queryTasks(sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("QueryTasks: %v", err)
+	}
+	return tasks, nil
+}
+
+// taskFilterSQL returns the conditions of the WHERE clause selecting the
+// tasks matching the filter, and their arguments
+func taskFilterSQL(filter *pb.TaskFilter) ([]string, []any) {
+	var where []string
+	var args []any
+	if filter == nil {
+		return where, args
+	}
+
+	if len(filter.Statuses) > 0 {
+		where = append(where, "status IN ("+placeholders(len(filter.Statuses))+")")
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+	}
+	if len(filter.ReturnCodes) > 0 {
+		// only finished tasks have an exit code of their own
+		where = append(where, "status = ? AND return_code IN ("+placeholders(len(filter.ReturnCodes))+")")
+		args = append(args, pb.TaskStatus_FINISHED)
+		for _, code := range filter.ReturnCodes {
+			args = append(args, code)
+		}
+	}
+	if len(filter.WorkingDirectory) > 0 {
+		where = append(where, "working_directory = ?")
+		args = append(args, filter.WorkingDirectory)
+	}
+	if len(filter.CommandlineContains) > 0 {
+		where = append(where, `commandline LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.CommandlineContains)+"%")
+	}
+	if filter.CreatedAfter != nil {
+		where = append(where, "create_time >= ?")
+		args = append(args, filter.CreatedAfter.AsTime().UTC().Format(sqliteTimeLayout))
+	}
+	if filter.CreatedBefore != nil {
+		where = append(where, "create_time < ?")
+		args = append(args, filter.CreatedBefore.AsTime().UTC().Format(sqliteTimeLayout))
+	}
+	if filter.FinishedAfter != nil {
+		where = append(where, "finish_time >= ?")
+		args = append(args, filter.FinishedAfter.AsTime().UTC())
+	}
+	if filter.FinishedBefore != nil {
+		// tasks that have not finished have a zero finish_time
+		where = append(where, "finish_time > ? AND finish_time < ?")
+		args = append(args, time.Time{}, filter.FinishedBefore.AsTime().UTC())
+	}
+	return where, args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,304 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"internal/pb"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	SQL_CREATE_SCHEDULES_TABLE = `CREATE TABLE IF NOT EXISTS schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL DEFAULT '',
+		cron TEXT NOT NULL,
+		task_template TEXT NOT NULL,
+		paused INTEGER NOT NULL DEFAULT 0,
+		catch_up_policy INTEGER NOT NULL DEFAULT 0,
+		next_run_time DATETIME,
+		last_run_time DATETIME,
+		create_time DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// SQL_SCHEDULE_COLUMNS lists the columns read into a schedule, in the
+	// order of schedule.fields()
+	SQL_SCHEDULE_COLUMNS = `
+		id,
+		name,
+		cron,
+		task_template,
+		paused,
+		catch_up_policy,
+		next_run_time,
+		last_run_time,
+		create_time`
+
+	SQL_QUERY_SCHEDULES = `SELECT` + SQL_SCHEDULE_COLUMNS + `
+	FROM schedules ORDER BY id`
+
+	SQL_QUERY_ONE_SCHEDULE = `SELECT` + SQL_SCHEDULE_COLUMNS + `
+	FROM schedules WHERE id = ?`
+
+	SQL_INSERT_SCHEDULE = `INSERT INTO schedules (name, cron, task_template, paused, catch_up_policy, next_run_time, last_run_time)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	// the columns written from a schedule, in the order of schedule.values()
+	SQL_UPDATE_SCHEDULE = `UPDATE schedules SET
+		name = ?,
+		cron = ?,
+		task_template = ?,
+		paused = ?,
+		catch_up_policy = ?,
+		next_run_time = ?,
+		last_run_time = ?
+	WHERE id = ?`
+
+	SQL_UPDATE_SCHEDULE_RUN_TIMES = `UPDATE schedules SET next_run_time = ?, last_run_time = ? WHERE id = ?`
+	SQL_UPDATE_SCHEDULE_PAUSED    = `UPDATE schedules SET paused = ? WHERE id = ?`
+	SQL_UPDATE_SCHEDULE_RESUMED   = `UPDATE schedules SET paused = 0, next_run_time = ? WHERE id = ?`
+
+	SQL_DELETE_SCHEDULE = `DELETE FROM schedules WHERE id = ?`
+)
+
+type schedule struct {
+	ID            int64
+	Name          string
+	Cron          string
+	TaskTemplate  taskTemplate
+	Paused        bool
+	CatchUpPolicy pb.CatchUpPolicy
+	NextRunTime   time.Time
+	LastRunTime   time.Time
+	CreateTime    time.Time
+}
+
+// taskTemplate is stored as protojson
+type taskTemplate struct {
+	*pb.Task
+}
+
+func (t taskTemplate) Value() (driver.Value, error) {
+	if t.Task == nil {
+		return "{}", nil
+	}
+	b, err := protojson.Marshal(t.Task)
+	return string(b), err
+}
+
+func (t *taskTemplate) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T as a task template", src)
+	}
+	t.Task = &pb.Task{}
+	return protojson.Unmarshal(data, t.Task)
+}
+
+// fields returns pointers to the fields in the order of SQL_SCHEDULE_COLUMNS
+func (s *schedule) fields() []any {
+	return []any{
+		&s.ID,
+		&s.Name,
+		&s.Cron,
+		&s.TaskTemplate,
+		&s.Paused,
+		&s.CatchUpPolicy,
+		&s.NextRunTime,
+		&s.LastRunTime,
+		&s.CreateTime,
+	}
+}
+
+// values returns the fields written by SQL_INSERT_SCHEDULE and
+// SQL_UPDATE_SCHEDULE
+func (s *schedule) values() []any {
+	return []any{
+		s.Name,
+		s.Cron,
+		s.TaskTemplate,
+		s.Paused,
+		s.CatchUpPolicy,
+		s.NextRunTime,
+		s.LastRunTime,
+	}
+}
+
+func (s *schedule) ToProto() *pb.Schedule {
+	pbSchedule := &pb.Schedule{
+		Id:            s.ID,
+		Name:          s.Name,
+		Cron:          s.Cron,
+		TaskTemplate:  s.TaskTemplate.Task,
+		Paused:        s.Paused,
+		CatchUpPolicy: s.CatchUpPolicy,
+	}
+
+	if !s.NextRunTime.IsZero() {
+		pbSchedule.NextRunTime = timestamppb.New(s.NextRunTime)
+	}
+	if !s.LastRunTime.IsZero() {
+		pbSchedule.LastRunTime = timestamppb.New(s.LastRunTime)
+	}
+	if !s.CreateTime.IsZero() {
+		pbSchedule.CreateTime = timestamppb.New(s.CreateTime)
+	}
+
+	return pbSchedule
+}
+
+func ScheduleFromProto(pbSchedule *pb.Schedule) *schedule {
+	s := &schedule{
+		ID:            pbSchedule.Id,
+		Name:          pbSchedule.Name,
+		Cron:          pbSchedule.Cron,
+		TaskTemplate:  taskTemplate{pbSchedule.TaskTemplate},
+		Paused:        pbSchedule.Paused,
+		CatchUpPolicy: pbSchedule.CatchUpPolicy,
+	}
+
+	if pbSchedule.NextRunTime != nil {
+		s.NextRunTime = pbSchedule.NextRunTime.AsTime()
+	}
+	if pbSchedule.LastRunTime != nil {
+		s.LastRunTime = pbSchedule.LastRunTime.AsTime()
+	}
+	if pbSchedule.CreateTime != nil {
+		s.CreateTime = pbSchedule.CreateTime.AsTime()
+	}
+
+	return s
+}
+
+// CreateSchedule stores a new schedule and returns it as stored
+func (database *TaskDatabaseImpl) CreateSchedule(pbSchedule *pb.Schedule) (*pb.Schedule, error) {
+	s := ScheduleFromProto(pbSchedule)
+	result, err := database.db.Exec(SQL_INSERT_SCHEDULE, s.values()...)
+	if err != nil {
+		return nil, fmt.Errorf("CreateSchedule: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("CreateSchedule: get last insert ID: %v", err)
+	}
+
+	return database.GetSchedule(id)
+}
+
+// GetSchedules returns all schedules in ID order
+func (database *TaskDatabaseImpl) GetSchedules() ([]*pb.Schedule, error) {
+	rows, err := database.db.Query(SQL_QUERY_SCHEDULES)
+	if err != nil {
+		return nil, fmt.Errorf("GetSchedules: %v", err)
+	}
+	defer rows.Close()
+
+	var schedules []*pb.Schedule
+	for rows.Next() {
+		var s schedule
+		if err := rows.Scan(s.fields()...); err != nil {
+			return nil, fmt.Errorf("GetSchedules: %v", err)
+		}
+		schedules = append(schedules, s.ToProto())
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetSchedules: %v", err)
+	}
+
+	return schedules, nil
+}
+
+func (database *TaskDatabaseImpl) GetSchedule(id int64) (*pb.Schedule, error) {
+	var s schedule
+	err := database.db.QueryRow(SQL_QUERY_ONE_SCHEDULE, id).Scan(s.fields()...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &ErrNoRows{}
+		}
+		return nil, fmt.Errorf("GetSchedule: %v", err)
+	}
+	return s.ToProto(), nil
+}
+
+func (database *TaskDatabaseImpl) UpdateSchedule(pbSchedule *pb.Schedule) (*pb.Schedule, error) {
+	s := ScheduleFromProto(pbSchedule)
+	result, err := database.db.Exec(SQL_UPDATE_SCHEDULE, append(s.values(), s.ID)...)
+	if err != nil {
+		return nil, fmt.Errorf("UpdateSchedule: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("UpdateSchedule: get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return nil, &ErrNoRows{}
+	}
+
+	return s.ToProto(), nil
+}
+
+// UpdateScheduleRunTimes records a run of the schedule without touching its
+// settings, which may have been changed since it was read
+func (database *TaskDatabaseImpl) UpdateScheduleRunTimes(id int64, next time.Time, last time.Time) error {
+	_, err := database.db.Exec(SQL_UPDATE_SCHEDULE_RUN_TIMES, next, last, id)
+	if err != nil {
+		return fmt.Errorf("UpdateScheduleRunTimes: %v", err)
+	}
+	return nil
+}
+
+// UpdateSchedulePaused pauses or resumes the schedule without touching its
+// run times, which the scheduler may be updating
+func (database *TaskDatabaseImpl) UpdateSchedulePaused(id int64, paused bool) error {
+	result, err := database.db.Exec(SQL_UPDATE_SCHEDULE_PAUSED, paused, id)
+	if err != nil {
+		return fmt.Errorf("UpdateSchedulePaused: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("UpdateSchedulePaused: get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return &ErrNoRows{}
+	}
+	return nil
+}
+
+// UpdateScheduleResumed resumes the schedule from its next run time without
+// touching its settings, which may have been changed since it was read
+func (database *TaskDatabaseImpl) UpdateScheduleResumed(id int64, next time.Time) error {
+	result, err := database.db.Exec(SQL_UPDATE_SCHEDULE_RESUMED, next, id)
+	if err != nil {
+		return fmt.Errorf("UpdateScheduleResumed: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("UpdateScheduleResumed: get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return &ErrNoRows{}
+	}
+	return nil
+}
+
+// DeleteSchedule deletes the schedule; the tasks it created are kept
+func (database *TaskDatabaseImpl) DeleteSchedule(id int64) error {
+	_, err := database.db.Exec(SQL_DELETE_SCHEDULE, id)
+	if err != nil {
+		return fmt.Errorf("DeleteSchedule: %v", err)
+	}
+	return nil
+}
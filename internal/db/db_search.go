@@ -0,0 +1,165 @@
+package db
+
+import (
+	"fmt"
+	"internal/pb"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SQL_CREATE_OUTPUT_INDEX indexes the commandline and the output of the
+	// finished tasks, the docid being the task ID. FTS5 would need the
+	// sqlite_fts5 build tag, FTS4 is built into go-sqlite3.
+	SQL_CREATE_OUTPUT_INDEX = `CREATE VIRTUAL TABLE IF NOT EXISTS output_index
+	USING fts4(commandline, output, tokenize=unicode61);`
+
+	SQL_INSERT_OUTPUT_INDEX = `INSERT INTO output_index (docid, commandline, output) VALUES (?, ?, ?)`
+	SQL_DELETE_OUTPUT_INDEX = `DELETE FROM output_index WHERE docid = ?`
+
+	SQL_SEARCH_OUTPUT_INDEX = `SELECT docid, offsets(output_index), output FROM output_index
+	WHERE output_index MATCH ? ORDER BY docid DESC LIMIT ?`
+
+	SQL_QUERY_UNINDEXED_TASKS = `SELECT` + SQL_TASK_COLUMNS + `
+	FROM tasks WHERE id NOT IN (SELECT docid FROM output_index) ORDER BY id`
+
+	// outputIndexColumn is the number of the output column in offsets()
+	outputIndexColumn = 1
+	// maxSearchLines is the number of matching lines returned per task
+	maxSearchLines = 5
+	// maxSearchLineLength cuts long lines around the match
+	maxSearchLineLength = 200
+)
+
+// ErrInvalidQuery is returned for a full-text query SQLite cannot parse
+type ErrInvalidQuery struct {
+	message string
+}
+
+func (e *ErrInvalidQuery) Error() string {
+	return e.message
+}
+
+// IndexTaskOutput replaces what the search index holds for the task
+func (database *TaskDatabaseImpl) IndexTaskOutput(id int64, commandline string, output string) error {
+	tx, err := database.db.Begin()
+	if err != nil {
+		return fmt.Errorf("IndexTaskOutput: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(SQL_DELETE_OUTPUT_INDEX, id); err != nil {
+		return fmt.Errorf("IndexTaskOutput: %v", err)
+	}
+	if _, err := tx.Exec(SQL_INSERT_OUTPUT_INDEX, id, commandline, output); err != nil {
+		return fmt.Errorf("IndexTaskOutput: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("IndexTaskOutput: %v", err)
+	}
+	return nil
+}
+
+// GetUnindexedTasks returns the tasks missing from the search index in ID
+// order
+func (database *TaskDatabaseImpl) GetUnindexedTasks() ([]*pb.Task, error) {
+	return database.queryTasks(SQL_QUERY_UNINDEXED_TASKS)
+}
+
+// searchMatch is a task matching a search, before the task is read
+type searchMatch struct {
+	id    int64
+	lines []*pb.OutputLine
+}
+
+// SearchTasks returns the indexed tasks matching the full-text query, newest
+// first, with the first lines of their output that match
+func (database *TaskDatabaseImpl) SearchTasks(query string, limit int) ([]*pb.TaskSearchResult, error) {
+	rows, err := database.db.Query(SQL_SEARCH_OUTPUT_INDEX, query, limit)
+	if err != nil {
+		return nil, searchError(err)
+	}
+	var matches []searchMatch
+	for rows.Next() {
+		var match searchMatch
+		var offsets, output string
+		if err := rows.Scan(&match.id, &offsets, &output); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("SearchTasks: %v", err)
+		}
+		match.lines = matchingLines(output, offsets)
+		matches = append(matches, match)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, searchError(err)
+	}
+	rows.Close()
+
+	// the rows are closed first, as the database has a single connection
+	results := make([]*pb.TaskSearchResult, 0, len(matches))
+	for _, match := range matches {
+		task, err := database.GetTask(match.id)
+		if err != nil {
+			if _, ok := err.(*ErrNoRows); ok {
+				continue
+			}
+			return nil, fmt.Errorf("SearchTasks: %v", err)
+		}
+		results = append(results, &pb.TaskSearchResult{Task: task, Lines: match.lines})
+	}
+	return results, nil
+}
+
+func searchError(err error) error {
+	if strings.Contains(err.Error(), "malformed MATCH expression") {
+		return &ErrInvalidQuery{message: err.Error()}
+	}
+	return fmt.Errorf("SearchTasks: %v", err)
+}
+
+// matchingLines returns the first lines of output holding a match, given the
+// result of offsets(): the column, term, byte offset and size of every match
+func matchingLines(output string, offsets string) []*pb.OutputLine {
+	fields := strings.Fields(offsets)
+	var starts []int
+	for i := 0; i+3 < len(fields); i += 4 {
+		column, _ := strconv.Atoi(fields[i])
+		start, err := strconv.Atoi(fields[i+2])
+		if column == outputIndexColumn && err == nil && start < len(output) {
+			starts = append(starts, start)
+		}
+	}
+	sort.Ints(starts)
+
+	var lines []*pb.OutputLine
+	number, lineStart := 1, 0
+	for _, start := range starts {
+		if start < lineStart {
+			// another match on the line already returned
+			continue
+		}
+		number += strings.Count(output[lineStart:start], "\n")
+		lineStart += strings.LastIndexByte(output[lineStart:start], '\n') + 1
+		lineEnd := len(output)
+		if i := strings.IndexByte(output[start:], '\n'); i >= 0 {
+			lineEnd = start + i
+		}
+
+		text := output[lineStart:lineEnd]
+		if len(text) > maxSearchLineLength {
+			from := max(0, min(start-lineStart-maxSearchLineLength/4, len(text)-maxSearchLineLength))
+			text = text[from : from+maxSearchLineLength]
+		}
+		lines = append(lines, &pb.OutputLine{
+			Number: int32(number),
+			Text:   strings.ToValidUTF8(strings.TrimRight(text, "\r"), "�"),
+		})
+		if len(lines) == maxSearchLines {
+			break
+		}
+		lineStart = lineEnd + 1
+		number++
+	}
+	return lines
+}
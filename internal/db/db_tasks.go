@@ -1,9 +1,13 @@
 package db
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"internal/pb"
 	"time"
 
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -19,6 +23,205 @@ type task struct {
 	WorkingDirectory string
 	Commandline      string
 	CreateTime       time.Time
+	Priority         int32
+	Timeout          time.Duration
+	Env              stringMap
+	ClearEnv         bool
+	Shell            pb.Shell
+	Argv             stringList
+	RecoveryPolicy   pb.RecoveryPolicy
+	Pid              int32
+	RetryPolicy      retryPolicy
+	Attempt          int32
+	Interactive      bool
+	ScheduleID       int64
+	NotBefore        nullTime
+	DependsOn        idList
+	PipelineID       int64
+	RerunOf          int64
+	MaxOutputBytes   int64
+	// Pinned is only written by UpdateTaskPinned, so that updates of a
+	// running task do not undo it
+	Pinned bool
+}
+
+// nullTime is a time stored as NULL when zero
+type nullTime struct {
+	time.Time
+}
+
+func (t nullTime) Value() (driver.Value, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return t.UTC(), nil
+}
+
+func (t *nullTime) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+	case time.Time:
+		t.Time = v
+	default:
+		return fmt.Errorf("cannot scan %T as a time", src)
+	}
+	return nil
+}
+
+// retryPolicy is stored as protojson, or as an empty string when unset
+type retryPolicy struct {
+	*pb.RetryPolicy
+}
+
+func (p retryPolicy) Value() (driver.Value, error) {
+	if p.RetryPolicy == nil {
+		return "", nil
+	}
+	b, err := protojson.Marshal(p.RetryPolicy)
+	return string(b), err
+}
+
+func (p *retryPolicy) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T as a retry policy", src)
+	}
+	if len(data) == 0 {
+		p.RetryPolicy = nil
+		return nil
+	}
+	p.RetryPolicy = &pb.RetryPolicy{}
+	return protojson.Unmarshal(data, p.RetryPolicy)
+}
+
+// stringMap is stored as a JSON object
+type stringMap map[string]string
+
+func (m stringMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]string(m))
+	return string(b), err
+}
+
+func (m *stringMap) Scan(src any) error {
+	return scanJSON(src, (*map[string]string)(m))
+}
+
+// stringList is stored as a JSON array
+type stringList []string
+
+func (l stringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+func (l *stringList) Scan(src any) error {
+	return scanJSON(src, (*[]string)(l))
+}
+
+// idList is stored as a JSON array
+type idList []int64
+
+func (l idList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]int64(l))
+	return string(b), err
+}
+
+func (l *idList) Scan(src any) error {
+	return scanJSON(src, (*[]int64)(l))
+}
+
+func scanJSON(src any, v any) error {
+	switch data := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(data), v)
+	case []byte:
+		return json.Unmarshal(data, v)
+	default:
+		return fmt.Errorf("cannot scan %T as JSON", src)
+	}
+}
+
+// fields returns pointers to the fields in the order of SQL_TASK_COLUMNS
+func (t *task) fields() []any {
+	return []any{
+		&t.ID,
+		&t.Status,
+		&t.Commandline,
+		&t.ReturnCode,
+		&t.StartTime,
+		&t.FinishTime,
+		&t.ExecutionTime,
+		&t.WorkingDirectory,
+		&t.CreateTime,
+		&t.Output,
+		&t.Priority,
+		&t.Timeout,
+		&t.Env,
+		&t.ClearEnv,
+		&t.Shell,
+		&t.Argv,
+		&t.RecoveryPolicy,
+		&t.Pid,
+		&t.RetryPolicy,
+		&t.Attempt,
+		&t.Interactive,
+		&t.ScheduleID,
+		&t.NotBefore,
+		&t.DependsOn,
+		&t.PipelineID,
+		&t.RerunOf,
+		&t.Pinned,
+		&t.MaxOutputBytes,
+	}
+}
+
+// values returns the fields written by SQL_INSERT_TASK and SQL_UPDATE_TASK
+func (t *task) values() []any {
+	return []any{
+		t.Status,
+		t.Commandline,
+		t.ReturnCode,
+		t.StartTime,
+		t.FinishTime,
+		t.ExecutionTime,
+		t.WorkingDirectory,
+		t.Output,
+		t.Priority,
+		t.Timeout,
+		t.Env,
+		t.ClearEnv,
+		t.Shell,
+		t.Argv,
+		t.RecoveryPolicy,
+		t.Pid,
+		t.RetryPolicy,
+		t.Attempt,
+		t.Interactive,
+		t.ScheduleID,
+		t.NotBefore,
+		t.DependsOn,
+		t.PipelineID,
+		t.RerunOf,
+		t.MaxOutputBytes,
+	}
 }
 
 func (t *task) ToProto() *pb.Task {
@@ -29,6 +232,22 @@ func (t *task) ToProto() *pb.Task {
 		Output:           t.Output,
 		WorkingDirectory: t.WorkingDirectory,
 		Commandline:      t.Commandline,
+		Priority:         t.Priority,
+		Env:              t.Env,
+		ClearEnv:         t.ClearEnv,
+		Shell:            t.Shell,
+		Argv:             t.Argv,
+		RecoveryPolicy:   t.RecoveryPolicy,
+		Pid:              t.Pid,
+		RetryPolicy:      t.RetryPolicy.RetryPolicy,
+		Attempt:          t.Attempt,
+		Interactive:      t.Interactive,
+		ScheduleId:       t.ScheduleID,
+		DependsOn:        t.DependsOn,
+		PipelineId:       t.PipelineID,
+		RerunOf:          t.RerunOf,
+		Pinned:           t.Pinned,
+		MaxOutputBytes:   t.MaxOutputBytes,
 	}
 
 	if !t.StartTime.IsZero() {
@@ -43,6 +262,12 @@ func (t *task) ToProto() *pb.Task {
 	if !t.CreateTime.IsZero() {
 		pbTask.CreateTime = timestamppb.New(t.CreateTime)
 	}
+	if t.Timeout != 0 {
+		pbTask.Timeout = durationpb.New(t.Timeout)
+	}
+	if !t.NotBefore.IsZero() {
+		pbTask.NotBefore = timestamppb.New(t.NotBefore.Time)
+	}
 
 	return pbTask
 }
@@ -55,6 +280,22 @@ func TaskFromProto(pbTask *pb.Task) *task {
 		Output:           pbTask.Output,
 		WorkingDirectory: pbTask.WorkingDirectory,
 		Commandline:      pbTask.Commandline,
+		Priority:         pbTask.Priority,
+		Env:              pbTask.Env,
+		ClearEnv:         pbTask.ClearEnv,
+		Shell:            pbTask.Shell,
+		Argv:             pbTask.Argv,
+		RecoveryPolicy:   pbTask.RecoveryPolicy,
+		Pid:              pbTask.Pid,
+		RetryPolicy:      retryPolicy{pbTask.RetryPolicy},
+		Attempt:          pbTask.Attempt,
+		Interactive:      pbTask.Interactive,
+		ScheduleID:       pbTask.ScheduleId,
+		DependsOn:        pbTask.DependsOn,
+		PipelineID:       pbTask.PipelineId,
+		RerunOf:          pbTask.RerunOf,
+		Pinned:           pbTask.Pinned,
+		MaxOutputBytes:   pbTask.MaxOutputBytes,
 	}
 
 	if pbTask.StartTime != nil {
@@ -69,6 +310,12 @@ func TaskFromProto(pbTask *pb.Task) *task {
 	if pbTask.CreateTime != nil {
 		t.CreateTime = pbTask.CreateTime.AsTime()
 	}
+	if pbTask.Timeout != nil {
+		t.Timeout = pbTask.Timeout.AsDuration()
+	}
+	if pbTask.NotBefore != nil {
+		t.NotBefore = nullTime{pbTask.NotBefore.AsTime()}
+	}
 
 	return t
 }
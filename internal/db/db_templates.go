@@ -0,0 +1,149 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"internal/pb"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	SQL_CREATE_TEMPLATES_TABLE = `CREATE TABLE IF NOT EXISTS templates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		task TEXT NOT NULL,
+		create_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+		update_time DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// SQL_TEMPLATE_COLUMNS lists the columns read into a template, in the
+	// order of template.fields()
+	SQL_TEMPLATE_COLUMNS = `
+		id,
+		name,
+		task,
+		create_time,
+		update_time`
+
+	SQL_QUERY_TEMPLATES = `SELECT` + SQL_TEMPLATE_COLUMNS + `
+	FROM templates ORDER BY name`
+
+	SQL_QUERY_ONE_TEMPLATE = `SELECT` + SQL_TEMPLATE_COLUMNS + `
+	FROM templates WHERE name = ?`
+
+	SQL_INSERT_TEMPLATE = `INSERT INTO templates (name, task) VALUES (?, ?)`
+
+	SQL_UPDATE_TEMPLATE = `UPDATE templates SET task = ?, update_time = CURRENT_TIMESTAMP WHERE name = ?`
+
+	SQL_DELETE_TEMPLATE = `DELETE FROM templates WHERE name = ?`
+)
+
+type template struct {
+	ID         int64
+	Name       string
+	Task       taskTemplate
+	CreateTime time.Time
+	UpdateTime time.Time
+}
+
+// fields returns pointers to the fields in the order of SQL_TEMPLATE_COLUMNS
+func (t *template) fields() []any {
+	return []any{
+		&t.ID,
+		&t.Name,
+		&t.Task,
+		&t.CreateTime,
+		&t.UpdateTime,
+	}
+}
+
+func (t *template) ToProto() *pb.Template {
+	pbTemplate := &pb.Template{
+		Id:   t.ID,
+		Name: t.Name,
+		Task: t.Task.Task,
+	}
+
+	if !t.CreateTime.IsZero() {
+		pbTemplate.CreateTime = timestamppb.New(t.CreateTime)
+	}
+	if !t.UpdateTime.IsZero() {
+		pbTemplate.UpdateTime = timestamppb.New(t.UpdateTime)
+	}
+
+	return pbTemplate
+}
+
+// CreateTemplate stores a new template and returns it as stored
+func (database *TaskDatabaseImpl) CreateTemplate(pbTemplate *pb.Template) (*pb.Template, error) {
+	_, err := database.db.Exec(SQL_INSERT_TEMPLATE, pbTemplate.GetName(), taskTemplate{pbTemplate.GetTask()})
+	if err != nil {
+		return nil, fmt.Errorf("CreateTemplate: %v", err)
+	}
+
+	return database.GetTemplate(pbTemplate.GetName())
+}
+
+// GetTemplates returns all templates in name order
+func (database *TaskDatabaseImpl) GetTemplates() ([]*pb.Template, error) {
+	rows, err := database.db.Query(SQL_QUERY_TEMPLATES)
+	if err != nil {
+		return nil, fmt.Errorf("GetTemplates: %v", err)
+	}
+	defer rows.Close()
+
+	var templates []*pb.Template
+	for rows.Next() {
+		var t template
+		if err := rows.Scan(t.fields()...); err != nil {
+			return nil, fmt.Errorf("GetTemplates: %v", err)
+		}
+		templates = append(templates, t.ToProto())
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetTemplates: %v", err)
+	}
+
+	return templates, nil
+}
+
+func (database *TaskDatabaseImpl) GetTemplate(name string) (*pb.Template, error) {
+	var t template
+	err := database.db.QueryRow(SQL_QUERY_ONE_TEMPLATE, name).Scan(t.fields()...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &ErrNoRows{}
+		}
+		return nil, fmt.Errorf("GetTemplate: %v", err)
+	}
+	return t.ToProto(), nil
+}
+
+// UpdateTemplate replaces the task of the template with the same name
+func (database *TaskDatabaseImpl) UpdateTemplate(pbTemplate *pb.Template) (*pb.Template, error) {
+	result, err := database.db.Exec(SQL_UPDATE_TEMPLATE, taskTemplate{pbTemplate.GetTask()}, pbTemplate.GetName())
+	if err != nil {
+		return nil, fmt.Errorf("UpdateTemplate: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("UpdateTemplate: get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return nil, &ErrNoRows{}
+	}
+
+	return database.GetTemplate(pbTemplate.GetName())
+}
+
+func (database *TaskDatabaseImpl) DeleteTemplate(name string) error {
+	_, err := database.db.Exec(SQL_DELETE_TEMPLATE, name)
+	if err != nil {
+		return fmt.Errorf("DeleteTemplate: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+-- tasks.db as created by the first release, before schema_version existed
+CREATE TABLE IF NOT EXISTS tasks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	status INTEGER,
+	commandline TEXT,
+	return_code INTEGER,
+	start_time DATETIME,
+	finish_time DATETIME,
+	execution_time INTEGER,
+	working_directory TEXT,
+	output TEXT,
+	create_time DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO tasks (status, commandline, return_code, start_time, finish_time, execution_time, working_directory, output, create_time)
+VALUES (2, 'make test', 0, '2024-05-01 10:00:00+00:00', '2024-05-01 10:00:03+00:00', 3000000000, '/src', '/home/user/tmp/output/1.txt', '2024-05-01 09:59:59');
+INSERT INTO tasks (status, commandline, return_code, start_time, finish_time, execution_time, working_directory, output, create_time)
+VALUES (0, 'make lint', 0, '0001-01-01 00:00:00+00:00', '0001-01-01 00:00:00+00:00', 0, '/src', '', '2024-05-01 10:05:00');
@@ -0,0 +1,3 @@
+module outputlog
+
+go 1.23.3
@@ -0,0 +1,149 @@
+// Package outputlog reads and writes the output log of a task. The log keeps
+// what the task wrote to stdout and stderr apart, with the time it was
+// written: after a header line, every line of output, or the part of a line
+// written at once, is a JSON object on a line of its own, e.g.
+//
+//	{"t":"2024-06-01T23:00:00.123456789Z","s":"err","d":"make: *** Error 2\n"}
+//
+// Data that is not valid UTF-8 is kept as base64 under "b" instead of "d".
+// The records are in the order they were written, so concatenating their
+// data gives the output as a terminal would have shown it.
+package outputlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// Header is the first line of every output log. Output files recorded before
+// stdout and stderr were kept apart hold the raw output without it.
+const Header = `{"format":"task output log","version":1}` + "\n"
+
+// The streams of a record
+const (
+	Stdout = "out"
+	Stderr = "err"
+	// Runner marks messages of the runner rather than of the task, e.g.
+	// that output was dropped
+	Runner = ""
+)
+
+// maxRecordSize bounds the size of an encoded record, the data of a single
+// write escaped
+const maxRecordSize = 1 << 20
+
+// Record is a line of output, or the part of one written at once
+type Record struct {
+	Time   time.Time
+	Stream string
+	Data   []byte
+}
+
+type jsonRecord struct {
+	Time   time.Time `json:"t"`
+	Stream string    `json:"s,omitempty"`
+	Text   string    `json:"d,omitempty"`
+	Bytes  []byte    `json:"b,omitempty"`
+}
+
+// IsLog reports whether an output file starting with prefix is an output
+// log, given as much of its start as there is up to the length of Header. An
+// empty file or a partially written header count as a log.
+func IsLog(prefix []byte) bool {
+	return bytes.HasPrefix([]byte(Header), prefix)
+}
+
+// Append appends the records of data written to a stream at t to the log,
+// splitting it after every newline
+func Append(log []byte, t time.Time, stream string, data []byte) []byte {
+	buf := bytes.NewBuffer(log)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n') + 1
+		if n == 0 {
+			n = len(data)
+		}
+		record := jsonRecord{Time: t.UTC(), Stream: stream}
+		if utf8.Valid(data[:n]) {
+			record.Text = string(data[:n])
+		} else {
+			record.Bytes = data[:n]
+		}
+		// a record always encodes, and writing to a buffer cannot fail
+		encoder.Encode(record)
+		data = data[n:]
+	}
+	return buf.Bytes()
+}
+
+// Scanner reads the records of an output log
+type Scanner struct {
+	scanner *bufio.Scanner
+	offset  int64
+	record  Record
+	err     error
+}
+
+// NewScanner reads the records from r, which reads the log from offset, the
+// start of a record. A record still being written at the end is left for a
+// later scan.
+func NewScanner(r io.Reader, offset int64) *Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordSize)
+	scanner.Split(scanRecords)
+	return &Scanner{scanner: scanner, offset: offset}
+}
+
+// scanRecords splits the log into lines, newline included, and stops before
+// an unterminated line
+func scanRecords(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	return 0, nil, nil
+}
+
+// Scan reads the next record, reporting false at the end of the log or on
+// error
+func (s *Scanner) Scan() bool {
+	if s.err != nil || !s.scanner.Scan() {
+		return false
+	}
+	line := s.scanner.Bytes()
+	var record jsonRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		s.err = fmt.Errorf("invalid output log record at offset %d: %v", s.offset, err)
+		return false
+	}
+	s.offset += int64(len(line))
+
+	s.record = Record{Time: record.Time, Stream: record.Stream, Data: record.Bytes}
+	if len(record.Text) > 0 {
+		s.record.Data = []byte(record.Text)
+	}
+	return true
+}
+
+// Record returns the record read by the last call to Scan
+func (s *Scanner) Record() Record {
+	return s.record
+}
+
+// Offset returns the offset of the log after the last record read
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
+// Err returns the error that stopped Scan, nil at the end of the log
+func (s *Scanner) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.scanner.Err()
+}
@@ -0,0 +1,54 @@
+package outputlog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendAndScan(t *testing.T) {
+	now := time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC)
+	log := []byte(Header)
+	log = Append(log, now, Stdout, []byte("building <all>\nprogress 10%"))
+	log = Append(log, now.Add(time.Second), Stderr, []byte("\xff\xfe\n"))
+	log = Append(log, now.Add(2*time.Second), Stdout, []byte(" done\n"))
+
+	if !IsLog(log[:len(Header)]) || !IsLog(nil) || IsLog([]byte("make all\n")) {
+		t.Error("expect IsLog to recognize the header only")
+	}
+	if !strings.Contains(string(log), `"d":"building <all>\n"`) {
+		t.Errorf("expect text records to be readable, but got %s", log)
+	}
+
+	// the last record is still being written
+	partial := log[:len(log)-3]
+	scanner := NewScanner(bytes.NewReader(partial[len(Header):]), int64(len(Header)))
+	var records []Record
+	for scanner.Scan() {
+		records = append(records, scanner.Record())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Scan() should not fail, but got %v", err)
+	}
+
+	want := []Record{
+		{now, Stdout, []byte("building <all>\n")},
+		{now, Stdout, []byte("progress 10%")},
+		{now.Add(time.Second), Stderr, []byte("\xff\xfe\n")},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("expect %d records, but got %d", len(want), len(records))
+	}
+	for i, r := range records {
+		if !r.Time.Equal(want[i].Time) || r.Stream != want[i].Stream || !bytes.Equal(r.Data, want[i].Data) {
+			t.Errorf("expect record %d to be %v, but got %v", i, want[i], r)
+		}
+	}
+
+	// resuming after the last complete record reads the rest
+	scanner = NewScanner(bytes.NewReader(log[scanner.Offset():]), scanner.Offset())
+	if !scanner.Scan() || string(scanner.Record().Data) != " done\n" || scanner.Offset() != int64(len(log)) {
+		t.Errorf("expect to resume with the last record, but got %v, %v", scanner.Record(), scanner.Err())
+	}
+}
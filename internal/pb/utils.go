@@ -13,3 +13,17 @@ func (t *Task) AsJsonString() string {
 	}
 	return string(jsonData)
 }
+
+// IsFinal reports whether a task in this status will not change any more.
+func (s TaskStatus) IsFinal() bool {
+	switch s {
+	case TaskStatus_FINISHED, TaskStatus_CANCELLED, TaskStatus_TIMED_OUT, TaskStatus_INTERRUPTED, TaskStatus_SKIPPED:
+		return true
+	}
+	return false
+}
+
+// Succeeded reports whether the task finished with exit code 0
+func (t *Task) Succeeded() bool {
+	return t.GetStatus() == TaskStatus_FINISHED && t.GetReturnCode() == 0
+}
@@ -6,9 +6,13 @@ replace internal/pb => ../pb
 
 replace internal/db => ../db
 
+replace internal/outputlog => ../outputlog
+
 require (
+	github.com/creack/pty v1.1.24
 	google.golang.org/protobuf v1.36.1
 	internal/db v1.0.0
+	internal/outputlog v1.0.0
 	internal/pb v1.0.0
 )
 
@@ -0,0 +1,42 @@
+package runner
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+// compressedOutputSuffix ends the path of an output file compressed with
+// gzip. The offsets of the output chunks are offsets in the uncompressed
+// output.
+const compressedOutputSuffix = ".gz"
+
+// compressOutput writes a gzipped copy of the output file of a finished task
+// next to it and returns its path. The original is left for the caller to
+// remove once the task points to the copy.
+func compressOutput(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	compressed := path + compressedOutputSuffix
+	out, err := os.OpenFile(compressed, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(compressed)
+		return "", err
+	}
+	return compressed, nil
+}
@@ -0,0 +1,197 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"internal/outputlog"
+	"internal/pb"
+)
+
+const (
+	outputFeedQueueSize = 256
+	// overflowNotice follows the head of an output over the limit
+	overflowNotice = "\n[... output limit reached, the last %d bytes of the output log follow once the task exits ...]\n"
+)
+
+// outputFeed writes the output of a task to its output log and hands every
+// write, a run of complete records, to the subscribers, so that readers can
+// follow a running task without polling the file. The first chunk starts at
+// offset 0 with the header of the log because the file is truncated when the
+// task starts.
+//
+// With a limit, only the records within the first half of it, the head, are
+// written as the task runs, followed by a record once the output goes past it
+// so that followers know the rest is held back. The records after the head
+// are kept in memory, and when the feed is closed the last half of the limit,
+// the tail, is written after a record telling how much was dropped. The file
+// always holds exactly the chunks handed to the subscribers.
+type outputFeed struct {
+	mu          sync.Mutex
+	file        *os.File
+	offset      int64
+	closed      bool
+	subscribers map[chan *pb.TaskOutputChunk]struct{}
+
+	// head and tail split the limit, tail is 0 without one
+	head     int64
+	tail     int64
+	overflow bool
+	pending  []byte
+	dropped  int64
+}
+
+func newOutputFeed(file *os.File, limit int64) (*outputFeed, error) {
+	f := &outputFeed{
+		file:        file,
+		subscribers: make(map[chan *pb.TaskOutputChunk]struct{}),
+	}
+	if limit > 0 {
+		f.head = limit / 2
+		f.tail = limit - f.head
+	}
+	if _, err := f.write([]byte(outputlog.Header)); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stream returns a writer recording the output of the task to one of the
+// streams of outputlog
+func (f *outputFeed) Stream(stream string) io.Writer {
+	return &streamWriter{feed: f, stream: stream}
+}
+
+type streamWriter struct {
+	feed   *outputFeed
+	stream string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	return w.feed.writeStream(w.stream, p)
+}
+
+// writeStream records p, timestamped under the lock so that the records are
+// in the order they were written
+func (f *outputFeed) writeStream(stream string, p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	records := outputlog.Append(nil, time.Now(), stream, p)
+	if f.tail == 0 || !f.overflow && f.offset+int64(len(records)) <= f.head {
+		if _, err := f.write(records); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if !f.overflow {
+		f.overflow = true
+		notice := outputlog.Append(nil, time.Now(), outputlog.Runner, []byte(fmt.Sprintf(overflowNotice, f.tail)))
+		if _, err := f.write(notice); err != nil {
+			return 0, err
+		}
+	}
+	f.pending = append(f.pending, records...)
+	// trimmed lazily so that the buffer is not copied on every write
+	if excess := int64(len(f.pending)) - f.tail; excess > f.tail {
+		f.drop(excess)
+	}
+	return len(p), nil
+}
+
+// drop drops at least n bytes of pending records, up to the end of a record
+func (f *outputFeed) drop(n int64) {
+	if i := bytes.IndexByte(f.pending[n:], '\n'); i >= 0 {
+		n += int64(i) + 1
+	} else {
+		n = int64(len(f.pending))
+	}
+	f.dropped += n
+	f.pending = append(f.pending[:0], f.pending[n:]...)
+}
+
+// write writes to the file and hands the chunk to the subscribers
+func (f *outputFeed) write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	if n > 0 {
+		chunk := &pb.TaskOutputChunk{Offset: f.offset, Data: bytes.Clone(p[:n])}
+		f.offset += int64(n)
+		chunk.NextOffset = f.offset
+		for ch := range f.subscribers {
+			select {
+			case ch <- chunk:
+			default:
+				// the subscriber fell behind, it has to catch up from the file
+				delete(f.subscribers, ch)
+				close(ch)
+			}
+		}
+	}
+	return n, err
+}
+
+// Subscribe returns a channel that receives every chunk written from now on
+// and a function that cancels the subscription. The channel is closed when
+// the task exits or when the subscriber falls behind. It reports false if the
+// feed is already closed.
+func (f *outputFeed) Subscribe() (<-chan *pb.TaskOutputChunk, func(), bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, nil, false
+	}
+
+	ch := make(chan *pb.TaskOutputChunk, outputFeedQueueSize)
+	f.subscribers[ch] = struct{}{}
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subscribers[ch]; ok {
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel, true
+}
+
+// Close writes the tail of the output, closes the output file and ends all
+// subscriptions. Closing the feed again does nothing.
+func (f *outputFeed) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+
+	var err error
+	if f.overflow {
+		if excess := int64(len(f.pending)) - f.tail; excess > 0 {
+			f.drop(excess)
+		}
+		if f.dropped > 0 {
+			message := fmt.Sprintf("\n[... output truncated, %d bytes of the output log dropped ...]\n", f.dropped)
+			_, err = f.write(outputlog.Append(nil, time.Now(), outputlog.Runner, []byte(message)))
+		}
+		if err == nil {
+			_, err = f.write(f.pending)
+		}
+		f.pending = nil
+	}
+
+	f.closed = true
+	for ch := range f.subscribers {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,299 @@
+package runner
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"internal/outputlog"
+	"internal/pb"
+)
+
+// feedRecord is a record of an output log with its encoded size
+type feedRecord struct {
+	stream string
+	data   string
+	size   int64
+}
+
+// scanRecords returns the records of an output log
+func scanRecords(t *testing.T, log []byte) []feedRecord {
+	t.Helper()
+	if !bytes.HasPrefix(log, []byte(outputlog.Header)) {
+		t.Fatalf("expect an output log, but got %q", log)
+	}
+	var records []feedRecord
+	scanner := outputlog.NewScanner(bytes.NewReader(log[len(outputlog.Header):]), int64(len(outputlog.Header)))
+	offset := scanner.Offset()
+	for scanner.Scan() {
+		record := scanner.Record()
+		records = append(records, feedRecord{record.Stream, string(record.Data), scanner.Offset() - offset})
+		offset = scanner.Offset()
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("cannot scan the output: %v", err)
+	}
+	if offset != int64(len(log)) {
+		t.Errorf("expect the log to end with a complete record, but %d bytes are left", int64(len(log))-offset)
+	}
+	return records
+}
+
+var truncatedPattern = regexp.MustCompile(`^\n\[\.\.\. output truncated, (\d+) bytes of the output log dropped \.\.\.\]\n$`)
+
+func TestOutputFeed(t *testing.T) {
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, fmt.Sprintf("line %03d\n", i))
+	}
+	// the lines have the same length, their records only differ by the
+	// digits of the timestamp
+	t0 := time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC)
+	minSize := int64(len(outputlog.Append(nil, t0, outputlog.Stdout, []byte(lines[0]))))
+	maxSize := int64(len(outputlog.Append(nil, t0.Add(123456789), outputlog.Stdout, []byte(lines[0]))))
+	tests := []struct {
+		name  string
+		limit int64
+		// batch writes all the lines at once rather than one by one
+		batch bool
+		// overflow is set when the output goes past the head, truncated when
+		// it does not fit in the tail either
+		overflow, truncated bool
+	}{
+		{"no limit", 0, false, false, false},
+		{"under the limit", 1 << 20, false, false, false},
+		{"over the limit", 2000, false, true, true},
+		{"a single write over the limit", 2000, true, true, true},
+		{"odd limit", 2001, false, true, true},
+		{"past the head only", int64(len(lines)) * maxSize * 3 / 2, false, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "task_output_1.log")
+			file, err := os.Create(path)
+			if err != nil {
+				t.Fatalf("cannot create the output: %v", err)
+			}
+			feed, err := newOutputFeed(file, test.limit)
+			if err != nil {
+				t.Fatalf("newOutputFeed() should not return error, but got %v", err)
+			}
+			ch, _, ok := feed.Subscribe()
+			if !ok {
+				t.Fatal("expect to subscribe to an open feed")
+			}
+			var data []byte
+			offset := int64(len(outputlog.Header))
+			receive := func(chunk *pb.TaskOutputChunk) {
+				if chunk.Offset != offset || chunk.NextOffset != offset+int64(len(chunk.Data)) {
+					t.Errorf("expect a chunk at offset %d, but got %d to %d", offset, chunk.Offset, chunk.NextOffset)
+				}
+				offset = chunk.NextOffset
+				data = append(data, chunk.Data...)
+			}
+
+			// odd lines go to stderr, the lines are checked in the order written
+			if test.batch {
+				feed.Stream(outputlog.Stdout).Write([]byte(strings.Join(lines, "")))
+			} else {
+				for i, line := range lines {
+					stream := outputlog.Stdout
+					if i%2 == 1 {
+						stream = outputlog.Stderr
+					}
+					if _, err := feed.Stream(stream).Write([]byte(line)); err != nil {
+						t.Fatalf("Write() should not return error, but got %v", err)
+					}
+				}
+			}
+
+			// followers learn that the output is held back while the task is
+			// still running
+			notice := fmt.Sprintf(overflowNotice, test.limit-test.limit/2)
+			for test.overflow && !strings.Contains(runnerMessages(scanRecords(t, append([]byte(outputlog.Header), data...))), notice) {
+				select {
+				case chunk := <-ch:
+					receive(chunk)
+				default:
+					t.Fatalf("expect the overflow notice before the feed is closed, but got %q", data)
+				}
+			}
+
+			if err := feed.Close(); err != nil {
+				t.Fatalf("Close() should not return error, but got %v", err)
+			}
+			if _, err := feed.Stream(outputlog.Stdout).Write([]byte("late\n")); err == nil {
+				t.Error("expect writing to a closed feed to fail")
+			}
+			for chunk := range ch {
+				receive(chunk)
+			}
+
+			log, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("cannot read the output: %v", err)
+			}
+			if !bytes.Equal(data, log[len(outputlog.Header):]) {
+				t.Errorf("expect the subscriber to receive the file after the header, but got %q", data)
+			}
+
+			// the records are a head of the lines, the notice, the marker and
+			// a tail; the messages of the runner take a record per line
+			records := scanRecords(t, log)
+			var head, tail []feedRecord
+			var notices, markers []feedRecord
+			for _, record := range records {
+				switch {
+				case record.stream != outputlog.Runner && len(notices) == 0:
+					head = append(head, record)
+				case record.stream != outputlog.Runner:
+					tail = append(tail, record)
+				case len(tail) == 0 && !strings.Contains(runnerMessages(notices), notice):
+					notices = append(notices, record)
+				default:
+					markers = append(markers, record)
+				}
+			}
+			if !test.overflow {
+				if len(notices) > 0 || len(head) != len(lines) {
+					t.Fatalf("expect the %d lines untruncated, but got %d records", len(lines), len(records))
+				}
+				for i, record := range head {
+					if record.data != lines[i] {
+						t.Errorf("expect record %d to be %q, but got %q", i, lines[i], record.data)
+					}
+				}
+				return
+			}
+			if runnerMessages(notices) != notice {
+				t.Fatalf("expect the overflow notice after the head, but got %q", runnerMessages(notices))
+			}
+			var headSize, tailSize, maxTailSize int64
+			for i, record := range head {
+				if record.data != lines[i] {
+					t.Errorf("expect head record %d to be %q, but got %q", i, lines[i], record.data)
+				}
+				headSize += record.size
+			}
+			dropped := len(lines) - len(head) - len(tail)
+			for i, record := range tail {
+				if want := lines[len(head)+dropped+i]; record.data != want {
+					t.Errorf("expect tail record %d to be %q, but got %q", i, want, record.data)
+				}
+				tailSize += record.size
+				maxTailSize = max(maxTailSize, record.size)
+			}
+			if headSize > test.limit/2 {
+				t.Errorf("expect the head to fit in %d bytes, but got %d", test.limit/2, headSize)
+			}
+			if !test.truncated {
+				if dropped != 0 || len(markers) > 0 {
+					t.Errorf("expect the whole output after the notice, but got %d lines dropped and %q", dropped, runnerMessages(markers))
+				}
+				return
+			}
+			if dropped <= 0 {
+				t.Fatalf("expect lines to be dropped, but got %d in the head and %d in the tail", len(head), len(tail))
+			}
+
+			// the tail is as full as whole records allow
+			if limit := test.limit - test.limit/2; tailSize > limit || tailSize <= limit-maxTailSize {
+				t.Errorf("expect the tail to fill up to %d bytes, but got %d", limit, tailSize)
+			}
+
+			match := truncatedPattern.FindStringSubmatch(runnerMessages(markers))
+			if match == nil {
+				t.Fatalf("expect the truncation marker, but got %q", runnerMessages(markers))
+			}
+			n, _ := strconv.ParseInt(match[1], 10, 64)
+			if n < int64(dropped)*minSize || n > int64(dropped)*maxSize {
+				t.Errorf("expect the %d dropped records to take %d to %d bytes, but got %d", dropped, int64(dropped)*minSize, int64(dropped)*maxSize, n)
+			}
+		})
+	}
+}
+
+// runnerMessages returns the messages of the runner in the records
+func runnerMessages(records []feedRecord) string {
+	message := ""
+	for _, record := range records {
+		if record.stream == outputlog.Runner {
+			message += record.data
+		}
+	}
+	return message
+}
+
+func TestCompressOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "task_output_1.log")
+	log := outputlog.Append([]byte(outputlog.Header), time.Now(), outputlog.Stdout, []byte(strings.Repeat("compressed\n", 1000)))
+	if err := os.WriteFile(path, log, 0644); err != nil {
+		t.Fatalf("cannot write the output: %v", err)
+	}
+
+	compressed, err := compressOutput(path)
+	if err != nil {
+		t.Fatalf("compressOutput() should not return error, but got %v", err)
+	}
+	if compressed != path+compressedOutputSuffix {
+		t.Errorf("expect %s, but got %s", path+compressedOutputSuffix, compressed)
+	}
+	file, err := os.Open(compressed)
+	if err != nil {
+		t.Fatalf("cannot open the compressed output: %v", err)
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("cannot decompress the output: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(data, log) {
+		t.Errorf("expect the compressed output to hold the log, but got %d bytes, %v", len(data), err)
+	}
+
+	if _, err := compressOutput(filepath.Join(t.TempDir(), "missing.log")); err == nil {
+		t.Error("expect compressOutput() to fail without an output")
+	}
+}
+
+func TestRunTaskCompressOutput(t *testing.T) {
+	d := newTestDaemon(t, RunnerOptions{MaxOutputBytes: 4096, CompressOutput: true})
+	d.ignoreWakeUps(t)
+	task := d.createTask(t, &pb.Task{Commandline: "seq 1 10; sleep 0.1; seq 11 2000"})
+
+	if !d.runTask(d.workers[0]) {
+		t.Fatal("expect runTask() to take the task")
+	}
+	task = d.waitFinal(t, task.Id)
+	if task.Status != pb.TaskStatus_FINISHED || task.ReturnCode != 0 {
+		t.Errorf("expect FINISHED with return code 0, but got %s, %d", task.Status, task.ReturnCode)
+	}
+	if !strings.HasSuffix(task.Output, compressedOutputSuffix) {
+		t.Fatalf("expect a compressed output, but got %s", task.Output)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(task.Output, compressedOutputSuffix)); !os.IsNotExist(err) {
+		t.Errorf("expect the compressed output to replace the original, but got %v", err)
+	}
+	attempts, err := d.db.GetTaskAttempts(task.Id)
+	if err != nil || len(attempts) != 1 || attempts[0].Output != task.Output {
+		t.Errorf("expect the attempt to keep the compressed output, but got %v, %v", attempts, err)
+	}
+
+	output := readOutput(t, task.Output)
+	if !strings.HasPrefix(output[outputlog.Stdout], "1\n2\n") || !strings.HasSuffix(output[outputlog.Stdout], "\n1999\n2000\n") {
+		t.Errorf("expect the head and the tail of the output, but got %q", output[outputlog.Stdout])
+	}
+	notice := fmt.Sprintf(overflowNotice, 2048)
+	if marker, ok := strings.CutPrefix(output[outputlog.Runner], notice); !ok || !truncatedPattern.MatchString(marker) {
+		t.Errorf("expect the overflow notice and the truncation marker, but got %q", output[outputlog.Runner])
+	}
+}
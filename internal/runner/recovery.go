@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"internal/pb"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Recover reconciles the tasks that were RUNNING or RETRYING when the server
+// stopped. It must be called before Run, while no task is being executed.
+//
+// RETRYING tasks have lost their backoff timer and are requeued right away.
+//
+// A task without a pid was claimed but never started, so it is requeued
+// whatever its recovery policy. A task whose process is gone is requeued or
+// marked INTERRUPTED according to its recovery policy. A task whose process
+// is still alive cannot be waited on any more, so it is marked INTERRUPTED
+// and the process is left alone rather than risking to signal an unrelated
+// process.
+func (rd *RunnerDaemon) Recover() error {
+	tasks, err := rd.db.GetTasksByStatus(pb.TaskStatus_RUNNING)
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		alive := task.Pid > 0 && processExists(int(task.Pid))
+
+		// keep a record of the attempt that was cut short; a task without a
+		// pid was claimed but never started
+		if task.Pid > 0 {
+			interrupted := proto.Clone(task).(*pb.Task)
+			interrupted.Status = pb.TaskStatus_INTERRUPTED
+			interrupted.ReturnCode = -1
+			interrupted.FinishTime = timestamppb.New(time.Now())
+			rd.recordAttempt(interrupted)
+		}
+
+		if task.Pid == 0 || !alive && task.RecoveryPolicy == pb.RecoveryPolicy_REQUEUE {
+			if task.Pid == 0 {
+				log.Printf("recovery: task %d was claimed but never started, requeueing it", task.Id)
+			} else {
+				log.Printf("recovery: task %d (pid %d) is gone, requeueing it", task.Id, task.Pid)
+			}
+			task.Status = pb.TaskStatus_NEW
+			task.StartTime = nil
+			task.Pid = 0
+		} else {
+			if alive {
+				log.Printf("recovery: task %d is orphaned but process %d still exists, marking it INTERRUPTED", task.Id, task.Pid)
+			} else {
+				log.Printf("recovery: task %d (pid %d) is gone, marking it INTERRUPTED", task.Id, task.Pid)
+			}
+			task.Status = pb.TaskStatus_INTERRUPTED
+			task.ReturnCode = -1
+			task.FinishTime = timestamppb.New(time.Now())
+			task.Pid = 0
+		}
+
+		if _, err := rd.db.UpdateTask(task); err != nil {
+			return err
+		}
+	}
+
+	retrying, err := rd.db.GetTasksByStatus(pb.TaskStatus_RETRYING)
+	if err != nil {
+		return err
+	}
+	for _, task := range retrying {
+		log.Printf("recovery: task %d was waiting to be retried, requeueing it", task.Id)
+		if _, err := rd.db.UpdateTaskStatus(task.Id, pb.TaskStatus_RETRYING, pb.TaskStatus_NEW); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	if err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	// a zombie has exited already, it is only waiting to be reaped
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err == nil {
+		// the state follows the command name, which is in parentheses
+		if i := bytes.LastIndexByte(stat, ')'); i >= 0 && i+2 < len(stat) && stat[i+2] == 'Z' {
+			return false
+		}
+	}
+	return true
+}
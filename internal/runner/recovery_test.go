@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"internal/pb"
+)
+
+// deadPid returns the pid of a process that has exited and been reaped
+func deadPid(t *testing.T) int32 {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("cannot run true: %v", err)
+	}
+	return int32(cmd.Process.Pid)
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   pb.TaskStatus
+		pid      int32
+		policy   pb.RecoveryPolicy
+		expect   pb.TaskStatus
+		attempts int
+	}{
+		{"never started", pb.TaskStatus_RUNNING, 0, pb.RecoveryPolicy_MARK_INTERRUPTED, pb.TaskStatus_NEW, 0},
+		{"gone and requeued", pb.TaskStatus_RUNNING, deadPid(t), pb.RecoveryPolicy_REQUEUE, pb.TaskStatus_NEW, 1},
+		{"gone and interrupted", pb.TaskStatus_RUNNING, deadPid(t), pb.RecoveryPolicy_MARK_INTERRUPTED, pb.TaskStatus_INTERRUPTED, 1},
+		{"still alive", pb.TaskStatus_RUNNING, int32(os.Getpid()), pb.RecoveryPolicy_REQUEUE, pb.TaskStatus_INTERRUPTED, 1},
+		{"retrying", pb.TaskStatus_RETRYING, 0, pb.RecoveryPolicy_MARK_INTERRUPTED, pb.TaskStatus_NEW, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestDaemon(t, RunnerOptions{})
+			task := d.createTask(t, &pb.Task{Commandline: "sleep 30", RecoveryPolicy: test.policy})
+			task.Status = test.status
+			task.Pid = test.pid
+			task.Attempt = 1
+			if _, err := d.db.UpdateTask(task); err != nil {
+				t.Fatalf("UpdateTask() should not return error, but got %v", err)
+			}
+
+			if err := d.Recover(); err != nil {
+				t.Fatalf("Recover() should not return error, but got %v", err)
+			}
+			task, err := d.db.GetTask(task.Id)
+			if err != nil {
+				t.Fatalf("GetTask() should not return error, but got %v", err)
+			}
+			if task.Status != test.expect || task.Pid != 0 {
+				t.Errorf("expect %s without a pid, but got %s, pid %d", test.expect, task.Status, task.Pid)
+			}
+			if test.expect == pb.TaskStatus_INTERRUPTED && task.ReturnCode != -1 {
+				t.Errorf("expect return code -1, but got %d", task.ReturnCode)
+			}
+			attempts, err := d.db.GetTaskAttempts(task.Id)
+			if err != nil || len(attempts) != test.attempts {
+				t.Errorf("expect %d attempts, but got %v, %v", test.attempts, attempts, err)
+			}
+			for _, attempt := range attempts {
+				if attempt.Status != pb.TaskStatus_INTERRUPTED {
+					t.Errorf("expect the attempt to be INTERRUPTED, but got %s", attempt.Status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"internal/pb"
+)
+
+// retryDelay decides whether a finished task is attempted again according
+// to its retry policy, and how long to wait before the next attempt.
+func retryDelay(task *pb.Task) (time.Duration, bool) {
+	policy := task.GetRetryPolicy()
+	if task.Status != pb.TaskStatus_FINISHED || task.ReturnCode == 0 {
+		return 0, false
+	}
+	if task.Attempt >= policy.GetMaxAttempts() {
+		return 0, false
+	}
+	if codes := policy.GetRetryOnExitCodes(); len(codes) > 0 {
+		found := false
+		for _, code := range codes {
+			if code == task.ReturnCode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+
+	multiplier := policy.GetBackoffMultiplier()
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+	delay := time.Duration(float64(policy.GetBackoff().AsDuration()) * math.Pow(multiplier, float64(task.Attempt-1)))
+	if maxBackoff := policy.GetMaxBackoff().AsDuration(); maxBackoff > 0 && delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay, true
+}
+
+// recordAttempt stores the execution that just ended as an attempt of the task
+func (rd *RunnerDaemon) recordAttempt(task *pb.Task) {
+	attempt := &pb.TaskAttempt{
+		TaskId:        task.Id,
+		Attempt:       task.Attempt,
+		Status:        task.Status,
+		ReturnCode:    task.ReturnCode,
+		Output:        task.Output,
+		StartTime:     task.StartTime,
+		FinishTime:    task.FinishTime,
+		ExecutionTime: task.ExecutionTime,
+	}
+	if _, err := rd.db.CreateTaskAttempt(attempt); err != nil {
+		log.Printf("Failed to record attempt %d of task %d: %v", task.Attempt, task.Id, err)
+	}
+}
+
+// scheduleRetry puts a RETRYING task back in the queue once the delay has
+// passed, unless it has been cancelled in the meantime.
+func (rd *RunnerDaemon) scheduleRetry(id int64, delay time.Duration) {
+	log.Printf("task %d will be retried in %v", id, delay)
+	time.AfterFunc(delay, func() {
+		requeued, err := rd.db.UpdateTaskStatus(id, pb.TaskStatus_RETRYING, pb.TaskStatus_NEW)
+		if err != nil {
+			log.Printf("Failed to requeue task %d for retry: %v", id, err)
+			return
+		}
+		if !requeued {
+			return
+		}
+
+		task, err := rd.db.GetTask(id)
+		if err != nil {
+			log.Printf("Failed to get task %d: %v", id, err)
+		} else {
+			rd.taskChan <- task
+		}
+		rd.incomingChan <- true
+	})
+}
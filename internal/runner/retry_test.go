@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := &pb.RetryPolicy{
+		MaxAttempts:       4,
+		Backoff:           durationpb.New(time.Second),
+		BackoffMultiplier: 3,
+		MaxBackoff:        durationpb.New(5 * time.Second),
+	}
+	tests := []struct {
+		name       string
+		status     pb.TaskStatus
+		returnCode int32
+		attempt    int32
+		policy     *pb.RetryPolicy
+		delay      time.Duration
+		retry      bool
+	}{
+		{"no policy", pb.TaskStatus_FINISHED, 1, 1, nil, 0, false},
+		{"succeeded", pb.TaskStatus_FINISHED, 0, 1, policy, 0, false},
+		{"cancelled", pb.TaskStatus_CANCELLED, -1, 1, policy, 0, false},
+		{"timed out", pb.TaskStatus_TIMED_OUT, -1, 1, policy, 0, false},
+		{"first retry", pb.TaskStatus_FINISHED, 1, 1, policy, time.Second, true},
+		{"multiplied", pb.TaskStatus_FINISHED, 1, 2, policy, 3 * time.Second, true},
+		{"max backoff", pb.TaskStatus_FINISHED, 1, 3, policy, 5 * time.Second, true},
+		{"max attempts", pb.TaskStatus_FINISHED, 1, 4, policy, 0, false},
+		{"no multiplier", pb.TaskStatus_FINISHED, 1, 3, &pb.RetryPolicy{MaxAttempts: 4, Backoff: durationpb.New(time.Second)}, time.Second, true},
+		{"retried exit code", pb.TaskStatus_FINISHED, 3, 1, &pb.RetryPolicy{MaxAttempts: 2, RetryOnExitCodes: []int32{2, 3}}, 0, true},
+		{"other exit code", pb.TaskStatus_FINISHED, 1, 1, &pb.RetryPolicy{MaxAttempts: 2, RetryOnExitCodes: []int32{2, 3}}, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := &pb.Task{Status: test.status, ReturnCode: test.returnCode, Attempt: test.attempt, RetryPolicy: test.policy}
+			delay, retry := retryDelay(task)
+			if delay != test.delay || retry != test.retry {
+				t.Errorf("expect retryDelay() to return %v, %v, but got %v, %v", test.delay, test.retry, delay, retry)
+			}
+		})
+	}
+}
+
+func TestRunnerDaemonRetry(t *testing.T) {
+	d := newTestDaemon(t, RunnerOptions{})
+	task := d.createTask(t, &pb.Task{
+		Commandline: "exit 3",
+		RetryPolicy: &pb.RetryPolicy{
+			MaxAttempts:       3,
+			Backoff:           durationpb.New(100 * time.Millisecond),
+			BackoffMultiplier: 2,
+			RetryOnExitCodes:  []int32{3},
+		},
+	})
+	d.start(t)
+
+	task = d.waitFinal(t, task.Id)
+	if task.Status != pb.TaskStatus_FINISHED || task.ReturnCode != 3 || task.Attempt != 3 {
+		t.Errorf("expect the last attempt to finish with return code 3, but got %s, %d after %d attempts", task.Status, task.ReturnCode, task.Attempt)
+	}
+	retrying := 0
+	for _, status := range d.statuses(task.Id) {
+		if status == pb.TaskStatus_RETRYING {
+			retrying++
+		}
+	}
+	if retrying != 2 {
+		t.Errorf("expect the task to be RETRYING twice, but got %d", retrying)
+	}
+
+	attempts, err := d.db.GetTaskAttempts(task.Id)
+	if err != nil || len(attempts) != 3 {
+		t.Fatalf("expect 3 attempts, but got %v, %v", attempts, err)
+	}
+	// the attempts are spaced by the backoff, which doubles
+	for i, backoff := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		previous, next := attempts[i], attempts[i+1]
+		if previous.Attempt != int32(i+1) || previous.Status != pb.TaskStatus_FINISHED || previous.ReturnCode != 3 {
+			t.Errorf("expect attempt %d to finish with return code 3, but got %v", i+1, previous)
+		}
+		if gap := next.StartTime.AsTime().Sub(previous.FinishTime.AsTime()); gap < backoff {
+			t.Errorf("expect attempt %d to start %v after the previous one, but got %v", i+2, backoff, gap)
+		}
+	}
+}
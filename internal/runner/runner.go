@@ -1,20 +1,96 @@
 package runner
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"sort"
+	"sync"
+	"syscall"
 	"time"
 
+	"internal/outputlog"
 	"internal/pb"
 
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-func Run(task *pb.Task) (<-chan *pb.Task, error) {
-	cmd := exec.Command("sh", "-c", task.Commandline)
+// outputDrainTimeout is how long the output of a task is still collected
+// after its process exited, in case it left children behind that hold on to
+// the output
+const outputDrainTimeout = 5 * time.Second
+
+// Execution is a started task. The task is sent on Updates when it starts
+// running and once more when it is done; nil is sent if waiting on the
+// process failed.
+type Execution struct {
+	Updates <-chan *pb.Task
+
+	cmd        *exec.Cmd
+	output     *outputFeed
+	terminal   *terminal
+	mu         sync.Mutex
+	stopping   bool
+	stopStatus pb.TaskStatus
+	// exited is set once the process has been reaped, killTimer sends
+	// SIGKILL at the end of the grace period
+	exited    bool
+	killTimer *time.Timer
+}
+
+// command builds the command of the task according to its shell and
+// environment settings
+func command(task *pb.Task) (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	switch task.Shell {
+	case pb.Shell_SH:
+		cmd = exec.Command("sh", "-c", task.Commandline)
+	case pb.Shell_BASH:
+		cmd = exec.Command("bash", "-c", task.Commandline)
+	case pb.Shell_EXEC:
+		if len(task.Argv) == 0 {
+			return nil, fmt.Errorf("task %d has no argv to execute", task.Id)
+		}
+		cmd = exec.Command(task.Argv[0], task.Argv[1:]...)
+	default:
+		return nil, fmt.Errorf("task %d has unknown shell %v", task.Id, task.Shell)
+	}
+
+	if task.ClearEnv || len(task.Env) > 0 {
+		env := []string{}
+		if !task.ClearEnv {
+			env = os.Environ()
+		}
+		keys := make([]string, 0, len(task.Env))
+		for k := range task.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			env = append(env, k+"="+task.Env[k])
+		}
+		cmd.Env = env
+	}
+
+	return cmd, nil
+}
+
+// Run starts the task, writing its output log to the file at task.Output.
+// maxOutputBytes limits the size of the output, see outputFeed; 0 for no
+// limit.
+func Run(task *pb.Task, maxOutputBytes int64) (*Execution, error) {
+	cmd, err := command(task)
+	if err != nil {
+		return nil, err
+	}
 	cmd.Dir = task.WorkingDirectory
+	// run the command in its own process group so that terminating the task
+	// also terminates everything the shell started
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	outputPathAbsolute := task.GetOutput()
 	outputFile, err := os.OpenFile(outputPathAbsolute, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
@@ -22,26 +98,58 @@ func Run(task *pb.Task) (<-chan *pb.Task, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer outputFile.Close()
 
-	cmd.Stdout = outputFile
-	cmd.Stderr = outputFile
-
-	err = cmd.Start()
+	output, err := newOutputFeed(outputFile, maxOutputBytes)
+	if err != nil {
+		outputFile.Close()
+		return nil, err
+	}
+	var term *terminal
+	if task.Interactive {
+		// the terminal merges stdout and stderr
+		term, err = startTerminal(cmd, output.Stream(outputlog.Stdout))
+	} else {
+		cmd.Stdout = output.Stream(outputlog.Stdout)
+		cmd.Stderr = output.Stream(outputlog.Stderr)
+		cmd.WaitDelay = outputDrainTimeout
+		err = cmd.Start()
+	}
 	if err != nil {
+		output.Close()
 		return nil, err
 	}
 
 	ch := make(chan *pb.Task)
+	e := &Execution{
+		Updates:  ch,
+		cmd:      cmd,
+		output:   output,
+		terminal: term,
+	}
 
 	go func() {
 		defer close(ch)
+		defer output.Close()
 		startTime := time.Now()
 		task.StartTime = timestamppb.New(startTime)
 		task.Status = pb.TaskStatus_RUNNING
-		ch <- task
+		task.Pid = int32(cmd.Process.Pid)
+		// a copy, as the task is updated again once the process exits
+		ch <- proto.Clone(task).(*pb.Task)
 		err := cmd.Wait()
-		if err != nil {
+		e.exit()
+		if term != nil {
+			term.Close(outputDrainTimeout)
+		}
+		// the output is complete before the task is seen as done
+		if err := output.Close(); err != nil {
+			log.Printf("Task %d failed to write its output: %v", task.Id, err)
+		}
+		var exitErr *exec.ExitError
+		if errors.Is(err, exec.ErrWaitDelay) {
+			log.Printf("Task %d exited but left processes behind, stopped collecting their output", task.Id)
+		} else if err != nil && !errors.As(err, &exitErr) {
+			log.Printf("Task %d failed to wait for process: %v", task.Id, err)
 			ch <- nil
 			return
 		}
@@ -49,10 +157,76 @@ func Run(task *pb.Task) (<-chan *pb.Task, error) {
 		task.FinishTime = timestamppb.New(finishTime)
 		task.ReturnCode = int32(cmd.ProcessState.ExitCode())
 		task.ExecutionTime = durationpb.New(finishTime.Sub(startTime))
-		task.Status = pb.TaskStatus_FINISHED
-		log.Printf("Task %d finished with return code %d", task.Id, task.ReturnCode)
+		task.Status = e.finalStatus()
+		task.Pid = 0
+		log.Printf("Task %d %s with return code %d", task.Id, task.Status, task.ReturnCode)
 		ch <- task
 	}()
 
-	return ch, nil
+	return e, nil
+}
+
+// SubscribeOutput follows the output of the task, see outputFeed.Subscribe
+func (e *Execution) SubscribeOutput() (<-chan *pb.TaskOutputChunk, func(), bool) {
+	return e.output.Subscribe()
+}
+
+// Terminate sends SIGTERM to the process group of the task, and SIGKILL if it
+// is still alive after the grace period. The task will finish with status
+// unless it has exited already.
+func (e *Execution) Terminate(status pb.TaskStatus, grace time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopping || e.exited {
+		return
+	}
+	e.stopping = true
+	e.stopStatus = status
+
+	pgid := e.cmd.Process.Pid
+	log.Printf("sending SIGTERM to process group %d", pgid)
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		log.Printf("failed to send SIGTERM to process group %d: %v", pgid, err)
+	}
+	e.killTimer = time.AfterFunc(grace, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if !e.exited {
+			e.killGroup(fmt.Sprintf("still alive after %v", grace))
+		}
+	})
+}
+
+// exit is called once the process has been reaped. From then on the ID of
+// its group may be reused as soon as the group is empty, so the group must
+// not be signalled later on. A task being terminated may have left children
+// behind, e.g. when the shell exits on SIGTERM but they do not; they are
+// killed right away instead of at the end of the grace period.
+func (e *Execution) exit() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.exited = true
+	if e.killTimer != nil && e.killTimer.Stop() {
+		e.killGroup("left behind by the task")
+	}
+}
+
+// killGroup sends SIGKILL to the process group of the task, with e.mu held
+func (e *Execution) killGroup(reason string) {
+	pgid := e.cmd.Process.Pid
+	err := syscall.Kill(-pgid, syscall.SIGKILL)
+	if err == nil {
+		log.Printf("process group %d %s, sent SIGKILL", pgid, reason)
+	} else if err != syscall.ESRCH {
+		log.Printf("failed to send SIGKILL to process group %d: %v", pgid, err)
+	}
+}
+
+func (e *Execution) finalStatus() pb.TaskStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopping {
+		return e.stopStatus
+	}
+	return pb.TaskStatus_FINISHED
 }
@@ -1,19 +1,51 @@
 package runner
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"internal/db"
+	"internal/outputlog"
 	"internal/pb"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
 	outputDir = "tmp/output"
+
+	DefaultCancelGracePeriod = 10 * time.Second
+	DefaultMaxConcurrency    = 4
 )
 
+// RunnerOptions configures a RunnerDaemon
+type RunnerOptions struct {
+	// CancelGracePeriod is how long a cancelled task gets between SIGTERM
+	// and SIGKILL
+	CancelGracePeriod time.Duration
+	// MaxConcurrency is the number of tasks that may run at the same time
+	MaxConcurrency int
+	// MaxOutputBytes limits the output of the tasks that have no limit of
+	// their own, 0 for no limit
+	MaxOutputBytes int64
+	// CompressOutput gzips the output of the tasks once they finish
+	CompressOutput bool
+}
+
+// worker runs one task at a time
+type worker struct {
+	id       int32
+	taskID   int64
+	since    time.Time
+	finished int64
+}
+
 type RunnerDaemon struct {
 	TaskChan     <-chan *pb.Task
 	IncomingChan chan<- bool
@@ -24,13 +56,21 @@ type RunnerDaemon struct {
 	exitChan     chan bool
 	db           db.TaskDatabase
 	outputDir    string
+	options      RunnerOptions
+
+	mu      sync.Mutex
+	workers []*worker
+	// running maps the IDs of claimed tasks to their executions. The
+	// execution is nil while the task is being started.
+	running       map[int64]*Execution
+	pendingCancel map[int64]bool
 }
 
 func (rd *RunnerDaemon) Close() {
 	close(rd.exitChan)
 }
 
-func NewRunnerDaemon(db db.TaskDatabase) *RunnerDaemon {
+func NewRunnerDaemon(db db.TaskDatabase, options RunnerOptions) *RunnerDaemon {
 	taskChan := make(chan *pb.Task)
 	incomingChan := make(chan bool)
 	exitChan := make(chan bool, 2)
@@ -39,6 +79,13 @@ func NewRunnerDaemon(db db.TaskDatabase) *RunnerDaemon {
 		dir = filepath.Join(os.Getenv("HOME"), outputDir)
 	}
 	log.Printf("output directory: %v", dir)
+	if options.MaxConcurrency <= 0 {
+		options.MaxConcurrency = 1
+	}
+	workers := make([]*worker, options.MaxConcurrency)
+	for i := range workers {
+		workers[i] = &worker{id: int32(i), since: time.Now()}
+	}
 	return &RunnerDaemon{
 		TaskChan:     taskChan,
 		IncomingChan: incomingChan,
@@ -49,40 +96,221 @@ func NewRunnerDaemon(db db.TaskDatabase) *RunnerDaemon {
 		exitChan:     exitChan,
 		db:           db,
 		outputDir:    dir,
+		options:      options,
+
+		workers:       workers,
+		running:       make(map[int64]*Execution),
+		pendingCancel: make(map[int64]bool),
+	}
+}
+
+// CancelTask terminates the task if it is running in this daemon. It reports
+// whether the task was found; the final status is recorded once the process
+// has exited.
+func (rd *RunnerDaemon) CancelTask(id int64) bool {
+	rd.mu.Lock()
+	execution, ok := rd.running[id]
+	if ok && execution == nil {
+		// the process is being started, the worker will terminate it
+		rd.pendingCancel[id] = true
+	}
+	rd.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if execution == nil {
+		log.Printf("cancelling task %d once it has started", id)
+		return true
+	}
+
+	log.Printf("cancelling task %d", id)
+	execution.Terminate(pb.TaskStatus_CANCELLED, rd.options.CancelGracePeriod)
+	return true
+}
+
+// SubscribeOutput follows the output of a task running in this daemon. It
+// returns the path of the output file being written, a channel receiving the
+// chunks written from now on and a function cancelling the subscription. It
+// reports false if the task is not running.
+func (rd *RunnerDaemon) SubscribeOutput(id int64) (string, <-chan *pb.TaskOutputChunk, func(), bool) {
+	rd.mu.Lock()
+	execution := rd.running[id]
+	rd.mu.Unlock()
+	if execution == nil {
+		return "", nil, nil, false
+	}
+
+	chunks, cancel, ok := execution.SubscribeOutput()
+	if !ok {
+		return "", nil, nil, false
 	}
+	return execution.output.file.Name(), chunks, cancel, true
 }
 
-// Run waits on the channel
+// WriteInput types data into the terminal of a running interactive task
+func (rd *RunnerDaemon) WriteInput(id int64, data []byte) error {
+	execution, err := rd.execution(id)
+	if err != nil {
+		return err
+	}
+	return execution.WriteInput(data)
+}
+
+// ResizeTerminal changes the terminal size of a running interactive task
+func (rd *RunnerDaemon) ResizeTerminal(id int64, rows, cols uint16) error {
+	execution, err := rd.execution(id)
+	if err != nil {
+		return err
+	}
+	return execution.ResizeTerminal(rows, cols)
+}
+
+func (rd *RunnerDaemon) execution(id int64) (*Execution, error) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	execution := rd.running[id]
+	if execution == nil {
+		return nil, fmt.Errorf("task %d is not running", id)
+	}
+	return execution, nil
+}
+
+// Workers returns the status of every worker
+func (rd *RunnerDaemon) Workers() []*pb.WorkerStatus {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	statuses := make([]*pb.WorkerStatus, 0, len(rd.workers))
+	for _, w := range rd.workers {
+		statuses = append(statuses, &pb.WorkerStatus{
+			Id:            w.id,
+			Busy:          w.taskID != 0,
+			TaskId:        w.taskID,
+			Since:         timestamppb.New(w.since),
+			FinishedTasks: w.finished,
+		})
+	}
+	return statuses
+}
+
+// OutputDir returns the directory the output files of the tasks are created in
+func (rd *RunnerDaemon) OutputDir() string {
+	return rd.outputDir
+}
+
+// Run waits on the channel and wakes up idle workers when tasks come in
 func (rd *RunnerDaemon) Run() {
+	wakeChan := make(chan bool, len(rd.workers))
+	stopChan := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, w := range rd.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rd.work(w, wakeChan, stopChan)
+		}()
+	}
+	defer wg.Wait()
+	defer close(stopChan)
+
+	// pick up the tasks left in the queue before the daemon started
+	for range rd.workers {
+		wakeChan <- true
+	}
+
 	for {
+		// tasks that must not start yet do not send events when their time
+		// comes, so wake up the workers then
 		select {
 		case <-rd.exitChan:
 			return
 		case <-rd.incomingChan:
-			rd.runTask()
+		case <-rd.notBeforeTimer():
+		}
+		for range rd.workers {
+			select {
+			case wakeChan <- true:
+			default:
+			}
 		}
 	}
 }
 
-func (rd *RunnerDaemon) runTask() {
-	task, err := rd.db.GetLatestTask()
+// notBeforeTimer returns a channel receiving when the next task waiting for
+// its not_before time may start. The channel is nil if no task is waiting.
+func (rd *RunnerDaemon) notBeforeTimer() <-chan time.Time {
+	next, err := rd.db.GetNextNotBefore()
 	if err != nil {
-		log.Printf("failed to get latest task to execute: %v", err)
 		if _, ok := err.(*db.ErrNoRows); !ok {
+			log.Printf("failed to get the next not_before time: %v", err)
+			return time.After(5 * time.Second)
+		}
+		return nil
+	}
+	return time.After(time.Until(next))
+}
+
+// work runs tasks until there are none left, then waits to be woken up
+func (rd *RunnerDaemon) work(w *worker, wakeChan <-chan bool, stopChan <-chan struct{}) {
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-wakeChan:
+			for rd.runTask(w) {
+			}
+		}
+	}
+}
+
+func (rd *RunnerDaemon) setWorkerTask(w *worker, taskID int64) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if taskID == 0 {
+		w.finished++
+	}
+	w.taskID = taskID
+	w.since = time.Now()
+}
+
+// runTask claims the next task and runs it to completion. It reports whether
+// a task was run.
+func (rd *RunnerDaemon) runTask(w *worker) bool {
+	// the task is registered before CancelTask can see it RUNNING, which
+	// would otherwise report it as not running in this daemon
+	rd.mu.Lock()
+	task, err := rd.db.ClaimNextTask()
+	if err == nil {
+		rd.running[task.Id] = nil
+	}
+	rd.mu.Unlock()
+	if err != nil {
+		if _, ok := err.(*db.ErrNoRows); !ok {
+			log.Printf("failed to claim a task to execute: %v", err)
 			rd.retry()
 		}
-		return
+		return false
 	}
+	defer func() {
+		rd.mu.Lock()
+		delete(rd.running, task.Id)
+		delete(rd.pendingCancel, task.Id)
+		rd.mu.Unlock()
+	}()
+	rd.setWorkerTask(w, task.Id)
+	defer rd.setWorkerTask(w, 0)
 
-	log.Printf("got task %s", task.AsJsonString())
+	log.Printf("worker %d got task %s", w.id, task.AsJsonString())
 
+	// every attempt writes to its own output file
+	task.Attempt++
 	output := task.GetOutput()
-	if len(output) == 0 {
+	if len(output) == 0 || task.Attempt > 1 {
 		tempFile, err := os.CreateTemp(rd.outputDir, "task_output_*.log")
 		if err != nil {
 			log.Printf("failed to create temporary file: %v", err)
-			rd.retry()
-			return
+			rd.requeue(task)
+			return false
 		}
 		tempFile.Close()
 		log.Printf("tempFile path is: %s", tempFile.Name())
@@ -90,37 +318,140 @@ func (rd *RunnerDaemon) runTask() {
 	}
 
 	log.Printf("Executing task %v", task.AsJsonString())
-	receivingChan, err := Run(task)
+	maxOutputBytes := task.GetMaxOutputBytes()
+	if maxOutputBytes == 0 {
+		maxOutputBytes = rd.options.MaxOutputBytes
+	}
+	execution, err := Run(task, maxOutputBytes)
 	if err != nil {
 		log.Printf("failed to execute task %v, error: %v", task, err)
-		rd.retry()
-		return
+		rd.failStart(task, err)
+		return true
+	}
+	rd.mu.Lock()
+	rd.running[task.Id] = execution
+	cancelled := rd.pendingCancel[task.Id]
+	rd.mu.Unlock()
+	if cancelled {
+		execution.Terminate(pb.TaskStatus_CANCELLED, rd.options.CancelGracePeriod)
 	}
-	task2 := <-receivingChan
+	if timeout := task.GetTimeout().AsDuration(); timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			log.Printf("task %d timed out after %v", task.Id, timeout)
+			execution.Terminate(pb.TaskStatus_TIMED_OUT, rd.options.CancelGracePeriod)
+		})
+		defer timer.Stop()
+	}
+
+	task2 := <-execution.Updates
 
 	log.Printf("Updating task status to RUNNING: %v", task2.AsJsonString())
 	_, err = rd.db.UpdateTask(task2)
 	if err != nil {
 		log.Printf("Failed to update task status to RUNNING: %v", err)
 	}
+	rd.taskChan <- proto.Clone(task2).(*pb.Task)
 
-	task3 := <-receivingChan
+	task3 := <-execution.Updates
 	if task3 == nil {
 		log.Printf("task failed to execute: %v", task)
+		task.Status = pb.TaskStatus_NEW
+		task.Pid = 0
 		_, err = rd.db.UpdateTask(task)
 		if err != nil {
 			log.Printf("Failed to update task status to NEW: %v", err)
 		}
 		rd.retry()
-		return
+		return false
+	}
+	rd.finishAttempt(task3)
+	return true
+}
+
+// failStart ends an attempt whose process could not be started, e.g. because
+// its executable is missing or its working directory was removed after the
+// task was verified. Starting it again would most likely fail the same way,
+// so the attempt fails with the error as its output and the retry policy
+// decides whether there is another one.
+func (rd *RunnerDaemon) failStart(task *pb.Task, startErr error) {
+	rd.mu.Lock()
+	cancelled := rd.pendingCancel[task.Id]
+	rd.mu.Unlock()
+
+	now := time.Now()
+	message := fmt.Sprintf("failed to start the task: %v\n", startErr)
+	output := outputlog.Append([]byte(outputlog.Header), now, outputlog.Runner, []byte(message))
+	if err := os.WriteFile(task.Output, output, 0644); err != nil {
+		log.Printf("failed to write the output of task %d: %v", task.Id, err)
+	}
+
+	task.Status = pb.TaskStatus_FINISHED
+	if cancelled {
+		task.Status = pb.TaskStatus_CANCELLED
+	}
+	task.ReturnCode = -1
+	task.Pid = 0
+	task.StartTime = timestamppb.New(now)
+	task.FinishTime = timestamppb.New(now)
+	task.ExecutionTime = durationpb.New(0)
+	rd.finishAttempt(task)
+}
+
+// finishAttempt records the attempt that just ended and stores the task,
+// either final or RETRYING if its retry policy asks for another attempt
+func (rd *RunnerDaemon) finishAttempt(task *pb.Task) {
+	uncompressed := ""
+	if rd.options.CompressOutput {
+		path, err := compressOutput(task.Output)
+		if err != nil {
+			log.Printf("failed to compress the output of task %d: %v", task.Id, err)
+		} else {
+			uncompressed = task.Output
+			task.Output = path
+		}
 	}
-	log.Printf("Updating task status to FINISHED: %v", task3.AsJsonString())
-	_, err = rd.db.UpdateTask(task3)
+	rd.recordAttempt(task)
+	delay, retry := retryDelay(task)
+	if retry {
+		task.Status = pb.TaskStatus_RETRYING
+	}
+
+	log.Printf("Updating task status to %s: %v", task.Status, task.AsJsonString())
+	_, err := rd.db.UpdateTask(task)
 	if err != nil {
-		log.Printf("Failed to update task status to FINISHED: %v", err)
+		log.Printf("Failed to update task status to %s: %v", task.Status, err)
+	} else if len(uncompressed) > 0 {
+		// readers that saw the task running may still be reading it
+		os.Remove(uncompressed)
+	}
+
+	rd.taskChan <- proto.Clone(task).(*pb.Task)
+	if retry {
+		rd.scheduleRetry(task.Id, delay)
 	}
+}
+
+// requeue gives a claimed task back to the queue when the runner could not
+// prepare it, unless it has been cancelled in the meantime
+func (rd *RunnerDaemon) requeue(task *pb.Task) {
+	rd.mu.Lock()
+	cancelled := rd.pendingCancel[task.Id]
+	rd.mu.Unlock()
 
-	rd.taskChan <- task
+	status := pb.TaskStatus_NEW
+	if cancelled {
+		status = pb.TaskStatus_CANCELLED
+	}
+	_, err := rd.db.UpdateTaskStatus(task.Id, pb.TaskStatus_RUNNING, status)
+	if err != nil {
+		log.Printf("Failed to update task status to %s: %v", status, err)
+	}
+	if cancelled {
+		task.Status = status
+		rd.taskChan <- proto.Clone(task).(*pb.Task)
+		return
+	}
+	rd.retry()
 }
 
 func (rd *RunnerDaemon) retry() {
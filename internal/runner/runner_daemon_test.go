@@ -0,0 +1,270 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"internal/db"
+	"internal/outputlog"
+	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// testDaemon is a daemon on a fresh database that records the tasks it
+// sends on TaskChan
+type testDaemon struct {
+	*RunnerDaemon
+	db db.TaskDatabase
+
+	mu      sync.Mutex
+	updates []*pb.Task
+}
+
+func newTestDaemon(t *testing.T, options RunnerOptions) *testDaemon {
+	taskDB, err := db.NewTaskDatabase(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("NewTaskDatabase() should not return error, but got %v", err)
+	}
+	if err := taskDB.Init(); err != nil {
+		t.Fatalf("Init() should not return error, but got %v", err)
+	}
+	t.Cleanup(func() { taskDB.Uninit() })
+
+	d := &testDaemon{RunnerDaemon: NewRunnerDaemon(taskDB, options), db: taskDB}
+	d.outputDir = t.TempDir()
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case task := <-d.TaskChan:
+				d.mu.Lock()
+				d.updates = append(d.updates, task)
+				d.mu.Unlock()
+			}
+		}
+	}()
+	return d
+}
+
+// start runs the daemon until the end of the test
+func (d *testDaemon) start(t *testing.T) {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		d.Run()
+	}()
+	t.Cleanup(func() {
+		d.Close()
+		<-stopped
+	})
+}
+
+// ignoreWakeUps drops the wake-ups meant for Run, for the tests that call
+// runTask themselves
+func (d *testDaemon) ignoreWakeUps(t *testing.T) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-d.incomingChan:
+			}
+		}
+	}()
+}
+
+// statuses returns the statuses of the task sent on TaskChan
+func (d *testDaemon) statuses(id int64) []pb.TaskStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var statuses []pb.TaskStatus
+	for _, task := range d.updates {
+		if task.Id == id {
+			statuses = append(statuses, task.Status)
+		}
+	}
+	return statuses
+}
+
+func (d *testDaemon) createTask(t *testing.T, task *pb.Task) *pb.Task {
+	t.Helper()
+	if len(task.WorkingDirectory) == 0 {
+		task.WorkingDirectory = t.TempDir()
+	}
+	task, err := d.db.CreateTask(task)
+	if err != nil {
+		t.Fatalf("CreateTask() should not return error, but got %v", err)
+	}
+	return task
+}
+
+// waitFinal waits until the task is final in the database
+func (d *testDaemon) waitFinal(t *testing.T, id int64) *pb.Task {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		task, err := d.db.GetTask(id)
+		if err != nil {
+			t.Fatalf("GetTask() should not return error, but got %v", err)
+		}
+		if task.Status.IsFinal() {
+			return task
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect task %d to be final, but it is %s", id, task.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunnerDaemonWorkers(t *testing.T) {
+	d := newTestDaemon(t, RunnerOptions{MaxConcurrency: 2})
+	var tasks []*pb.Task
+	for i := 0; i < 5; i++ {
+		tasks = append(tasks, d.createTask(t, &pb.Task{Commandline: "sleep 0.3"}))
+	}
+	d.start(t)
+
+	type interval struct{ start, finish time.Time }
+	var intervals []interval
+	for i, task := range tasks {
+		tasks[i] = d.waitFinal(t, task.Id)
+		task = tasks[i]
+		if task.Status != pb.TaskStatus_FINISHED || task.ReturnCode != 0 || task.Attempt != 1 {
+			t.Errorf("expect task %d to run once and succeed, but got %s, %d after %d attempts", task.Id, task.Status, task.ReturnCode, task.Attempt)
+		}
+		intervals = append(intervals, interval{task.StartTime.AsTime(), task.FinishTime.AsTime()})
+	}
+
+	// at most two tasks ran at the same time, and two did
+	maxRunning := 0
+	for _, i := range intervals {
+		running := 0
+		for _, j := range intervals {
+			if !j.start.After(i.start) && j.finish.After(i.start) {
+				running++
+			}
+		}
+		maxRunning = max(maxRunning, running)
+	}
+	if maxRunning != 2 {
+		t.Errorf("expect 2 tasks to run at the same time, but got %d", maxRunning)
+	}
+
+	var finished int64
+	for _, w := range d.Workers() {
+		finished += w.FinishedTasks
+	}
+	if finished != int64(len(tasks)) {
+		t.Errorf("expect the workers to finish %d tasks, but got %d", len(tasks), finished)
+	}
+}
+
+func TestRunTaskStartFailure(t *testing.T) {
+	d := newTestDaemon(t, RunnerOptions{})
+	d.ignoreWakeUps(t)
+	task := d.createTask(t, &pb.Task{Commandline: "true", WorkingDirectory: filepath.Join(t.TempDir(), "removed")})
+
+	if !d.runTask(d.workers[0]) {
+		t.Fatal("expect runTask() to take the task")
+	}
+	task = d.waitFinal(t, task.Id)
+	if task.Status != pb.TaskStatus_FINISHED || task.ReturnCode != -1 || task.Attempt != 1 {
+		t.Errorf("expect the attempt to fail with return code -1, but got %s, %d after %d attempts", task.Status, task.ReturnCode, task.Attempt)
+	}
+	if output := readOutput(t, task.Output)[outputlog.Runner]; !strings.Contains(output, "failed to start the task") {
+		t.Errorf("expect the start error in the output, but got %q", output)
+	}
+	attempts, err := d.db.GetTaskAttempts(task.Id)
+	if err != nil || len(attempts) != 1 || attempts[0].Output != task.Output {
+		t.Errorf("expect the failed attempt to be recorded, but got %v, %v", attempts, err)
+	}
+
+	// the task is not claimed again
+	if d.runTask(d.workers[0]) {
+		t.Error("expect no task left to run")
+	}
+	if files, _ := os.ReadDir(d.outputDir); len(files) != 1 {
+		t.Errorf("expect a single output file, but got %d", len(files))
+	}
+}
+
+// claimHook calls hook with every task it claims before returning it
+type claimHook struct {
+	db.TaskDatabase
+	hook func(task *pb.Task)
+}
+
+func (c *claimHook) ClaimNextTask() (*pb.Task, error) {
+	task, err := c.TaskDatabase.ClaimNextTask()
+	if err == nil {
+		c.hook(task)
+	}
+	return task, err
+}
+
+func TestCancelTaskWhileClaiming(t *testing.T) {
+	d := newTestDaemon(t, RunnerOptions{})
+	d.ignoreWakeUps(t)
+	task := d.createTask(t, &pb.Task{Commandline: "sleep 30"})
+
+	// the task is cancelled as soon as it is RUNNING in the database
+	found := make(chan bool, 1)
+	d.RunnerDaemon.db = &claimHook{TaskDatabase: d.db, hook: func(task *pb.Task) {
+		go func() { found <- d.CancelTask(task.Id) }()
+		time.Sleep(50 * time.Millisecond)
+	}}
+
+	if !d.runTask(d.workers[0]) {
+		t.Fatal("expect runTask() to take the task")
+	}
+	if !<-found {
+		t.Error("expect CancelTask() to find the task being claimed")
+	}
+	task = d.waitFinal(t, task.Id)
+	if task.Status != pb.TaskStatus_CANCELLED {
+		t.Errorf("expect the task to be CANCELLED, but got %s", task.Status)
+	}
+}
+
+func TestRunTaskTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		commandline string
+		status      pb.TaskStatus
+		returnCode  int32
+	}{
+		{"finished in time", "exit 2", pb.TaskStatus_FINISHED, 2},
+		{"timed out", "sleep 30", pb.TaskStatus_TIMED_OUT, -1},
+		{"killed after the grace period", `trap "" TERM; sleep 30`, pb.TaskStatus_TIMED_OUT, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestDaemon(t, RunnerOptions{CancelGracePeriod: 200 * time.Millisecond})
+			d.ignoreWakeUps(t)
+			task := d.createTask(t, &pb.Task{Commandline: test.commandline, Timeout: durationpb.New(200 * time.Millisecond)})
+
+			start := time.Now()
+			if !d.runTask(d.workers[0]) {
+				t.Fatal("expect runTask() to take the task")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expect the task to end soon after its timeout, but it took %v", elapsed)
+			}
+			task = d.waitFinal(t, task.Id)
+			if task.Status != test.status || task.ReturnCode != test.returnCode {
+				t.Errorf("expect %s with return code %d, but got %s, %d", test.status, test.returnCode, task.Status, task.ReturnCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package runner
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"internal/outputlog"
+	"internal/pb"
+)
+
+// readOutput returns the text of each stream of an output log, keyed by the
+// stream of outputlog. Compressed logs are decompressed.
+func readOutput(t *testing.T, path string) map[string]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("cannot open the output: %v", err)
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(path, compressedOutputSuffix) {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("cannot decompress the output: %v", err)
+		}
+		r = zr
+	}
+	log, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("cannot read the output: %v", err)
+	}
+	if !strings.HasPrefix(string(log), outputlog.Header) {
+		t.Fatalf("expect an output log, but got %q", log)
+	}
+
+	text := make(map[string]string)
+	scanner := outputlog.NewScanner(strings.NewReader(string(log[len(outputlog.Header):])), int64(len(outputlog.Header)))
+	for scanner.Scan() {
+		record := scanner.Record()
+		text[record.Stream] += string(record.Data)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("cannot scan the output: %v", err)
+	}
+	return text
+}
+
+// waitForOutput waits until the task wrote text to stdout
+func waitForOutput(t *testing.T, path string, text string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(readOutput(t, path)[outputlog.Stdout], text) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the task to write %q", text)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitDone returns the task once its execution is done
+func waitDone(t *testing.T, e *Execution) *pb.Task {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	var task *pb.Task
+	for {
+		select {
+		case update, ok := <-e.Updates:
+			if !ok {
+				if task == nil {
+					t.Fatal("expect the task to be waited on")
+				}
+				return task
+			}
+			task = update
+		case <-timeout:
+			t.Fatal("expect the task to finish in time")
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	task := &pb.Task{
+		Id:          1,
+		Commandline: "echo out; echo err >&2; exit 3",
+		Output:      filepath.Join(t.TempDir(), "task_output_1.log"),
+	}
+	e, err := Run(task, 0)
+	if err != nil {
+		t.Fatalf("Run() should not return error, but got %v", err)
+	}
+	if running := <-e.Updates; running.Status != pb.TaskStatus_RUNNING || running.Pid == 0 {
+		t.Errorf("expect the task to be RUNNING with a pid, but got %s, pid %d", running.Status, running.Pid)
+	}
+
+	task = waitDone(t, e)
+	if task.Status != pb.TaskStatus_FINISHED || task.ReturnCode != 3 || task.Pid != 0 {
+		t.Errorf("expect FINISHED with return code 3, but got %s, %d, pid %d", task.Status, task.ReturnCode, task.Pid)
+	}
+	output := readOutput(t, task.Output)
+	if output[outputlog.Stdout] != "out\n" || output[outputlog.Stderr] != "err\n" {
+		t.Errorf("expect out on stdout and err on stderr, but got %q", output)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	tests := []struct {
+		name        string
+		commandline string
+		grace       time.Duration
+		// minimum and maximum time to terminate the task
+		min, max time.Duration
+	}{
+		{"SIGTERM", "echo ready; sleep 30", 10 * time.Second, 0, 5 * time.Second},
+		{"SIGKILL after the grace period", `trap "" TERM; echo ready; sleep 30`, 300 * time.Millisecond, 300 * time.Millisecond, 5 * time.Second},
+		// the shell exits on SIGTERM but leaves a child ignoring it behind,
+		// which is killed once the shell is reaped
+		{"children left behind", `(trap "" TERM; exec sleep 30) >/dev/null 2>&1 & sleep 0.2; echo ready $!; wait`, 10 * time.Second, 0, 5 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := &pb.Task{Id: 1, Commandline: test.commandline, Output: filepath.Join(t.TempDir(), "task_output_1.log")}
+			e, err := Run(task, 0)
+			if err != nil {
+				t.Fatalf("Run() should not return error, but got %v", err)
+			}
+			<-e.Updates
+			waitForOutput(t, task.Output, "ready")
+
+			start := time.Now()
+			e.Terminate(pb.TaskStatus_CANCELLED, test.grace)
+			task = waitDone(t, e)
+			elapsed := time.Since(start)
+			if task.Status != pb.TaskStatus_CANCELLED || task.ReturnCode != -1 {
+				t.Errorf("expect CANCELLED by a signal, but got %s, %d", task.Status, task.ReturnCode)
+			}
+			if elapsed < test.min || elapsed > test.max {
+				t.Errorf("expect the task to be terminated in %v to %v, but it took %v", test.min, test.max, elapsed)
+			}
+
+			// every process of the group is gone
+			fields := strings.Fields(readOutput(t, task.Output)[outputlog.Stdout])
+			if len(fields) == 2 {
+				pid, _ := strconv.Atoi(fields[1])
+				deadline := time.Now().Add(time.Second)
+				for processExists(pid) && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				if processExists(pid) {
+					t.Errorf("expect child process %d to be killed", pid)
+				}
+			}
+		})
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		task   *pb.Task
+		stdout string
+	}{
+		{"sh with env", &pb.Task{Commandline: `echo "$FOO"`, Env: map[string]string{"FOO": "bar"}}, "bar\n"},
+		{"inherited env", &pb.Task{Commandline: `test -n "$PATH" && echo path`, Env: map[string]string{"FOO": "bar"}}, "path\n"},
+		{"clear env", &pb.Task{Shell: pb.Shell_EXEC, Argv: []string{"/usr/bin/env"}, ClearEnv: true, Env: map[string]string{"B": "2", "A": "1"}}, "A=1\nB=2\n"},
+		{"bash", &pb.Task{Shell: pb.Shell_BASH, Commandline: `[ -n "$BASH_VERSION" ] && echo bash`}, "bash\n"},
+		{"argv", &pb.Task{Shell: pb.Shell_EXEC, Argv: []string{"printf", "<%s>", "a b", "$HOME"}}, "<a b><$HOME>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			task := test.task
+			task.Id = 1
+			task.Output = filepath.Join(t.TempDir(), "task_output_1.log")
+			e, err := Run(task, 0)
+			if err != nil {
+				t.Fatalf("Run() should not return error, but got %v", err)
+			}
+			task = waitDone(t, e)
+			if task.Status != pb.TaskStatus_FINISHED || task.ReturnCode != 0 {
+				t.Errorf("expect FINISHED with return code 0, but got %s, %d", task.Status, task.ReturnCode)
+			}
+			if stdout := readOutput(t, task.Output)[outputlog.Stdout]; stdout != test.stdout {
+				t.Errorf("expect %q on stdout, but got %q", test.stdout, stdout)
+			}
+		})
+	}
+
+	task := &pb.Task{Id: 1, Shell: pb.Shell_EXEC, Output: filepath.Join(t.TempDir(), "task_output_1.log")}
+	if _, err := Run(task, 0); err == nil {
+		t.Error("expect Run() to fail without argv")
+	}
+}
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+const (
+	defaultTerminalRows = 24
+	defaultTerminalCols = 80
+)
+
+// terminal is the pseudo-terminal of an interactive task
+type terminal struct {
+	ptmx    *os.File
+	drained chan struct{}
+}
+
+// startTerminal starts the command in a new session with a pseudo-terminal as
+// its controlling terminal, so that the process group of the task is still
+// led by its process. Everything the terminal displays is written to output.
+func startTerminal(cmd *exec.Cmd, output io.Writer) (*terminal, error) {
+	// pty sets up the session instead
+	cmd.SysProcAttr = nil
+	ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: defaultTerminalRows, Cols: defaultTerminalCols})
+	if err != nil {
+		return nil, err
+	}
+
+	t := &terminal{ptmx: ptmx, drained: make(chan struct{})}
+	go func() {
+		defer close(t.drained)
+		// fails with EIO once the last process has closed the terminal
+		io.Copy(output, ptmx)
+	}()
+	return t, nil
+}
+
+func (t *terminal) Write(p []byte) (int, error) {
+	return t.ptmx.Write(p)
+}
+
+func (t *terminal) Resize(rows, cols uint16) error {
+	return pty.Setsize(t.ptmx, &pty.Winsize{Rows: rows, Cols: cols})
+}
+
+// Close waits for the output of the exited process to be copied, for at most
+// timeout in case it left children behind that hold on to the terminal.
+func (t *terminal) Close(timeout time.Duration) error {
+	select {
+	case <-t.drained:
+	case <-time.After(timeout):
+	}
+	err := t.ptmx.Close()
+	<-t.drained
+	return err
+}
+
+// WriteInput types data into the terminal of an interactive task
+func (e *Execution) WriteInput(data []byte) error {
+	if e.terminal == nil {
+		return fmt.Errorf("task is not interactive")
+	}
+	_, err := e.terminal.Write(data)
+	return err
+}
+
+// ResizeTerminal changes the size of the terminal of an interactive task
+func (e *Execution) ResizeTerminal(rows, cols uint16) error {
+	if e.terminal == nil {
+		return fmt.Errorf("task is not interactive")
+	}
+	return e.terminal.Resize(rows, cols)
+}
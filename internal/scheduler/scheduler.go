@@ -0,0 +1,199 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"internal/db"
+	"internal/pb"
+
+	"github.com/robfig/cron/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// missedRunGrace is how late a run may be and still be on time; later
+	// runs are missed and handled by the catch-up policy of the schedule
+	missedRunGrace = time.Minute
+	// maxCatchUpRuns bounds the tasks created at once for a RUN_ALL schedule
+	maxCatchUpRuns = 100
+	// checkInterval bounds how long the scheduler sleeps between checks
+	checkInterval = time.Minute
+)
+
+// ParseCron parses a standard cron expression with five fields, or a
+// descriptor such as @daily or @every 1h
+func ParseCron(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// NextRunTime returns the first time after t the cron expression is due
+func NextRunTime(expr string, t time.Time) (time.Time, error) {
+	c, err := ParseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return nextRun(c, t), nil
+}
+
+// nextRun returns the first run time after t. Cron expressions are in local
+// time unless they name a time zone, but the cron package reads them in the
+// location of t.
+func nextRun(c cron.Schedule, t time.Time) time.Time {
+	return c.Next(t.Local())
+}
+
+// Scheduler creates the tasks of the schedules when they are due. Created
+// tasks are sent on TaskChan.
+type Scheduler struct {
+	TaskChan <-chan *pb.Task
+
+	taskChan chan *pb.Task
+	wakeChan chan bool
+	exitChan chan bool
+	db       db.TaskDatabase
+	now      func() time.Time
+}
+
+func NewScheduler(db db.TaskDatabase) *Scheduler {
+	taskChan := make(chan *pb.Task)
+	return &Scheduler{
+		TaskChan: taskChan,
+
+		taskChan: taskChan,
+		wakeChan: make(chan bool, 1),
+		exitChan: make(chan bool),
+		db:       db,
+		now:      time.Now,
+	}
+}
+
+// Reschedule makes the scheduler look at the schedules again, e.g. after one
+// was created or resumed
+func (s *Scheduler) Reschedule() {
+	select {
+	case s.wakeChan <- true:
+	default:
+	}
+}
+
+func (s *Scheduler) Close() {
+	close(s.exitChan)
+}
+
+// Run creates the tasks of due schedules until the scheduler is closed
+func (s *Scheduler) Run() {
+	defer close(s.taskChan)
+	for {
+		next := s.runDue(s.now())
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-s.exitChan:
+			timer.Stop()
+			return
+		case <-s.wakeChan:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// runDue creates the tasks of every schedule that is due and returns when the
+// scheduler should look again
+func (s *Scheduler) runDue(now time.Time) time.Time {
+	next := now.Add(checkInterval)
+	schedules, err := s.db.GetSchedules()
+	if err != nil {
+		log.Printf("failed to get schedules: %v", err)
+		return next
+	}
+
+	for _, schedule := range schedules {
+		if schedule.GetPaused() {
+			continue
+		}
+		if schedule.GetNextRunTime().AsTime().After(now) {
+			if t := schedule.GetNextRunTime().AsTime(); t.Before(next) {
+				next = t
+			}
+			continue
+		}
+		if err := s.runSchedule(schedule, now); err != nil {
+			log.Printf("failed to run schedule %d: %v", schedule.GetId(), err)
+			continue
+		}
+		if t := schedule.GetNextRunTime().AsTime(); t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// runSchedule creates the tasks of a due schedule according to its catch-up
+// policy and moves it to its next run time
+func (s *Scheduler) runSchedule(schedule *pb.Schedule, now time.Time) error {
+	c, err := ParseCron(schedule.GetCron())
+	if err != nil {
+		return err
+	}
+
+	due, total := dueTimes(c, schedule.GetNextRunTime().AsTime(), now)
+	runs := catchUp(schedule.GetCatchUpPolicy(), due, now)
+	for _, run := range runs {
+		task := proto.Clone(schedule.GetTaskTemplate()).(*pb.Task)
+		task.Status = pb.TaskStatus_NEW
+		task.ScheduleId = schedule.GetId()
+		created, err := s.db.CreateTask(task)
+		if err != nil {
+			return err
+		}
+		log.Printf("schedule %d created task %d for %v", schedule.GetId(), created.GetId(), run)
+		schedule.LastRunTime = timestamppb.New(run)
+		s.taskChan <- created
+	}
+	if skipped := total - len(runs); skipped > 0 {
+		log.Printf("schedule %d skipped %d missed runs", schedule.GetId(), skipped)
+	}
+
+	schedule.NextRunTime = timestamppb.New(nextRun(c, now))
+	var last time.Time
+	if schedule.GetLastRunTime() != nil {
+		last = schedule.GetLastRunTime().AsTime()
+	}
+	return s.db.UpdateScheduleRunTimes(schedule.GetId(), schedule.GetNextRunTime().AsTime(), last)
+}
+
+// dueTimes returns the latest maxCatchUpRuns run times from first up to now,
+// and the number of run times in that range
+func dueTimes(c cron.Schedule, first time.Time, now time.Time) ([]time.Time, int) {
+	var due []time.Time
+	total := 0
+	for t := first; !t.After(now); t = nextRun(c, t) {
+		if len(due) == maxCatchUpRuns {
+			due = due[1:]
+		}
+		due = append(due, t)
+		total++
+	}
+	return due, total
+}
+
+// catchUp picks the runs to create out of the due run times
+func catchUp(policy pb.CatchUpPolicy, due []time.Time, now time.Time) []time.Time {
+	if len(due) == 0 {
+		return nil
+	}
+	latest := due[len(due)-1]
+	switch policy {
+	case pb.CatchUpPolicy_RUN_ALL:
+		return due
+	case pb.CatchUpPolicy_RUN_ONCE:
+		return []time.Time{latest}
+	default:
+		if now.Sub(latest) > missedRunGrace {
+			return nil
+		}
+		return []time.Time{latest}
+	}
+}
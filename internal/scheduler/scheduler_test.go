@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"internal/db"
+	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newTestScheduler(t *testing.T) *Scheduler {
+	taskDB, err := db.NewTaskDatabase(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("NewTaskDatabase: %v", err)
+	}
+	if err := taskDB.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() { taskDB.Uninit() })
+
+	s := NewScheduler(taskDB)
+	go func() {
+		for range s.TaskChan {
+		}
+	}()
+	t.Cleanup(func() { close(s.taskChan) })
+	return s
+}
+
+func TestCatchUp(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	missed := []time.Time{now.Add(-3 * time.Hour), now.Add(-2 * time.Hour)}
+	onTime := append(missed, now.Add(-10*time.Second))
+
+	tests := []struct {
+		policy pb.CatchUpPolicy
+		due    []time.Time
+		want   int
+	}{
+		{pb.CatchUpPolicy_SKIP_MISSED, nil, 0},
+		{pb.CatchUpPolicy_SKIP_MISSED, missed, 0},
+		{pb.CatchUpPolicy_SKIP_MISSED, onTime, 1},
+		{pb.CatchUpPolicy_RUN_ONCE, missed, 1},
+		{pb.CatchUpPolicy_RUN_ONCE, onTime, 1},
+		{pb.CatchUpPolicy_RUN_ALL, missed, 2},
+		{pb.CatchUpPolicy_RUN_ALL, onTime, 3},
+	}
+	for _, test := range tests {
+		runs := catchUp(test.policy, test.due, now)
+		if len(runs) != test.want {
+			t.Errorf("catchUp(%v, %d due) = %d runs, want %d", test.policy, len(test.due), len(runs), test.want)
+		}
+		if len(runs) > 0 && !runs[len(runs)-1].Equal(test.due[len(test.due)-1]) {
+			t.Errorf("catchUp(%v) did not run the latest due time", test.policy)
+		}
+	}
+}
+
+func TestRunSchedule(t *testing.T) {
+	now := time.Now()
+	for policy, want := range map[pb.CatchUpPolicy]int{
+		pb.CatchUpPolicy_SKIP_MISSED: 0,
+		pb.CatchUpPolicy_RUN_ONCE:    1,
+		pb.CatchUpPolicy_RUN_ALL:     4,
+	} {
+		s := newTestScheduler(t)
+		schedule, err := s.db.CreateSchedule(&pb.Schedule{
+			Cron:          "@every 1h",
+			TaskTemplate:  &pb.Task{Commandline: "echo scheduled"},
+			CatchUpPolicy: policy,
+			// missed at -3h30m, -2h30m, -1h30m and -30m
+			NextRunTime: timestamppb.New(now.Add(-3*time.Hour - 30*time.Minute)),
+		})
+		if err != nil {
+			t.Fatalf("CreateSchedule: %v", err)
+		}
+
+		next := s.runDue(now)
+		if !next.After(now) {
+			t.Errorf("%v: next check at %v, want after %v", policy, next, now)
+		}
+
+		tasks, err := s.db.GetTasks()
+		if err != nil {
+			t.Fatalf("GetTasks: %v", err)
+		}
+		if len(tasks) != want {
+			t.Errorf("%v: created %d tasks, want %d", policy, len(tasks), want)
+		}
+		for _, task := range tasks {
+			if task.ScheduleId != schedule.Id || task.Status != pb.TaskStatus_NEW || task.Commandline != "echo scheduled" {
+				t.Errorf("%v: unexpected task %v", policy, task)
+			}
+		}
+
+		schedule, err = s.db.GetSchedule(schedule.Id)
+		if err != nil {
+			t.Fatalf("GetSchedule: %v", err)
+		}
+		if !schedule.NextRunTime.AsTime().After(now) {
+			t.Errorf("%v: next run at %v, want after %v", policy, schedule.NextRunTime.AsTime(), now)
+		}
+		if want > 0 && schedule.LastRunTime == nil {
+			t.Errorf("%v: last run time not recorded", policy)
+		}
+	}
+}
+
+func TestPausedScheduleDoesNotRun(t *testing.T) {
+	s := newTestScheduler(t)
+	now := time.Now()
+	_, err := s.db.CreateSchedule(&pb.Schedule{
+		Cron:          "* * * * *",
+		TaskTemplate:  &pb.Task{Commandline: "echo paused"},
+		Paused:        true,
+		CatchUpPolicy: pb.CatchUpPolicy_RUN_ALL,
+		NextRunTime:   timestamppb.New(now.Add(-time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("CreateSchedule: %v", err)
+	}
+
+	s.runDue(now)
+
+	tasks, err := s.db.GetTasks()
+	if err != nil {
+		t.Fatalf("GetTasks: %v", err)
+	}
+	if len(tasks) != 0 {
+		t.Errorf("paused schedule created %d tasks", len(tasks))
+	}
+}
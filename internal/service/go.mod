@@ -6,17 +6,28 @@ replace internal/pb => ../pb
 
 replace internal/db => ../db
 
+replace internal/scheduler => ../scheduler
+
+replace internal/verifier => ../verifier
+
+replace internal/outputlog => ../outputlog
+
 require (
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240903143218-8af14fe29dc1
+	google.golang.org/grpc v1.68.1
+	google.golang.org/protobuf v1.36.1
 	internal/db v1.0.0
+	internal/outputlog v1.0.0
 	internal/pb v1.0.0
+	internal/scheduler v1.0.0
+	internal/verifier v1.0.0
 )
 
 require (
 	github.com/mattn/go-sqlite3 v1.14.16 // indirect
+	github.com/robfig/cron/v3 v3.0.1 // indirect
 	golang.org/x/net v0.29.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/text v0.18.0 // indirect
-	google.golang.org/genproto/googleapis/rpc v0.0.0-20240903143218-8af14fe29dc1 // indirect
-	google.golang.org/grpc v1.68.1 // indirect
-	google.golang.org/protobuf v1.35.2 // indirect
+	mvdan.cc/sh/v3 v3.7.0 // indirect
 )
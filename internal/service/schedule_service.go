@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"internal/db"
+	"internal/pb"
+	"internal/scheduler"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ScheduleRunner is the part of the scheduler the service controls directly
+type ScheduleRunner interface {
+	Reschedule()
+}
+
+type ScheduleServiceServer struct {
+	pb.UnimplementedScheduleServiceServer
+	taskDB    db.TaskDatabase
+	scheduler ScheduleRunner
+}
+
+// NewScheduleServiceServer creates a new ScheduleServiceServer
+func NewScheduleServiceServer(taskDB db.TaskDatabase) *ScheduleServiceServer {
+	return &ScheduleServiceServer{taskDB: taskDB}
+}
+
+// SetScheduler sets the scheduler that creates the tasks of the schedules
+func (s *ScheduleServiceServer) SetScheduler(scheduler ScheduleRunner) {
+	s.scheduler = scheduler
+}
+
+func (s *ScheduleServiceServer) reschedule() {
+	if s.scheduler != nil {
+		s.scheduler.Reschedule()
+	}
+}
+
+// toScheduleStatusError turns database errors into gRPC status errors
+func toScheduleStatusError(err error) error {
+	if _, ok := err.(*db.ErrNoRows); ok {
+		return status.Error(codes.NotFound, "schedule not found")
+	}
+	return err
+}
+
+// CreateSchedule implements the CreateSchedule gRPC method. The first run is
+// the first time the cron expression is due after now.
+func (s *ScheduleServiceServer) CreateSchedule(ctx context.Context, req *pb.CreateScheduleRequest) (*pb.ScheduleResponse, error) {
+	schedule := req.GetSchedule()
+	if schedule.GetTaskTemplate() == nil {
+		return nil, status.Error(codes.InvalidArgument, "task_template is required")
+	}
+	if err := validateTask(schedule.GetTaskTemplate()); err != nil {
+		return nil, err
+	}
+	if len(schedule.GetTaskTemplate().GetDependsOn()) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "scheduled tasks cannot depend on other tasks")
+	}
+	next, err := scheduler.NextRunTime(schedule.GetCron(), time.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid cron expression %q: %v", schedule.GetCron(), err)
+	}
+	schedule.NextRunTime = timestamppb.New(next)
+	schedule.LastRunTime = nil
+
+	schedule, err = s.taskDB.CreateSchedule(schedule)
+	if err != nil {
+		log.Printf("CreateSchedule: Failed to create schedule: %v", err)
+		return nil, err
+	}
+
+	s.reschedule()
+	return &pb.ScheduleResponse{Schedule: schedule}, nil
+}
+
+// ListSchedules implements the ListSchedules gRPC method
+func (s *ScheduleServiceServer) ListSchedules(ctx context.Context, req *pb.ListSchedulesRequest) (*pb.ListSchedulesResponse, error) {
+	schedules, err := s.taskDB.GetSchedules()
+	if err != nil {
+		log.Printf("ListSchedules: Failed to get schedules: %v", err)
+		return nil, err
+	}
+	return &pb.ListSchedulesResponse{Schedules: schedules}, nil
+}
+
+// DeleteSchedule implements the DeleteSchedule gRPC method. The tasks the
+// schedule created are kept.
+func (s *ScheduleServiceServer) DeleteSchedule(ctx context.Context, req *pb.DeleteScheduleRequest) (*pb.ScheduleResponse, error) {
+	schedule, err := s.taskDB.GetSchedule(req.GetId())
+	if err != nil {
+		log.Printf("DeleteSchedule: Failed to get schedule: %v", err)
+		return nil, toScheduleStatusError(err)
+	}
+
+	err = s.taskDB.DeleteSchedule(req.GetId())
+	if err != nil {
+		log.Printf("DeleteSchedule: Failed to delete schedule: %v", err)
+		return nil, err
+	}
+
+	return &pb.ScheduleResponse{Schedule: schedule}, nil
+}
+
+// PauseSchedule implements the PauseSchedule gRPC method
+func (s *ScheduleServiceServer) PauseSchedule(ctx context.Context, req *pb.PauseScheduleRequest) (*pb.ScheduleResponse, error) {
+	// only the paused column is written, so that a run the scheduler records
+	// at the same time is not undone
+	err := s.taskDB.UpdateSchedulePaused(req.GetId(), true)
+	if err != nil {
+		log.Printf("PauseSchedule: Failed to update schedule: %v", err)
+		return nil, toScheduleStatusError(err)
+	}
+
+	schedule, err := s.taskDB.GetSchedule(req.GetId())
+	if err != nil {
+		log.Printf("PauseSchedule: Failed to get schedule: %v", err)
+		return nil, toScheduleStatusError(err)
+	}
+
+	return &pb.ScheduleResponse{Schedule: schedule}, nil
+}
+
+// ResumeSchedule implements the ResumeSchedule gRPC method. The runs missed
+// while the schedule was paused are not caught up.
+func (s *ScheduleServiceServer) ResumeSchedule(ctx context.Context, req *pb.ResumeScheduleRequest) (*pb.ScheduleResponse, error) {
+	schedule, err := s.taskDB.GetSchedule(req.GetId())
+	if err != nil {
+		log.Printf("ResumeSchedule: Failed to get schedule: %v", err)
+		return nil, toScheduleStatusError(err)
+	}
+	if !schedule.GetPaused() {
+		return &pb.ScheduleResponse{Schedule: schedule}, nil
+	}
+
+	next, err := scheduler.NextRunTime(schedule.GetCron(), time.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "invalid cron expression %q: %v", schedule.GetCron(), err)
+	}
+	// only the paused column and the next run time are written, so that
+	// changes made since the schedule was read are not undone
+	err = s.taskDB.UpdateScheduleResumed(req.GetId(), next)
+	if err != nil {
+		log.Printf("ResumeSchedule: Failed to update schedule: %v", err)
+		return nil, toScheduleStatusError(err)
+	}
+	schedule, err = s.taskDB.GetSchedule(req.GetId())
+	if err != nil {
+		log.Printf("ResumeSchedule: Failed to get schedule: %v", err)
+		return nil, toScheduleStatusError(err)
+	}
+
+	s.reschedule()
+	return &pb.ScheduleResponse{Schedule: schedule}, nil
+}
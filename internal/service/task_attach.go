@@ -0,0 +1,59 @@
+package service
+
+import (
+	"log"
+
+	"internal/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AttachTask implements the AttachTask gRPC method. The output of the task is
+// followed as with StreamTaskOutput while the requests are passed on to its
+// terminal.
+func (s *TaskServiceServer) AttachTask(stream pb.TaskService_AttachTaskServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	task, err := s.taskDB.GetTask(req.GetId())
+	if err != nil {
+		log.Printf("AttachTask: Failed to get task: %v", err)
+		return toStatusError(err)
+	}
+	if !task.GetInteractive() {
+		return status.Errorf(codes.FailedPrecondition, "task %d is not interactive", task.GetId())
+	}
+	if s.runner == nil {
+		return status.Error(codes.Unavailable, "no runner is attached to the service")
+	}
+
+	go func() {
+		for {
+			s.forwardToTerminal(task.GetId(), req)
+			req, err = stream.Recv()
+			if err != nil {
+				// the client stopped sending, it may still be reading
+				return
+			}
+		}
+	}()
+
+	return s.StreamTaskOutput(&pb.StreamTaskOutputRequest{Id: task.GetId(), Follow: true}, stream)
+}
+
+// forwardToTerminal passes the input and resize of req to the terminal. Input
+// is dropped while the task is not running.
+func (s *TaskServiceServer) forwardToTerminal(id int64, req *pb.AttachTaskRequest) {
+	if size := req.GetResize(); size != nil {
+		if err := s.runner.ResizeTerminal(id, uint16(size.GetRows()), uint16(size.GetCols())); err != nil {
+			log.Printf("AttachTask: Failed to resize terminal: %v", err)
+		}
+	}
+	if input := req.GetInput(); len(input) > 0 {
+		if err := s.runner.WriteInput(id, input); err != nil {
+			log.Printf("AttachTask: Failed to write input: %v", err)
+		}
+	}
+}
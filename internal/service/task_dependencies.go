@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"internal/db"
+	"internal/pb"
+	"log"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// A task with depends_on is created WAITING. Every time a task reaches a
+// final status, the WAITING tasks depending on it are resolved: they become
+// NEW once all their parents have succeeded, or SKIPPED as soon as one of
+// them has not. A SKIPPED task is final, so the tasks depending on it are
+// skipped in turn.
+
+// ResolveWaitingTasks resolves every WAITING task, e.g. after a restart in
+// which their parents finished while nobody was listening
+func (s *TaskServiceServer) ResolveWaitingTasks() {
+	tasks, err := s.taskDB.GetTasksByStatus(pb.TaskStatus_WAITING)
+	if err != nil {
+		log.Printf("ResolveWaitingTasks: Failed to get waiting tasks: %v", err)
+		return
+	}
+	for _, task := range tasks {
+		s.resolveTask(task)
+	}
+}
+
+// resolveDependents resolves the WAITING tasks that depend on the task
+func (s *TaskServiceServer) resolveDependents(id int64) {
+	tasks, err := s.taskDB.GetTasksByStatus(pb.TaskStatus_WAITING)
+	if err != nil {
+		log.Printf("resolveDependents: Failed to get waiting tasks: %v", err)
+		return
+	}
+	for _, task := range tasks {
+		if slices.Contains(task.DependsOn, id) {
+			s.resolveTask(task)
+		}
+	}
+}
+
+// resolveTask queues or skips a WAITING task if all its parents are done. A
+// parent that was deleted counts as failed.
+func (s *TaskServiceServer) resolveTask(task *pb.Task) {
+	to := pb.TaskStatus_NEW
+	for _, id := range task.DependsOn {
+		parent, err := s.taskDB.GetTask(id)
+		if err != nil {
+			if _, ok := err.(*db.ErrNoRows); !ok {
+				log.Printf("resolveTask: Failed to get task %d: %v", id, err)
+				return
+			}
+			to = pb.TaskStatus_SKIPPED
+			break
+		}
+		if !parent.Status.IsFinal() {
+			return
+		}
+		if !parent.Succeeded() {
+			to = pb.TaskStatus_SKIPPED
+			break
+		}
+	}
+
+	updated, err := s.taskDB.UpdateTaskStatus(task.Id, pb.TaskStatus_WAITING, to)
+	if err != nil {
+		log.Printf("resolveTask: Failed to update task %d: %v", task.Id, err)
+		return
+	}
+	if !updated {
+		// resolved or cancelled in the meantime
+		return
+	}
+	task.Status = to
+	s.NotifyTaskUpdated(task)
+}
+
+// checkParents returns an InvalidArgument error if a task the new task
+// depends on does not exist
+func (s *TaskServiceServer) checkParents(task *pb.Task) error {
+	for _, id := range task.GetDependsOn() {
+		if _, err := s.taskDB.GetTask(id); err != nil {
+			if _, ok := err.(*db.ErrNoRows); ok {
+				return status.Errorf(codes.InvalidArgument, "depends_on: task %d does not exist", id)
+			}
+			log.Printf("CreateTask: Failed to get task %d: %v", id, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// sortPipelineTasks checks the names and dependencies of the tasks of a
+// pipeline and returns them so that every task comes after the tasks it
+// depends on
+func sortPipelineTasks(tasks []*pb.PipelineTask) ([]*pb.PipelineTask, error) {
+	byName := make(map[string]*pb.PipelineTask, len(tasks))
+	for _, t := range tasks {
+		if len(t.GetName()) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "every task of the pipeline needs a name")
+		}
+		if _, ok := byName[t.GetName()]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "task name %q is used twice", t.GetName())
+		}
+		if t.GetTask() == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "task %q: task is required", t.GetName())
+		}
+		byName[t.GetName()] = t
+	}
+
+	// Kahn's algorithm: take the tasks whose parents have all been taken
+	pending := make(map[string]int, len(tasks))
+	children := make(map[string][]string)
+	for _, t := range tasks {
+		for _, parent := range t.GetDependsOn() {
+			if _, ok := byName[parent]; !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "task %q depends on unknown task %q", t.GetName(), parent)
+			}
+			pending[t.GetName()]++
+			children[parent] = append(children[parent], t.GetName())
+		}
+	}
+	var ready []string
+	for _, t := range tasks {
+		if pending[t.GetName()] == 0 {
+			ready = append(ready, t.GetName())
+		}
+	}
+	sorted := make([]*pb.PipelineTask, 0, len(tasks))
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, byName[name])
+		for _, child := range children[name] {
+			pending[child]--
+			if pending[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+
+	if len(sorted) < len(tasks) {
+		var cycle []string
+		for _, t := range tasks {
+			if pending[t.GetName()] > 0 {
+				cycle = append(cycle, t.GetName())
+			}
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "the dependencies of tasks %s form a cycle", strings.Join(cycle, ", "))
+	}
+	return sorted, nil
+}
+
+// CreatePipeline implements the CreatePipeline gRPC method. The tasks are
+// checked and verified as a whole and either all of them are created or none.
+func (s *TaskServiceServer) CreatePipeline(ctx context.Context, req *pb.CreatePipelineRequest) (*pb.PipelineResponse, error) {
+	if len(req.GetTasks()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "a pipeline needs at least one task")
+	}
+	sorted, err := sortPipelineTasks(req.GetTasks())
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*pb.PipelineTask, 0, len(sorted))
+	var problems []*pb.TaskProblem
+	for _, t := range sorted {
+		if len(t.GetTask().GetDependsOn()) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "task %q: use the depends_on of the pipeline task instead of task IDs", t.GetName())
+		}
+		if err := validateTask(t.GetTask()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "task %q: %s", t.GetName(), status.Convert(err).Message())
+		}
+		if !req.GetForce() {
+			for _, p := range s.problems(t.GetTask()) {
+				problems = append(problems, &pb.TaskProblem{
+					Field:       fmt.Sprintf("tasks[%s].%s", t.GetName(), p.Field),
+					Description: p.Description,
+				})
+			}
+		}
+
+		t = proto.Clone(t).(*pb.PipelineTask)
+		t.Task.Status = pb.TaskStatus_NEW
+		if len(t.GetDependsOn()) > 0 {
+			t.Task.Status = pb.TaskStatus_WAITING
+		}
+		tasks = append(tasks, t)
+	}
+	if err := problemsError(problems); err != nil {
+		return nil, err
+	}
+
+	pipeline, err := s.taskDB.CreatePipeline(req.GetName(), tasks)
+	if err != nil {
+		log.Printf("CreatePipeline: Failed to create pipeline: %v", err)
+		return nil, err
+	}
+
+	for _, task := range pipeline.Tasks {
+		s.NotifyTaskCreated(task)
+	}
+	pipeline.Status = pipelineStatus(pipeline.Tasks)
+	return &pb.PipelineResponse{Pipeline: pipeline}, nil
+}
+
+// ReadPipeline implements the ReadPipeline gRPC method
+func (s *TaskServiceServer) ReadPipeline(ctx context.Context, req *pb.ReadPipelineRequest) (*pb.PipelineResponse, error) {
+	pipeline, err := s.taskDB.GetPipeline(req.GetId())
+	if err != nil {
+		log.Printf("ReadPipeline: Failed to get pipeline: %v", err)
+		if _, ok := err.(*db.ErrNoRows); ok {
+			return nil, status.Error(codes.NotFound, "pipeline not found")
+		}
+		return nil, err
+	}
+	pipeline.Tasks, err = s.taskDB.GetTasksByPipeline(pipeline.Id)
+	if err != nil {
+		log.Printf("ReadPipeline: Failed to get tasks: %v", err)
+		return nil, err
+	}
+	pipeline.Status = pipelineStatus(pipeline.Tasks)
+	return &pb.PipelineResponse{Pipeline: pipeline}, nil
+}
+
+// pipelineStatus is RUNNING until every task is done, then SUCCEEDED if they
+// all succeeded
+func pipelineStatus(tasks []*pb.Task) pb.PipelineStatus {
+	succeeded := true
+	for _, task := range tasks {
+		if !task.Status.IsFinal() {
+			return pb.PipelineStatus_PIPELINE_RUNNING
+		}
+		succeeded = succeeded && task.Succeeded()
+	}
+	if !succeeded {
+		return pb.PipelineStatus_PIPELINE_FAILED
+	}
+	return pb.PipelineStatus_PIPELINE_SUCCEEDED
+}
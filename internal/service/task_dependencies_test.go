@@ -0,0 +1,142 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"internal/db"
+	"internal/pb"
+	"service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestTaskService(t *testing.T) (*service.TaskServiceServer, db.TaskDatabase) {
+	taskDB, err := db.NewTaskDatabase(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("NewTaskDatabase() should not return error, but got %v", err)
+	}
+	if err := taskDB.Init(); err != nil {
+		t.Fatalf("Init() should not return error, but got %v", err)
+	}
+	t.Cleanup(func() { taskDB.Uninit() })
+	return service.NewTaskServiceServer(taskDB), taskDB
+}
+
+// waitForStatus polls the task until it has the status, as dependents are
+// resolved in the background
+func waitForStatus(t *testing.T, taskDB db.TaskDatabase, id int64, want pb.TaskStatus) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		task, err := taskDB.GetTask(id)
+		if err != nil {
+			t.Fatalf("GetTask() should not return error, but got %v", err)
+		}
+		if task.Status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect task %d to be %s, but it is %s", id, want, task.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// finish records the end of a task the way the runner does
+func finish(t *testing.T, s *service.TaskServiceServer, taskDB db.TaskDatabase, id int64, returnCode int32) {
+	t.Helper()
+	task, err := taskDB.GetTask(id)
+	if err != nil {
+		t.Fatalf("GetTask() should not return error, but got %v", err)
+	}
+	task.Status = pb.TaskStatus_FINISHED
+	task.ReturnCode = returnCode
+	if _, err := taskDB.UpdateTask(task); err != nil {
+		t.Fatalf("UpdateTask() should not return error, but got %v", err)
+	}
+	s.NotifyTaskUpdated(task)
+}
+
+func TestCreatePipelineCycle(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+
+	_, err := s.CreatePipeline(context.Background(), &pb.CreatePipelineRequest{Tasks: []*pb.PipelineTask{
+		{Name: "build", Task: &pb.Task{Commandline: "make"}},
+		{Name: "test", Task: &pb.Task{Commandline: "make test"}, DependsOn: []string{"build", "deploy"}},
+		{Name: "deploy", Task: &pb.Task{Commandline: "make deploy"}, DependsOn: []string{"test"}},
+	}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expect InvalidArgument for a cycle, but got %v", err)
+	}
+	if msg := status.Convert(err).Message(); !strings.Contains(msg, "test, deploy") {
+		t.Errorf("expect the error to name the tasks of the cycle, but got %q", msg)
+	}
+
+	_, err = s.CreatePipeline(context.Background(), &pb.CreatePipelineRequest{Tasks: []*pb.PipelineTask{
+		{Name: "test", Task: &pb.Task{Commandline: "make test"}, DependsOn: []string{"build"}},
+	}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect InvalidArgument for an unknown dependency, but got %v", err)
+	}
+
+	tasks, err := taskDB.GetTasks()
+	if err != nil {
+		t.Fatalf("GetTasks() should not return error, but got %v", err)
+	}
+	if len(tasks) != 0 {
+		t.Errorf("expect no task to be created, but got %d", len(tasks))
+	}
+}
+
+func TestPipelineDependencies(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+	ctx := context.Background()
+
+	// listed out of order on purpose
+	res, err := s.CreatePipeline(ctx, &pb.CreatePipelineRequest{Name: "release", Tasks: []*pb.PipelineTask{
+		{Name: "deploy", Task: &pb.Task{Commandline: "make deploy"}, DependsOn: []string{"test"}},
+		{Name: "test", Task: &pb.Task{Commandline: "make test"}, DependsOn: []string{"build"}},
+		{Name: "build", Task: &pb.Task{Commandline: "make"}},
+		{Name: "docs", Task: &pb.Task{Commandline: "make docs"}, DependsOn: []string{"build"}},
+	}})
+	if err != nil {
+		t.Fatalf("CreatePipeline() should not return error, but got %v", err)
+	}
+	ids := res.Pipeline.TaskIds
+	waitForStatus(t, taskDB, ids["build"], pb.TaskStatus_NEW)
+	waitForStatus(t, taskDB, ids["test"], pb.TaskStatus_WAITING)
+
+	finish(t, s, taskDB, ids["build"], 0)
+	waitForStatus(t, taskDB, ids["test"], pb.TaskStatus_NEW)
+	waitForStatus(t, taskDB, ids["docs"], pb.TaskStatus_NEW)
+	waitForStatus(t, taskDB, ids["deploy"], pb.TaskStatus_WAITING)
+
+	finish(t, s, taskDB, ids["docs"], 0)
+	finish(t, s, taskDB, ids["test"], 1)
+	waitForStatus(t, taskDB, ids["deploy"], pb.TaskStatus_SKIPPED)
+
+	pipeline, err := s.ReadPipeline(ctx, &pb.ReadPipelineRequest{Id: res.Pipeline.Id})
+	if err != nil {
+		t.Fatalf("ReadPipeline() should not return error, but got %v", err)
+	}
+	if pipeline.Pipeline.Status != pb.PipelineStatus_PIPELINE_FAILED {
+		t.Errorf("expect the pipeline to have failed, but got %s", pipeline.Pipeline.Status)
+	}
+
+	// a task created after its parent failed is skipped right away
+	child, err := s.CreateTask(ctx, &pb.CreateTaskRequest{Task: &pb.Task{Commandline: "true", DependsOn: []int64{ids["test"]}}})
+	if err != nil {
+		t.Fatalf("CreateTask() should not return error, but got %v", err)
+	}
+	waitForStatus(t, taskDB, child.Task.Id, pb.TaskStatus_SKIPPED)
+
+	_, err = s.CreateTask(ctx, &pb.CreateTaskRequest{Task: &pb.Task{Commandline: "true", DependsOn: []int64{1000}}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect InvalidArgument for a missing parent, but got %v", err)
+	}
+}
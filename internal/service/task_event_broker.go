@@ -0,0 +1,162 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+
+	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	eventHistorySize    = 1024
+	subscriberQueueSize = 256
+)
+
+// ErrSequenceOutOfRange is returned when a subscriber asks to resume from a
+// sequence number that is no longer (or not yet) in the event history.
+type ErrSequenceOutOfRange struct {
+	Requested int64
+	Oldest    int64
+	Latest    int64
+}
+
+func (e *ErrSequenceOutOfRange) Error() string {
+	return fmt.Sprintf("sequence %d is out of range [%d, %d]", e.Requested, e.Oldest, e.Latest)
+}
+
+// TaskEventBroker turns listener callbacks into sequenced TaskEvents and fans
+// them out to subscribers. A bounded history is kept so that reconnecting
+// subscribers can resume from the last sequence number they saw.
+type TaskEventBroker struct {
+	mu          sync.Mutex
+	sequence    int64
+	history     []*pb.TaskEvent
+	subscribers map[*TaskEventSubscription]struct{}
+}
+
+// TaskEventSubscription receives live events on C. C is closed when the
+// subscription is cancelled or when the subscriber falls too far behind.
+type TaskEventSubscription struct {
+	C <-chan *pb.TaskEvent
+
+	ch     chan *pb.TaskEvent
+	broker *TaskEventBroker
+}
+
+func NewTaskEventBroker() *TaskEventBroker {
+	return &TaskEventBroker{
+		history:     make([]*pb.TaskEvent, 0, eventHistorySize),
+		subscribers: make(map[*TaskEventSubscription]struct{}),
+	}
+}
+
+// OnTaskCreated implements the TaskServiceListener interface
+func (b *TaskEventBroker) OnTaskCreated(task *pb.Task) {
+	b.publish(pb.TaskEventType_CREATED, task)
+}
+
+// OnTaskUpdated implements the TaskServiceListener interface
+func (b *TaskEventBroker) OnTaskUpdated(task *pb.Task) {
+	b.publish(pb.TaskEventType_UPDATED, task)
+}
+
+// OnTaskDeleted implements the TaskServiceListener interface
+func (b *TaskEventBroker) OnTaskDeleted(task *pb.Task) {
+	b.publish(pb.TaskEventType_DELETED, task)
+}
+
+func (b *TaskEventBroker) publish(eventType pb.TaskEventType, task *pb.Task) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sequence++
+	event := &pb.TaskEvent{
+		Sequence: b.sequence,
+		Type:     eventType,
+		Task:     task,
+		Time:     timestamppb.Now(),
+	}
+
+	if len(b.history) == eventHistorySize {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:eventHistorySize-1]
+	}
+	b.history = append(b.history, event)
+
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- event:
+		default:
+			// The subscriber is not keeping up; drop it so it can resume
+			// from the history instead of silently missing events.
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. If since is positive, the buffered
+// events newer than since are returned so the caller can replay them before
+// reading live events from the subscription.
+func (b *TaskEventBroker) Subscribe(since int64) (*TaskEventSubscription, []*pb.TaskEvent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []*pb.TaskEvent
+	if since > 0 {
+		oldest := b.sequence - int64(len(b.history))
+		if since < oldest || since > b.sequence {
+			return nil, nil, &ErrSequenceOutOfRange{Requested: since, Oldest: oldest, Latest: b.sequence}
+		}
+		backlog = append(backlog, b.history[len(b.history)-int(b.sequence-since):]...)
+	}
+
+	ch := make(chan *pb.TaskEvent, subscriberQueueSize)
+	sub := &TaskEventSubscription{C: ch, ch: ch, broker: b}
+	b.subscribers[sub] = struct{}{}
+	return sub, backlog, nil
+}
+
+// Cancel removes the subscription from the broker and closes its channel.
+func (sub *TaskEventSubscription) Cancel() {
+	b := sub.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// MatchTaskEvent reports whether the event passes the filters of the request.
+func MatchTaskEvent(req *pb.WatchTasksRequest, event *pb.TaskEvent) bool {
+	task := event.GetTask()
+	if ids := req.GetIds(); len(ids) > 0 {
+		found := false
+		for _, id := range ids {
+			if id == task.GetId() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if statuses := req.GetStatuses(); len(statuses) > 0 {
+		found := false
+		for _, s := range statuses {
+			if s == task.GetStatus() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
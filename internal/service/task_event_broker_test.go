@@ -0,0 +1,147 @@
+package service_test
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"internal/pb"
+	"service"
+
+	"google.golang.org/grpc"
+)
+
+func TestTaskEventBrokerResume(t *testing.T) {
+	broker := service.NewTaskEventBroker()
+
+	broker.OnTaskCreated(&pb.Task{Id: 1})
+	broker.OnTaskUpdated(&pb.Task{Id: 1, Status: pb.TaskStatus_RUNNING})
+	broker.OnTaskDeleted(&pb.Task{Id: 1})
+
+	sub, backlog, err := broker.Subscribe(1)
+	if err != nil {
+		t.Fatalf("Subscribe() should not return error, but got %v", err)
+	}
+	defer sub.Cancel()
+
+	if len(backlog) != 2 {
+		t.Fatalf("expect 2 events in the backlog, but got %d", len(backlog))
+	}
+	if backlog[0].Sequence != 2 || backlog[0].Type != pb.TaskEventType_UPDATED {
+		t.Errorf("expect UPDATED event with sequence 2, but got %v", backlog[0])
+	}
+
+	broker.OnTaskCreated(&pb.Task{Id: 2})
+	event := <-sub.C
+	if event.Sequence != 4 || event.Task.Id != 2 {
+		t.Errorf("expect live event with sequence 4 for task 2, but got %v", event)
+	}
+
+	_, _, err = broker.Subscribe(10)
+	if err == nil {
+		t.Error("expect an error when resuming from a future sequence, but got none")
+	}
+}
+
+func TestMatchTaskEvent(t *testing.T) {
+	event := &pb.TaskEvent{Task: &pb.Task{Id: 3, Status: pb.TaskStatus_FINISHED}}
+
+	if !service.MatchTaskEvent(&pb.WatchTasksRequest{}, event) {
+		t.Error("expect an empty filter to match")
+	}
+	if !service.MatchTaskEvent(&pb.WatchTasksRequest{Ids: []int64{1, 3}}, event) {
+		t.Error("expect the ID filter to match")
+	}
+	if service.MatchTaskEvent(&pb.WatchTasksRequest{Statuses: []pb.TaskStatus{pb.TaskStatus_RUNNING}}, event) {
+		t.Error("expect the status filter not to match")
+	}
+}
+
+// watchStream collects the events sent by WatchTasks until it has count
+type watchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	cancel context.CancelFunc
+	count  int
+	events []*pb.TaskEvent
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(event *pb.TaskEvent) error {
+	s.events = append(s.events, event)
+	if len(s.events) == s.count {
+		s.cancel()
+	}
+	return nil
+}
+
+// statusListener records the statuses it is told about
+type statusListener struct {
+	mu       sync.Mutex
+	statuses []pb.TaskStatus
+}
+
+func (l *statusListener) OnTaskCreated(task *pb.Task) {}
+func (l *statusListener) OnTaskDeleted(task *pb.Task) {}
+
+func (l *statusListener) OnTaskUpdated(task *pb.Task) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.statuses = append(l.statuses, task.Status)
+}
+
+func TestNotifyTaskUpdatedOrder(t *testing.T) {
+	s, _ := newTestTaskService(t)
+	listener := &statusListener{}
+	s.RegisterListener(listener)
+
+	const updates = 100
+	s.NotifyTaskCreated(&pb.Task{Id: 1})
+	for i := 0; i < updates; i++ {
+		status := pb.TaskStatus_RUNNING
+		if i%2 == 1 {
+			status = pb.TaskStatus_RETRYING
+		}
+		s.NotifyTaskUpdated(&pb.Task{Id: 1, Status: status})
+	}
+
+	// the broker has the events as soon as they are notified
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := &watchStream{ctx: ctx, cancel: cancel, count: updates}
+	if err := s.WatchTasks(&pb.WatchTasksRequest{SinceSequence: 1}, stream); err != nil {
+		t.Fatalf("WatchTasks() should not return error, but got %v", err)
+	}
+	if len(stream.events) != updates {
+		t.Fatalf("expect %d events, but got %d", updates, len(stream.events))
+	}
+	for i, event := range stream.events {
+		if event.Sequence != int64(i+2) || (event.Task.Status == pb.TaskStatus_RUNNING) != (i%2 == 0) {
+			t.Fatalf("expect the events in the order they were notified, but event %d is %v", i, event)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		listener.mu.Lock()
+		statuses := slices.Clone(listener.statuses)
+		listener.mu.Unlock()
+		if len(statuses) == updates {
+			for i, status := range statuses {
+				if (status == pb.TaskStatus_RUNNING) != (i%2 == 0) {
+					t.Fatalf("expect the listener to get the updates in order, but update %d is %s", i, status)
+				}
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the listener to get %d updates, but got %d", updates, len(statuses))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
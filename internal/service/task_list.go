@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"hash/fnv"
+	"internal/db"
+	"internal/pb"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// pageToken is the position a page of ReadTaskList ends at. It carries a
+// hash of the filter and the order, which must not change between pages.
+type pageToken struct {
+	db.TaskCursor
+	Query uint64 `json:"q"`
+}
+
+// queryHash identifies the filter and the order of a ReadTaskList request
+func queryHash(req *pb.ReadTaskListRequest) uint64 {
+	query := &pb.ReadTaskListRequest{OrderBy: req.GetOrderBy()}
+	if proto.Size(req.GetFilter()) > 0 {
+		query.Filter = req.GetFilter()
+	}
+	h := fnv.New64a()
+	b, _ := proto.MarshalOptions{Deterministic: true}.Marshal(query)
+	h.Write(b)
+	return h.Sum64()
+}
+
+func encodePageToken(req *pb.ReadTaskListRequest, cursor db.TaskCursor) string {
+	b, _ := json.Marshal(pageToken{TaskCursor: cursor, Query: queryHash(req)})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageToken(req *pb.ReadTaskListRequest) (*db.TaskCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(req.GetPageToken())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+	var token pageToken
+	if err := json.Unmarshal(b, &token); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+	if token.Query != queryHash(req) {
+		return nil, status.Error(codes.InvalidArgument, "page_token is for another filter or order")
+	}
+	return &token.TaskCursor, nil
+}
+
+// ReadTaskList implements the ReadTaskList gRPC method. Pages end where the
+// previous one stopped, so tasks created or deleted in between do not shift
+// them.
+func (s *TaskServiceServer) ReadTaskList(ctx context.Context, req *pb.ReadTaskListRequest) (*pb.TaskListResponse, error) {
+	pageSize := int(req.GetPageSize())
+	if pageSize == 0 {
+		pageSize = int(req.GetCount())
+	}
+	if pageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+	if _, ok := pb.TaskOrder_name[int32(req.GetOrderBy())]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown order_by %d", req.GetOrderBy())
+	}
+
+	query := db.TaskQuery{Filter: req.GetFilter(), Order: req.GetOrderBy()}
+	if len(req.GetPageToken()) > 0 {
+		after, err := decodePageToken(req)
+		if err != nil {
+			return nil, err
+		}
+		query.After = after
+	}
+	if pageSize > 0 {
+		// one more to know whether there is a next page
+		query.Limit = pageSize + 1
+	}
+
+	tasks, err := s.taskDB.QueryTasks(query)
+	if err != nil {
+		log.Printf("ReadTaskList: Failed to get tasks: %v", err)
+		return nil, err
+	}
+
+	res := &pb.TaskListResponse{Tasks: tasks}
+	if pageSize > 0 && len(tasks) > pageSize {
+		res.Tasks = tasks[:pageSize]
+		res.NextPageToken = encodePageToken(req, db.CursorOf(res.Tasks[pageSize-1], req.GetOrderBy()))
+	}
+	return res, nil
+}
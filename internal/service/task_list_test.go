@@ -0,0 +1,73 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"internal/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReadTaskListPages(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if _, err := taskDB.CreateTask(&pb.Task{Commandline: "true", WorkingDirectory: "/src"}); err != nil {
+			t.Fatalf("CreateTask() should not return error, but got %v", err)
+		}
+	}
+	if _, err := taskDB.CreateTask(&pb.Task{Commandline: "true", WorkingDirectory: "/tmp"}); err != nil {
+		t.Fatalf("CreateTask() should not return error, but got %v", err)
+	}
+
+	req := &pb.ReadTaskListRequest{PageSize: 2, Filter: &pb.TaskFilter{WorkingDirectory: "/src"}, OrderBy: pb.TaskOrder_OLDEST_FIRST}
+	var seen []int64
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("expect 3 pages")
+		}
+		res, err := s.ReadTaskList(ctx, req)
+		if err != nil {
+			t.Fatalf("ReadTaskList() should not return error, but got %v", err)
+		}
+		for _, task := range res.Tasks {
+			seen = append(seen, task.Id)
+		}
+		if len(res.NextPageToken) == 0 {
+			break
+		}
+		req.PageToken = res.NextPageToken
+	}
+	if len(seen) != 5 {
+		t.Fatalf("expect the 5 tasks in /src, but got %v", seen)
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i] <= seen[i-1] {
+			t.Errorf("expect the tasks oldest first without repeats, but got %v", seen)
+		}
+	}
+
+	first, err := s.ReadTaskList(ctx, &pb.ReadTaskListRequest{PageSize: 2})
+	if err != nil {
+		t.Fatalf("ReadTaskList() should not return error, but got %v", err)
+	}
+	_, err = s.ReadTaskList(ctx, &pb.ReadTaskListRequest{PageSize: 2, PageToken: first.NextPageToken, OrderBy: pb.TaskOrder_OLDEST_FIRST})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect InvalidArgument for a token of another order, but got %v", err)
+	}
+	_, err = s.ReadTaskList(ctx, &pb.ReadTaskListRequest{PageToken: "not a token"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect InvalidArgument for an invalid token, but got %v", err)
+	}
+
+	res, err := s.ReadTaskList(ctx, &pb.ReadTaskListRequest{Count: 3})
+	if err != nil {
+		t.Fatalf("ReadTaskList() should not return error, but got %v", err)
+	}
+	if len(res.Tasks) != 3 || len(res.NextPageToken) == 0 {
+		t.Errorf("expect count to limit the tasks to 3, but got %d", len(res.Tasks))
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"slices"
+	"sync"
+
+	"internal/pb"
+)
+
+// notify publishes a change of a task. The event broker gets it right away,
+// under notifyMu, so that watchers see the changes in the order they were
+// notified. The other listeners and the work that follows the change, e.g.
+// resolving the dependents of a finished task, may be slow and run on the
+// work queue in the same order.
+func (s *TaskServiceServer) notify(publish func(TaskServiceListener), then func()) {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+	publish(s.events)
+	listeners := slices.Clone(s.listeners)
+	s.work.push(func() {
+		for _, l := range listeners {
+			publish(l)
+		}
+		if then != nil {
+			then()
+		}
+	})
+}
+
+func (s *TaskServiceServer) notifyCreated(task *pb.Task) {
+	s.notify(func(l TaskServiceListener) { l.OnTaskCreated(task) }, nil)
+}
+
+func (s *TaskServiceServer) notifyDeleted(task *pb.Task) {
+	// a deleted parent counts as failed
+	s.notify(func(l TaskServiceListener) { l.OnTaskDeleted(task) }, func() { s.resolveDependents(task.Id) })
+}
+
+// workQueue runs functions one at a time in the order they were pushed. The
+// goroutine running them exits when the queue is empty.
+type workQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+func (q *workQueue) push(f func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, f)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+func (q *workQueue) run() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		f := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		f()
+	}
+}
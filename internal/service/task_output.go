@@ -0,0 +1,313 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"internal/outputlog"
+	"internal/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	outputChunkSize    = 32 * 1024
+	outputPollInterval = 500 * time.Millisecond
+	// the runner gzips the output of finished tasks to files ending with
+	// this when asked to
+	compressedOutputSuffix = ".gz"
+	// outputTimeFormat prefixes the lines of output when timestamps are
+	// requested
+	outputTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// outputView renders the records of an output log as the request asks
+type outputView struct {
+	stream     pb.OutputStream
+	timestamps bool
+	lineStart  bool
+}
+
+func newOutputView(req *pb.StreamTaskOutputRequest) *outputView {
+	return &outputView{stream: req.GetStream(), timestamps: req.GetTimestamps(), lineStart: true}
+}
+
+// render appends the data of the record if the view shows its stream. The
+// messages of the runner are shown in every view.
+func (v *outputView) render(buf []byte, record outputlog.Record) []byte {
+	switch {
+	case record.Stream == outputlog.Stdout && v.stream == pb.OutputStream_STDERR:
+		return buf
+	case record.Stream == outputlog.Stderr && v.stream == pb.OutputStream_STDOUT:
+		return buf
+	}
+	if v.timestamps && v.lineStart {
+		buf = record.Time.UTC().AppendFormat(buf, outputTimeFormat)
+		buf = append(buf, ' ')
+	}
+	v.lineStart = bytes.HasSuffix(record.Data, []byte("\n"))
+	return append(buf, record.Data...)
+}
+
+// StreamTaskOutput implements the StreamTaskOutput gRPC method
+func (s *TaskServiceServer) StreamTaskOutput(req *pb.StreamTaskOutputRequest, stream pb.TaskService_StreamTaskOutputServer) error {
+	offset := req.GetOffset()
+	output := ""
+	view := newOutputView(req)
+	ticker := time.NewTicker(outputPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Read the status before the output, so that once the task is seen
+		// as finished the following read is guaranteed to reach the end.
+		task, err := s.taskDB.GetTask(req.GetId())
+		if err != nil {
+			log.Printf("StreamTaskOutput: Failed to get task: %v", err)
+			return toStatusError(err)
+		}
+		if task.GetOutput() != output {
+			if len(output) > 0 && task.GetOutput() != output+compressedOutputSuffix {
+				// a new attempt writes to a new file, the offset was in the
+				// file of the previous one
+				offset = 0
+			}
+			output = task.GetOutput()
+		}
+
+		if req.GetFollow() && task.GetStatus() == pb.TaskStatus_RUNNING && s.runner != nil {
+			path, chunks, cancel, ok := s.runner.SubscribeOutput(task.GetId())
+			if ok && path == task.GetOutput() {
+				offset, err = followOutput(path, offset, view, chunks, stream)
+				cancel()
+				if err != nil {
+					log.Printf("StreamTaskOutput: Failed to send output: %v", err)
+					return err
+				}
+				if stream.Context().Err() != nil {
+					return nil
+				}
+				// the task exited or the stream fell behind, the status
+				// decides whether to follow on
+				continue
+			}
+			if ok {
+				// the output of the previous attempt is still recorded
+				cancel()
+			}
+		}
+
+		offset, err = sendOutput(task.GetOutput(), offset, view, stream)
+		if err != nil {
+			log.Printf("StreamTaskOutput: Failed to send output: %v", err)
+			return err
+		}
+
+		if !req.GetFollow() || task.GetStatus().IsFinal() {
+			return nil
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// followOutput sends the output already in the file and then the records
+// written by the task as they arrive, until chunks is closed. Every write
+// after subscribing is on the channel, so reading the file afterwards leaves
+// no gap; the overlap is skipped by offset.
+func followOutput(path string, offset int64, view *outputView, chunks <-chan *pb.TaskOutputChunk, stream pb.TaskService_StreamTaskOutputServer) (int64, error) {
+	offset, err := sendOutput(path, offset, view, stream)
+	if err != nil {
+		return offset, err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return offset, nil
+		case chunk, ok := <-chunks:
+			if !ok {
+				return offset, nil
+			}
+			if chunk.GetOffset() > offset {
+				// should not happen, but the file has the missing part
+				offset, err = sendOutput(path, offset, view, stream)
+				if err != nil {
+					return offset, err
+				}
+				if chunk.GetOffset() > offset {
+					return offset, fmt.Errorf("output file %s is missing bytes %d to %d", path, offset, chunk.GetOffset())
+				}
+			}
+			if chunk.GetNextOffset() <= offset {
+				continue
+			}
+			// chunks are made of complete records, so offset is at the start
+			// of one
+			records := chunk.GetData()[offset-chunk.GetOffset():]
+			scanner := outputlog.NewScanner(bytes.NewReader(records), offset)
+			var data []byte
+			for scanner.Scan() {
+				data = view.render(data, scanner.Record())
+			}
+			if err := scanner.Err(); err != nil {
+				return offset, err
+			}
+			if len(data) > 0 {
+				if err := stream.Send(&pb.TaskOutputChunk{Offset: offset, Data: data, NextOffset: chunk.GetNextOffset()}); err != nil {
+					return offset, err
+				}
+			}
+			offset = chunk.GetNextOffset()
+		}
+	}
+}
+
+// outputFile reads an output file from an offset. Compressed files are
+// decompressed, offsets are always in the uncompressed file.
+type outputFile struct {
+	io.Reader
+	closers []io.Closer
+	// isLog is false for the raw output recorded before stdout and stderr
+	// were kept apart
+	isLog  bool
+	offset int64
+}
+
+// openOutput opens the output file at offset, moved past the header of an
+// output log
+func openOutput(path string, offset int64) (*outputFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	out := &outputFile{Reader: file, closers: []io.Closer{file}}
+	if strings.HasSuffix(path, compressedOutputSuffix) {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		out.Reader = zr
+		out.closers = append(out.closers, zr)
+	}
+
+	header := make([]byte, len(outputlog.Header))
+	n, err := io.ReadFull(out, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		out.Close()
+		return nil, err
+	}
+	out.isLog = outputlog.IsLog(header[:n])
+	if out.isLog {
+		offset = max(offset, int64(len(outputlog.Header)))
+	}
+	out.offset = offset
+
+	err = nil
+	switch {
+	case offset < int64(n):
+		out.Reader = io.MultiReader(bytes.NewReader(header[offset:n]), out.Reader)
+	case out.Reader == io.Reader(file):
+		_, err = file.Seek(offset, io.SeekStart)
+	default:
+		_, err = io.CopyN(io.Discard, out, offset-int64(n))
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *outputFile) Close() error {
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		f.closers[i].Close()
+	}
+	return nil
+}
+
+// sendOutput sends what the view shows of the output file after offset and
+// returns the offset where the next read should start.
+func sendOutput(path string, offset int64, view *outputView, stream pb.TaskService_StreamTaskOutputServer) (int64, error) {
+	if len(path) == 0 {
+		// the runner has not picked the task up yet
+		return offset, nil
+	}
+
+	file, err := openOutput(path, offset)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return offset, nil
+		}
+		return offset, err
+	}
+	defer file.Close()
+
+	if !file.isLog {
+		if view.stream != pb.OutputStream_MERGED {
+			return offset, status.Error(codes.FailedPrecondition, "the output of this task was recorded before stdout and stderr were kept apart")
+		}
+		return sendRawOutput(file, file.offset, stream)
+	}
+
+	offset = file.offset
+	scanner := outputlog.NewScanner(file, offset)
+	var data []byte
+	for scanner.Scan() {
+		data = view.render(data, scanner.Record())
+		if len(data) >= outputChunkSize {
+			if err := stream.Send(&pb.TaskOutputChunk{Offset: offset, Data: data, NextOffset: scanner.Offset()}); err != nil {
+				return offset, err
+			}
+			offset = scanner.Offset()
+			data = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return offset, err
+	}
+	if len(data) > 0 {
+		if err := stream.Send(&pb.TaskOutputChunk{Offset: offset, Data: data, NextOffset: scanner.Offset()}); err != nil {
+			return offset, err
+		}
+	}
+	return scanner.Offset(), nil
+}
+
+// sendRawOutput sends the rest of a raw output file
+func sendRawOutput(file io.Reader, offset int64, stream pb.TaskService_StreamTaskOutputServer) (int64, error) {
+	buf := make([]byte, outputChunkSize)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			next := offset + int64(n)
+			chunk := &pb.TaskOutputChunk{Offset: offset, Data: buf[:n], NextOffset: next}
+			if err := stream.Send(chunk); err != nil {
+				return offset, err
+			}
+			offset = next
+		}
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package service_test
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"internal/outputlog"
+	"internal/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// outputStream collects the output sent by StreamTaskOutput
+type outputStream struct {
+	grpc.ServerStream
+	data   []byte
+	chunks []*pb.TaskOutputChunk
+	// onSend is called after every chunk
+	onSend func()
+}
+
+func (s *outputStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *outputStream) Send(chunk *pb.TaskOutputChunk) error {
+	s.data = append(s.data, chunk.Data...)
+	s.chunks = append(s.chunks, chunk)
+	if s.onSend != nil {
+		s.onSend()
+	}
+	return nil
+}
+
+func TestStreamTaskOutput(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+	dir := t.TempDir()
+
+	start := time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC)
+	log := []byte(outputlog.Header)
+	log = outputlog.Append(log, start, outputlog.Stdout, []byte("compiling\n"))
+	log = outputlog.Append(log, start.Add(time.Second), outputlog.Stderr, []byte("warning: unused\n"))
+	log = outputlog.Append(log, start.Add(2*time.Second), outputlog.Stdout, []byte("done\n"))
+
+	path := filepath.Join(dir, "task_output_1.log")
+	os.WriteFile(path, log, 0644)
+	task, _ := taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_FINISHED, Commandline: "make", Output: path})
+
+	tests := []struct {
+		req  *pb.StreamTaskOutputRequest
+		want string
+	}{
+		{&pb.StreamTaskOutputRequest{}, "compiling\nwarning: unused\ndone\n"},
+		{&pb.StreamTaskOutputRequest{Stream: pb.OutputStream_STDOUT}, "compiling\ndone\n"},
+		{&pb.StreamTaskOutputRequest{Stream: pb.OutputStream_STDERR, Timestamps: true}, "2024-06-01T23:00:01.000Z warning: unused\n"},
+	}
+	for _, test := range tests {
+		test.req.Id = task.Id
+		stream := &outputStream{}
+		if err := s.StreamTaskOutput(test.req, stream); err != nil {
+			t.Fatalf("StreamTaskOutput(%v) should not return error, but got %v", test.req, err)
+		}
+		if string(stream.data) != test.want {
+			t.Errorf("StreamTaskOutput(%v) should send %q, but got %q", test.req, test.want, stream.data)
+		}
+	}
+
+	// resuming after the first record of a compressed log
+	file, _ := os.Create(path + ".gz")
+	zw := gzip.NewWriter(file)
+	zw.Write(log)
+	zw.Close()
+	file.Close()
+	task.Output = path + ".gz"
+	taskDB.UpdateTask(task)
+
+	stream := &outputStream{}
+	if err := s.StreamTaskOutput(&pb.StreamTaskOutputRequest{Id: task.Id}, stream); err != nil {
+		t.Fatalf("StreamTaskOutput() should not return error, but got %v", err)
+	}
+	next := stream.chunks[len(stream.chunks)-1].NextOffset
+	if string(stream.data) != "compiling\nwarning: unused\ndone\n" || next != int64(len(log)) {
+		t.Fatalf("expect the whole compressed output up to offset %d, but got %q up to %d", len(log), stream.data, next)
+	}
+	offset := int64(len(outputlog.Header)) + int64(len(outputlog.Append(nil, start, outputlog.Stdout, []byte("compiling\n"))))
+	stream = &outputStream{}
+	if err := s.StreamTaskOutput(&pb.StreamTaskOutputRequest{Id: task.Id, Offset: offset}, stream); err != nil {
+		t.Fatalf("StreamTaskOutput() should not return error, but got %v", err)
+	}
+	if string(stream.data) != "warning: unused\ndone\n" {
+		t.Errorf("expect the output after the first record, but got %q", stream.data)
+	}
+}
+
+func TestStreamRawTaskOutput(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+
+	// recorded before stdout and stderr were kept apart
+	path := filepath.Join(t.TempDir(), "task_output_1.log")
+	os.WriteFile(path, []byte("compiling\ndone\n"), 0644)
+	task, _ := taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_FINISHED, Commandline: "make", Output: path})
+
+	stream := &outputStream{}
+	if err := s.StreamTaskOutput(&pb.StreamTaskOutputRequest{Id: task.Id, Offset: 10}, stream); err != nil {
+		t.Fatalf("StreamTaskOutput() should not return error, but got %v", err)
+	}
+	if string(stream.data) != "done\n" {
+		t.Errorf("expect the raw output after offset 10, but got %q", stream.data)
+	}
+
+	err := s.StreamTaskOutput(&pb.StreamTaskOutputRequest{Id: task.Id, Stream: pb.OutputStream_STDERR}, &outputStream{})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expect FailedPrecondition for the stderr of raw output, but got %v", err)
+	}
+}
+
+func TestStreamTaskOutputAcrossAttempts(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+	dir := t.TempDir()
+
+	writeLog := func(name string, text string) string {
+		path := filepath.Join(dir, name)
+		log := outputlog.Append([]byte(outputlog.Header), time.Now(), outputlog.Stdout, []byte(text))
+		os.WriteFile(path, log, 0644)
+		return path
+	}
+	first := writeLog("task_output_1.log", "attempt 1\n")
+	task, _ := taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_RETRYING, Commandline: "make", Output: first})
+
+	// the next attempt starts and finishes while the output is followed,
+	// then its output is compressed
+	second := writeLog("task_output_2.log", "attempt 2 has a longer output\n")
+	file, _ := os.Create(second + ".gz")
+	zw := gzip.NewWriter(file)
+	log, _ := os.ReadFile(second)
+	zw.Write(log)
+	zw.Close()
+	file.Close()
+	updates := []func(){
+		func() {
+			task.Status = pb.TaskStatus_RUNNING
+			task.Output = second
+			taskDB.UpdateTask(task)
+		},
+		func() {
+			task.Status = pb.TaskStatus_FINISHED
+			task.Output = second + ".gz"
+			taskDB.UpdateTask(task)
+		},
+	}
+	stream := &outputStream{}
+	stream.onSend = func() {
+		if len(updates) > 0 {
+			updates[0]()
+			updates = updates[1:]
+		}
+	}
+
+	if err := s.StreamTaskOutput(&pb.StreamTaskOutputRequest{Id: task.Id, Follow: true}, stream); err != nil {
+		t.Fatalf("StreamTaskOutput() should not return error, but got %v", err)
+	}
+	if string(stream.data) != "attempt 1\nattempt 2 has a longer output\n" {
+		t.Errorf("expect the output of both attempts once, but got %q", stream.data)
+	}
+}
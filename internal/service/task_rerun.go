@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"internal/pb"
+	"log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// rerunFields are the settings RerunTask copies from the task being rerun
+var rerunFields = []protoreflect.Name{
+	"commandline", "working_directory", "priority", "timeout", "env",
+	"clear_env", "shell", "argv", "recovery_policy", "retry_policy",
+	"interactive", "max_output_bytes",
+}
+
+// rerunOverrides are the fields a rerun can override: the copied settings,
+// and when and after which tasks the rerun starts
+var rerunOverrides = func() map[string]bool {
+	overrides := map[string]bool{"not_before": true, "depends_on": true}
+	for _, name := range rerunFields {
+		overrides[string(name)] = true
+	}
+	return overrides
+}()
+
+// RerunTask implements the RerunTask gRPC method. The new task is created
+// like CreateTask would, with rerun_of set to the task it was copied from.
+func (s *TaskServiceServer) RerunTask(ctx context.Context, req *pb.RerunTaskRequest) (*pb.TaskResponse, error) {
+	origin, err := s.taskDB.GetTask(req.GetId())
+	if err != nil {
+		log.Printf("RerunTask: Failed to get task: %v", err)
+		return nil, toStatusError(err)
+	}
+
+	task := &pb.Task{RerunOf: origin.Id}
+	from, to := origin.ProtoReflect(), task.ProtoReflect()
+	fields := to.Descriptor().Fields()
+	for _, name := range rerunFields {
+		field := fields.ByName(name)
+		if from.Has(field) {
+			to.Set(field, from.Get(field))
+		}
+	}
+
+	overrides := req.GetOverrides().ProtoReflect()
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		overrides.Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+			paths = append(paths, string(field.Name()))
+			return true
+		})
+	}
+	for _, path := range paths {
+		if !rerunOverrides[path] {
+			return nil, status.Errorf(codes.InvalidArgument, "%s cannot be overridden", path)
+		}
+		field := fields.ByName(protoreflect.Name(path))
+		if overrides.Has(field) {
+			to.Set(field, overrides.Get(field))
+		} else {
+			to.Clear(field)
+		}
+	}
+
+	return s.CreateTask(ctx, &pb.CreateTaskRequest{Task: task, Force: req.GetForce()})
+}
@@ -0,0 +1,65 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"internal/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestRerunTask(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+	ctx := context.Background()
+
+	origin, err := taskDB.CreateTask(&pb.Task{
+		Status:           pb.TaskStatus_FINISHED,
+		ReturnCode:       1,
+		Commandline:      "make test",
+		WorkingDirectory: "/src",
+		Priority:         5,
+		Env:              map[string]string{"GOFLAGS": "-v"},
+		Shell:            pb.Shell_BASH,
+	})
+	if err != nil {
+		t.Fatalf("CreateTask() should not return error, but got %v", err)
+	}
+
+	res, err := s.RerunTask(ctx, &pb.RerunTaskRequest{Id: origin.Id})
+	if err != nil {
+		t.Fatalf("RerunTask() should not return error, but got %v", err)
+	}
+	rerun := res.Task
+	if rerun.Id == origin.Id || rerun.RerunOf != origin.Id || rerun.Status != pb.TaskStatus_NEW || rerun.ReturnCode != 0 {
+		t.Errorf("expect a NEW task rerunning task %d, but got %v", origin.Id, rerun)
+	}
+	if rerun.Commandline != "make test" || rerun.WorkingDirectory != "/src" || rerun.Priority != 5 || rerun.Env["GOFLAGS"] != "-v" || rerun.Shell != pb.Shell_BASH {
+		t.Errorf("expect the settings to be copied, but got %v", rerun)
+	}
+
+	// priority 0 is only overridden through the mask
+	res, err = s.RerunTask(ctx, &pb.RerunTaskRequest{
+		Id:         origin.Id,
+		Overrides:  &pb.Task{Commandline: "make race"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"commandline", "priority", "env"}},
+	})
+	if err != nil {
+		t.Fatalf("RerunTask() should not return error, but got %v", err)
+	}
+	if res.Task.Commandline != "make race" || res.Task.Priority != 0 || len(res.Task.Env) != 0 || res.Task.WorkingDirectory != "/src" {
+		t.Errorf("expect commandline, priority and env to be overridden, but got %v", res.Task)
+	}
+
+	_, err = s.RerunTask(ctx, &pb.RerunTaskRequest{Id: origin.Id, Overrides: &pb.Task{Status: pb.TaskStatus_RUNNING}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect InvalidArgument when overriding the status, but got %v", err)
+	}
+
+	_, err = s.RerunTask(ctx, &pb.RerunTaskRequest{Id: 1000})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expect NotFound for a missing task, but got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"internal/pb"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The retention policy keeps the task list and the output directory from
+// growing forever. PruneTasks removes the finished tasks breaking it, oldest
+// first, together with the output files of all their attempts. Pinned tasks,
+// tasks that have not finished and the tasks a WAITING task depends on are
+// never removed, but the size of their output counts against
+// max_total_bytes. A WAITING task would be skipped if one of its parents was
+// removed, as a deleted parent counts as failed.
+
+// SetRetention sets the policy PruneTasks applies when the request has none
+// and the directory the runner creates the output files in. Files outside of
+// that directory are never removed.
+func (s *TaskServiceServer) SetRetention(policy *pb.RetentionPolicy, outputDir string) {
+	s.retention = policy
+	s.outputDir = outputDir
+}
+
+// PinTask implements the PinTask gRPC method
+func (s *TaskServiceServer) PinTask(ctx context.Context, req *pb.PinTaskRequest) (*pb.TaskResponse, error) {
+	err := s.taskDB.UpdateTaskPinned(req.Id, req.Pinned)
+	if err != nil {
+		log.Printf("PinTask: Failed to update task: %v", err)
+		return nil, toStatusError(err)
+	}
+
+	task, err := s.taskDB.GetTask(req.Id)
+	if err != nil {
+		log.Printf("PinTask: Failed to get task: %v", err)
+		return nil, toStatusError(err)
+	}
+
+	s.NotifyTaskUpdated(task)
+	return &pb.TaskResponse{Task: task}, nil
+}
+
+// PruneTasks implements the PruneTasks gRPC method
+func (s *TaskServiceServer) PruneTasks(ctx context.Context, req *pb.PruneTasksRequest) (*pb.PruneTasksResponse, error) {
+	policy := req.GetPolicy()
+	if policy == nil {
+		policy = s.retention
+	}
+	if policy.GetMaxAge().AsDuration() < 0 || policy.GetMaxCount() < 0 || policy.GetMaxTotalBytes() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "the limits of the retention policy cannot be negative")
+	}
+	res := &pb.PruneTasksResponse{}
+	if policy.GetMaxAge().AsDuration() == 0 && policy.GetMaxCount() == 0 && policy.GetMaxTotalBytes() == 0 {
+		return res, nil
+	}
+
+	s.pruneMu.Lock()
+	defer s.pruneMu.Unlock()
+
+	tasks, err := s.taskDB.GetTasks()
+	if err != nil {
+		log.Printf("PruneTasks: Failed to get tasks: %v", err)
+		return nil, err
+	}
+	parents := make(map[int64]bool)
+	for _, task := range tasks {
+		if task.Status == pb.TaskStatus_WAITING {
+			for _, id := range task.DependsOn {
+				parents[id] = true
+			}
+		}
+	}
+	var candidates []*taskOutputs
+	var totalBytes int64
+	for _, task := range tasks {
+		outputs := s.taskOutputs(task)
+		totalBytes += outputs.bytes
+		if task.Status.IsFinal() && !task.Pinned && !parents[task.Id] {
+			candidates = append(candidates, outputs)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return finishedAt(candidates[i].task).Before(finishedAt(candidates[j].task))
+	})
+
+	for _, outputs := range applyRetention(policy, candidates, totalBytes, time.Now()) {
+		res.Tasks = append(res.Tasks, &pb.PrunedTask{
+			Task:        outputs.task,
+			Reason:      outputs.reason,
+			OutputBytes: outputs.bytes,
+		})
+		res.FreedBytes += outputs.bytes
+		if req.DryRun {
+			continue
+		}
+
+		if err := s.taskDB.DeleteTask(outputs.task.Id); err != nil {
+			log.Printf("PruneTasks: Failed to delete task %d: %v", outputs.task.Id, err)
+			return nil, err
+		}
+		for _, path := range outputs.files {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("PruneTasks: Failed to remove output of task %d: %v", outputs.task.Id, err)
+			}
+		}
+		s.notifyDeleted(outputs.task)
+	}
+
+	if !req.DryRun && len(res.Tasks) > 0 {
+		log.Printf("PruneTasks: Removed %d tasks and %d bytes of output", len(res.Tasks), res.FreedBytes)
+	}
+	return res, nil
+}
+
+// taskOutputs is a task with the output files of its attempts
+type taskOutputs struct {
+	task   *pb.Task
+	files  []string
+	bytes  int64
+	reason string
+}
+
+// taskOutputs collects the output files of the task that are in the output
+// directory and still exist
+func (s *TaskServiceServer) taskOutputs(task *pb.Task) *taskOutputs {
+	paths := []string{task.Output}
+	attempts, err := s.taskDB.GetTaskAttempts(task.Id)
+	if err != nil {
+		log.Printf("PruneTasks: Failed to get the attempts of task %d: %v", task.Id, err)
+	}
+	for _, attempt := range attempts {
+		paths = append(paths, attempt.Output)
+	}
+
+	outputs := &taskOutputs{task: task}
+	for _, path := range paths {
+		if !s.ownsOutput(path) || slices.Contains(outputs.files, path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		outputs.files = append(outputs.files, path)
+		outputs.bytes += info.Size()
+	}
+	return outputs
+}
+
+// ownsOutput reports whether the runner created the output file
+func (s *TaskServiceServer) ownsOutput(path string) bool {
+	if len(s.outputDir) == 0 || len(path) == 0 {
+		return false
+	}
+	return filepath.Dir(filepath.Clean(path)) == filepath.Clean(s.outputDir)
+}
+
+// finishedAt is the time a final task finished, or was created if it never
+// ran
+func finishedAt(task *pb.Task) time.Time {
+	if task.FinishTime != nil {
+		return task.FinishTime.AsTime()
+	}
+	return task.CreateTime.AsTime()
+}
+
+// applyRetention returns the tasks the policy removes, with the limit each
+// one breaks, in ID order. The candidates are the finished tasks that are not
+// pinned, oldest first; totalBytes is the size of the output of all tasks.
+func applyRetention(policy *pb.RetentionPolicy, candidates []*taskOutputs, totalBytes int64, now time.Time) []*taskOutputs {
+	var pruned []*taskOutputs
+	prune := func(outputs *taskOutputs, reason string) {
+		if len(outputs.reason) > 0 {
+			return
+		}
+		outputs.reason = reason
+		totalBytes -= outputs.bytes
+		pruned = append(pruned, outputs)
+	}
+
+	if maxAge := policy.GetMaxAge().AsDuration(); maxAge > 0 {
+		for _, outputs := range candidates {
+			if now.Sub(finishedAt(outputs.task)) > maxAge {
+				prune(outputs, fmt.Sprintf("finished more than %s ago", maxAge))
+			}
+		}
+	}
+	if maxCount := int(policy.GetMaxCount()); maxCount > 0 {
+		for i := 0; i < len(candidates)-maxCount; i++ {
+			prune(candidates[i], fmt.Sprintf("more than %d finished tasks", maxCount))
+		}
+	}
+	if maxBytes := policy.GetMaxTotalBytes(); maxBytes > 0 {
+		for i := 0; i < len(candidates) && totalBytes > maxBytes; i++ {
+			prune(candidates[i], fmt.Sprintf("output of all tasks over %d bytes", maxBytes))
+		}
+	}
+
+	sort.Slice(pruned, func(i, j int) bool { return pruned[i].task.Id < pruned[j].task.Id })
+	return pruned
+}
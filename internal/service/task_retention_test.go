@@ -0,0 +1,161 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"internal/db"
+	"internal/pb"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// createFinishedTask creates a task that finished some time ago with an
+// output file of the given size
+func createFinishedTask(t *testing.T, taskDB db.TaskDatabase, dir string, ago time.Duration, size int) *pb.Task {
+	t.Helper()
+	output, err := os.CreateTemp(dir, "task_output_*.log")
+	if err != nil {
+		t.Fatalf("CreateTemp() should not return error, but got %v", err)
+	}
+	output.WriteString(strings.Repeat("x", size))
+	output.Close()
+
+	task, err := taskDB.CreateTask(&pb.Task{
+		Status:      pb.TaskStatus_FINISHED,
+		Commandline: "make",
+		Output:      output.Name(),
+		FinishTime:  timestamppb.New(time.Now().Add(-ago)),
+	})
+	if err != nil {
+		t.Fatalf("CreateTask() should not return error, but got %v", err)
+	}
+	return task
+}
+
+func prunedIDs(res *pb.PruneTasksResponse) []int64 {
+	var ids []int64
+	for _, p := range res.Tasks {
+		ids = append(ids, p.Task.Id)
+	}
+	return ids
+}
+
+func TestPruneTasks(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+	dir := t.TempDir()
+	s.SetRetention(&pb.RetentionPolicy{MaxAge: durationpb.New(48 * time.Hour)}, dir)
+
+	old := createFinishedTask(t, taskDB, dir, 72*time.Hour, 10)
+	pinned := createFinishedTask(t, taskDB, dir, 96*time.Hour, 10)
+	if _, err := s.PinTask(context.Background(), &pb.PinTaskRequest{Id: pinned.Id, Pinned: true}); err != nil {
+		t.Fatalf("PinTask() should not return error, but got %v", err)
+	}
+	// the output of an earlier attempt of the task
+	retried := createFinishedTask(t, taskDB, dir, 30*time.Hour, 100)
+	attemptOutput := filepath.Join(dir, "task_output_attempt.log")
+	os.WriteFile(attemptOutput, []byte("attempt 1"), 0644)
+	taskDB.CreateTaskAttempt(&pb.TaskAttempt{TaskId: retried.Id, Attempt: 1, Output: attemptOutput})
+	recent := createFinishedTask(t, taskDB, dir, time.Hour, 1000)
+	running, _ := taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_RUNNING, Commandline: "sleep 100"})
+
+	// the policy of the server
+	res, err := s.PruneTasks(context.Background(), &pb.PruneTasksRequest{DryRun: true})
+	if err != nil {
+		t.Fatalf("PruneTasks() should not return error, but got %v", err)
+	}
+	if ids := prunedIDs(res); !slices.Equal(ids, []int64{old.Id}) {
+		t.Errorf("expect task %d to be pruned by age, but got %v", old.Id, ids)
+	}
+
+	policy := &pb.RetentionPolicy{MaxCount: 1}
+	res, err = s.PruneTasks(context.Background(), &pb.PruneTasksRequest{Policy: policy, DryRun: true})
+	if err != nil {
+		t.Fatalf("PruneTasks() should not return error, but got %v", err)
+	}
+	if ids := prunedIDs(res); !slices.Equal(ids, []int64{old.Id, retried.Id}) {
+		t.Errorf("expect tasks %d and %d to be pruned by count, but got %v", old.Id, retried.Id, ids)
+	}
+	if res.FreedBytes != 10+100+9 {
+		t.Errorf("expect 119 bytes to be freed, but got %d", res.FreedBytes)
+	}
+	if _, err := os.Stat(old.Output); err != nil {
+		t.Errorf("expect a dry run to keep the output, but got %v", err)
+	}
+
+	// the recent task alone is over the size limit
+	policy = &pb.RetentionPolicy{MaxTotalBytes: 500}
+	res, err = s.PruneTasks(context.Background(), &pb.PruneTasksRequest{Policy: policy})
+	if err != nil {
+		t.Fatalf("PruneTasks() should not return error, but got %v", err)
+	}
+	if ids := prunedIDs(res); !slices.Equal(ids, []int64{old.Id, retried.Id, recent.Id}) {
+		t.Errorf("expect every unpinned finished task to be pruned by size, but got %v", ids)
+	}
+	for _, path := range []string{old.Output, retried.Output, attemptOutput, recent.Output} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expect %s to be removed, but got %v", path, err)
+		}
+	}
+	if _, err := os.Stat(pinned.Output); err != nil {
+		t.Errorf("expect the output of the pinned task to be kept, but got %v", err)
+	}
+
+	tasks, err := taskDB.GetTasks()
+	if err != nil {
+		t.Fatalf("GetTasks() should not return error, but got %v", err)
+	}
+	var ids []int64
+	for _, task := range tasks {
+		ids = append(ids, task.Id)
+	}
+	if !slices.Equal(ids, []int64{pinned.Id, running.Id}) {
+		t.Errorf("expect the pinned and the running task to be kept, but got %v", ids)
+	}
+}
+
+func TestPruneTasksKeepsForeignOutput(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+	s.SetRetention(nil, t.TempDir())
+
+	// a task created with an output path of its own
+	task := createFinishedTask(t, taskDB, t.TempDir(), time.Hour, 10)
+	res, err := s.PruneTasks(context.Background(), &pb.PruneTasksRequest{Policy: &pb.RetentionPolicy{MaxAge: durationpb.New(time.Minute)}})
+	if err != nil {
+		t.Fatalf("PruneTasks() should not return error, but got %v", err)
+	}
+	if ids := prunedIDs(res); !slices.Equal(ids, []int64{task.Id}) || res.FreedBytes != 0 {
+		t.Errorf("expect task %d to be pruned without freeing bytes, but got %v, %d bytes", task.Id, ids, res.FreedBytes)
+	}
+	if _, err := os.Stat(task.Output); err != nil {
+		t.Errorf("expect a file outside of the output directory to be kept, but got %v", err)
+	}
+}
+
+func TestPruneTasksKeepsParents(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+	dir := t.TempDir()
+	s.SetRetention(nil, dir)
+
+	old := createFinishedTask(t, taskDB, dir, 72*time.Hour, 10)
+	parent := createFinishedTask(t, taskDB, dir, 72*time.Hour, 10)
+	running, _ := taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_RUNNING, Commandline: "make"})
+	child, _ := taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_WAITING, Commandline: "make install", DependsOn: []int64{parent.Id, running.Id}})
+
+	res, err := s.PruneTasks(context.Background(), &pb.PruneTasksRequest{Policy: &pb.RetentionPolicy{MaxAge: durationpb.New(48 * time.Hour)}})
+	if err != nil {
+		t.Fatalf("PruneTasks() should not return error, but got %v", err)
+	}
+	if ids := prunedIDs(res); !slices.Equal(ids, []int64{old.Id}) {
+		t.Errorf("expect only task %d to be pruned, but got %v", old.Id, ids)
+	}
+	if _, err := taskDB.GetTask(parent.Id); err != nil {
+		t.Errorf("expect the parent of WAITING task %d to be kept, but got %v", child.Id, err)
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"strings"
+
+	"internal/db"
+	"internal/outputlog"
+	"internal/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxIndexedOutput is how much of the start of the output is searchable
+	maxIndexedOutput   = 4 * 1024 * 1024
+	defaultSearchLimit = 20
+)
+
+// The output of a task is indexed once the task is final, with the records of
+// both streams merged as the output view shows them by default.
+
+// IndexTaskOutputs indexes the final tasks that are not indexed yet, e.g.
+// the ones that finished before the index existed
+func (s *TaskServiceServer) IndexTaskOutputs() {
+	tasks, err := s.taskDB.GetUnindexedTasks()
+	if err != nil {
+		log.Printf("IndexTaskOutputs: Failed to get unindexed tasks: %v", err)
+		return
+	}
+	for _, task := range tasks {
+		if task.Status.IsFinal() {
+			s.indexOutput(task)
+		}
+	}
+}
+
+// indexOutput indexes the commandline and the output of the task. An output
+// file that was removed leaves the commandline searchable.
+func (s *TaskServiceServer) indexOutput(task *pb.Task) {
+	output, err := readOutputText(task.GetOutput(), maxIndexedOutput)
+	if err != nil {
+		log.Printf("indexOutput: Failed to read the output of task %d: %v", task.Id, err)
+	}
+	if err := s.taskDB.IndexTaskOutput(task.Id, task.Commandline, output); err != nil {
+		log.Printf("indexOutput: Failed to index task %d: %v", task.Id, err)
+	}
+}
+
+// readOutputText returns up to limit bytes of the merged output as text
+func readOutputText(path string, limit int) (string, error) {
+	if len(path) == 0 {
+		return "", nil
+	}
+	file, err := openOutput(path, 0)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer file.Close()
+
+	var data []byte
+	if file.isLog {
+		view := &outputView{stream: pb.OutputStream_MERGED}
+		scanner := outputlog.NewScanner(file, file.offset)
+		for len(data) < limit && scanner.Scan() {
+			data = view.render(data, scanner.Record())
+		}
+		err = scanner.Err()
+	} else {
+		data, err = io.ReadAll(io.LimitReader(file, int64(limit)))
+	}
+	if len(data) > limit {
+		data = data[:limit]
+	}
+	return strings.ToValidUTF8(string(data), "�"), err
+}
+
+// SearchTasks implements the SearchTasks gRPC method
+func (s *TaskServiceServer) SearchTasks(ctx context.Context, req *pb.SearchTasksRequest) (*pb.SearchTasksResponse, error) {
+	if strings.TrimSpace(req.GetQuery()) == "" {
+		return nil, status.Error(codes.InvalidArgument, "the query cannot be empty")
+	}
+	limit := int(req.GetLimit())
+	if limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "the limit cannot be negative")
+	}
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+
+	results, err := s.taskDB.SearchTasks(req.GetQuery(), limit)
+	if err != nil {
+		log.Printf("SearchTasks: Failed to search tasks: %v", err)
+		if _, ok := err.(*db.ErrInvalidQuery); ok {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, err
+	}
+	return &pb.SearchTasksResponse{Results: results}, nil
+}
@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"internal/outputlog"
+	"internal/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSearchTasks(t *testing.T) {
+	s, taskDB := newTestTaskService(t)
+	dir := t.TempDir()
+
+	now := time.Now()
+	log := []byte(outputlog.Header)
+	log = outputlog.Append(log, now, outputlog.Stdout, []byte("compiling\n"))
+	log = outputlog.Append(log, now, outputlog.Stderr, []byte("main.go:3: undefined: "))
+	log = outputlog.Append(log, now, outputlog.Stderr, []byte("fmt\n"))
+	path := filepath.Join(dir, "task_output_1.log")
+	os.WriteFile(path, log, 0644)
+	failed, _ := taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_FINISHED, ReturnCode: 1, Commandline: "go build", Output: path})
+
+	// recorded before stdout and stderr were kept apart
+	path = filepath.Join(dir, "task_output_2.log")
+	os.WriteFile(path, []byte("fmt.Println\n"), 0644)
+	raw, _ := taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_FINISHED, Commandline: "grep -r fmt", Output: path})
+	taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_RUNNING, Commandline: "go test fmt"})
+
+	s.IndexTaskOutputs()
+
+	res, err := s.SearchTasks(context.Background(), &pb.SearchTasksRequest{Query: "undefined"})
+	if err != nil {
+		t.Fatalf("SearchTasks() should not return error, but got %v", err)
+	}
+	if len(res.Results) != 1 || res.Results[0].Task.Id != failed.Id {
+		t.Fatalf("expect task %d to match, but got %v", failed.Id, res.Results)
+	}
+	if lines := res.Results[0].Lines; len(lines) != 1 || lines[0].Number != 2 || lines[0].Text != "main.go:3: undefined: fmt" {
+		t.Errorf("expect the second line of the merged output, but got %v", lines)
+	}
+
+	// tasks that have not finished are not indexed
+	res, err = s.SearchTasks(context.Background(), &pb.SearchTasksRequest{Query: "fmt", Limit: 5})
+	if err != nil || len(res.Results) != 2 || res.Results[0].Task.Id != raw.Id {
+		t.Errorf("expect tasks %d and %d to match, but got %v, %v", raw.Id, failed.Id, res, err)
+	}
+
+	for _, query := range []string{"", `"fmt`} {
+		_, err := s.SearchTasks(context.Background(), &pb.SearchTasksRequest{Query: query})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expect InvalidArgument for query %q, but got %v", query, err)
+		}
+	}
+
+	// a task is indexed once the runner reports it finished
+	path = filepath.Join(dir, "task_output_3.log")
+	os.WriteFile(path, []byte("linking\n"), 0644)
+	linked, _ := taskDB.CreateTask(&pb.Task{Status: pb.TaskStatus_FINISHED, Commandline: "go build", Output: path})
+	s.NotifyTaskUpdated(linked)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err = s.SearchTasks(context.Background(), &pb.SearchTasksRequest{Query: "linking"})
+		if err == nil && len(res.Results) == 1 && res.Results[0].Task.Id == linked.Id {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect task %d to be indexed, but got %v, %v", linked.Id, res, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,9 +2,17 @@ package service
 
 import (
 	"context"
+	"errors"
 	"internal/db"
 	"internal/pb"
+	"internal/verifier"
 	"log"
+	"slices"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type TaskStatusProxy interface {
@@ -12,32 +20,75 @@ type TaskStatusProxy interface {
 	RemoveListener(listener TaskServiceListener)
 }
 
+// TaskRunner is the part of the runner the service controls directly
+type TaskRunner interface {
+	CancelTask(id int64) bool
+	Workers() []*pb.WorkerStatus
+	// SubscribeOutput follows the output of a running task. It returns the
+	// path of the output file, a channel receiving the chunks of the output
+	// log written from now on, which is closed when the task exits or the subscriber falls
+	// behind, and a function cancelling the subscription. It reports false if
+	// the task is not running.
+	SubscribeOutput(id int64) (string, <-chan *pb.TaskOutputChunk, func(), bool)
+	// WriteInput and ResizeTerminal drive the terminal of a running
+	// interactive task
+	WriteInput(id int64, data []byte) error
+	ResizeTerminal(id int64, rows, cols uint16) error
+}
+
 type TaskServiceServer struct {
 	pb.UnimplementedTaskServiceServer // Embedding for forward compatibility
 	taskDB                            db.TaskDatabase
 	listeners                         []TaskServiceListener
+	events                            *TaskEventBroker
+	runner                            TaskRunner
+	verifier                          verifier.Verifier
+	retention                         *pb.RetentionPolicy
+	outputDir                         string
+	// notifyMu orders the events and guards listeners, see notify
+	notifyMu sync.Mutex
+	work     workQueue
+	// index indexes the output of finished tasks apart from work, as
+	// reading a large output would hold up the events behind it
+	index workQueue
+	// pruneMu keeps the periodic pruning and PruneTasks calls apart
+	pruneMu sync.Mutex
 }
 
 // NewTaskServiceServer creates a new TaskServiceServer
 func NewTaskServiceServer(taskDB db.TaskDatabase) *TaskServiceServer {
 	return &TaskServiceServer{
-		taskDB:    taskDB,
-		listeners: make([]TaskServiceListener, 0)}
+		taskDB: taskDB,
+		events: NewTaskEventBroker()}
 }
 
 // RegisterListener implements the TaskStatusProxy interface
 func (s *TaskServiceServer) RegisterListener(listener TaskServiceListener) {
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
 	s.listeners = append(s.listeners, listener)
 }
 
 // RemoveListener implements the TaskStatusProxy interface
 func (s *TaskServiceServer) RemoveListener(listener TaskServiceListener) {
-	for i, l := range s.listeners {
-		if l == listener {
-			s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
-			return
-		}
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+	s.listeners = slices.DeleteFunc(s.listeners, func(l TaskServiceListener) bool {
+		return l == listener
+	})
+}
+
+// toStatusError turns database errors into gRPC status errors
+func toStatusError(err error) error {
+	if _, ok := err.(*db.ErrNoRows); ok {
+		return status.Error(codes.NotFound, "task not found")
 	}
+	return err
+}
+
+// SetRunner sets the runner that executes the tasks of this service
+func (s *TaskServiceServer) SetRunner(runner TaskRunner) {
+	s.runner = runner
 }
 
 // ReadTask implements the ReadTask gRPC method
@@ -45,9 +96,14 @@ func (s *TaskServiceServer) ReadTask(ctx context.Context, req *pb.ReadTaskReques
 	task, err := s.taskDB.GetTask(req.Id)
 	if err != nil {
 		log.Printf("Readtask: Failed to get task: %v", err)
+		return nil, toStatusError(err)
+	}
+	attempts, err := s.taskDB.GetTaskAttempts(req.Id)
+	if err != nil {
+		log.Printf("Readtask: Failed to get task attempts: %v", err)
 		return nil, err
 	}
-	return &pb.TaskResponse{Task: task}, nil
+	return &pb.TaskResponse{Task: task, Attempts: attempts}, nil
 }
 
 // DeleteTask implements the DeleteTask gRPC method
@@ -56,7 +112,7 @@ func (s *TaskServiceServer) DeleteTask(ctx context.Context, req *pb.DeleteTaskRe
 	task, err := s.taskDB.GetTask(req.Id)
 	if err != nil {
 		log.Printf("DeleteTask: Failed to get task: %v", err)
-		return nil, err
+		return nil, toStatusError(err)
 	}
 
 	err = s.taskDB.DeleteTask(req.Id)
@@ -65,37 +121,181 @@ func (s *TaskServiceServer) DeleteTask(ctx context.Context, req *pb.DeleteTaskRe
 		return nil, err
 	}
 
-	go func() {
-		for _, l := range s.listeners {
-			l.OnTaskDeleted(task)
-		}
-	}()
+	s.notifyDeleted(task)
 
 	return &pb.TaskResponse{Task: task}, nil
 }
 
-// ReadTaskList implements the ReadTaskList gRPC method
-func (s *TaskServiceServer) ReadTaskList(ctx context.Context, req *pb.ReadTaskListRequest) (*pb.TaskListResponse, error) {
-	tasks, err := s.taskDB.GetTasks()
-	if err != nil {
-		log.Printf("ReadTaskList: Failed to get tasks: %v", err)
-		return nil, err
+// validateTask checks the settings of a task before it is stored
+func validateTask(task *pb.Task) error {
+	if task.GetShell() == pb.Shell_EXEC && len(task.GetArgv()) == 0 {
+		return status.Error(codes.InvalidArgument, "argv is required when shell is EXEC")
 	}
-	return &pb.TaskListResponse{Tasks: tasks}, nil
+	return nil
 }
 
+// CreateTask implements the CreateTask gRPC method. The task is verified
+// first unless force is set. A task with depends_on is created WAITING.
 func (s *TaskServiceServer) CreateTask(ctx context.Context, req *pb.CreateTaskRequest) (*pb.TaskResponse, error) {
-	task, err := s.taskDB.CreateTask(req.GetTask())
+	if err := validateTask(req.GetTask()); err != nil {
+		return nil, err
+	}
+	if !req.GetForce() {
+		if err := s.verifyTask(req.GetTask()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkParents(req.GetTask()); err != nil {
+		return nil, err
+	}
+
+	task := req.GetTask()
+	if len(task.GetDependsOn()) > 0 {
+		task = proto.Clone(task).(*pb.Task)
+		task.Status = pb.TaskStatus_WAITING
+	}
+	task, err := s.taskDB.CreateTask(task)
 	if err != nil {
 		log.Printf("CreateTask: Failed to create task: %v", err)
 		return nil, err
 	}
 
-	go func() {
-		for _, l := range s.listeners {
-			l.OnTaskCreated(task)
+	s.notifyCreated(task)
+
+	// the parents may have finished already
+	if task.Status == pb.TaskStatus_WAITING {
+		s.resolveTask(task)
+	}
+	return &pb.TaskResponse{Task: task}, nil
+}
+
+// CancelTask implements the CancelTask gRPC method. A NEW, WAITING or RETRYING
+// task is cancelled right away; a RUNNING task is terminated by the runner, which records the
+// final status once the process has exited.
+func (s *TaskServiceServer) CancelTask(ctx context.Context, req *pb.CancelTaskRequest) (*pb.TaskResponse, error) {
+	task, err := s.taskDB.GetTask(req.Id)
+	if err != nil {
+		log.Printf("CancelTask: Failed to get task: %v", err)
+		return nil, toStatusError(err)
+	}
+
+	if task.Status == pb.TaskStatus_NEW || task.Status == pb.TaskStatus_WAITING || task.Status == pb.TaskStatus_RETRYING {
+		cancelled, err := s.taskDB.UpdateTaskStatus(task.Id, task.Status, pb.TaskStatus_CANCELLED)
+		if err != nil {
+			log.Printf("CancelTask: Failed to update task: %v", err)
+			return nil, err
 		}
-	}()
+		if cancelled {
+			task.Status = pb.TaskStatus_CANCELLED
+			s.NotifyTaskUpdated(task)
+			return &pb.TaskResponse{Task: task}, nil
+		}
+
+		// the runner picked the task up in the meantime
+		task, err = s.taskDB.GetTask(req.Id)
+		if err != nil {
+			log.Printf("CancelTask: Failed to get task: %v", err)
+			return nil, toStatusError(err)
+		}
+	}
 
+	if task.Status != pb.TaskStatus_RUNNING {
+		return nil, status.Errorf(codes.FailedPrecondition, "task %d is already %s", task.Id, task.Status)
+	}
+	if s.runner == nil || !s.runner.CancelTask(task.Id) {
+		return nil, status.Errorf(codes.FailedPrecondition, "task %d is not running in this server", task.Id)
+	}
+
+	return &pb.TaskResponse{Task: task}, nil
+}
+
+// ReprioritizeTask implements the ReprioritizeTask gRPC method. Only tasks
+// that are still waiting in the queue can be reprioritized.
+func (s *TaskServiceServer) ReprioritizeTask(ctx context.Context, req *pb.ReprioritizeTaskRequest) (*pb.TaskResponse, error) {
+	updated, err := s.taskDB.UpdateTaskPriority(req.Id, req.Priority)
+	if err != nil {
+		log.Printf("ReprioritizeTask: Failed to update task: %v", err)
+		return nil, err
+	}
+
+	task, err := s.taskDB.GetTask(req.Id)
+	if err != nil {
+		log.Printf("ReprioritizeTask: Failed to get task: %v", err)
+		return nil, toStatusError(err)
+	}
+	if !updated {
+		return nil, status.Errorf(codes.FailedPrecondition, "task %d is already %s", task.Id, task.Status)
+	}
+
+	s.NotifyTaskUpdated(task)
 	return &pb.TaskResponse{Task: task}, nil
 }
+
+// ListWorkers implements the ListWorkers gRPC method
+func (s *TaskServiceServer) ListWorkers(ctx context.Context, req *pb.ListWorkersRequest) (*pb.ListWorkersResponse, error) {
+	if s.runner == nil {
+		return &pb.ListWorkersResponse{}, nil
+	}
+	return &pb.ListWorkersResponse{Workers: s.runner.Workers()}, nil
+}
+
+// NotifyTaskCreated tells the listeners about a task created outside of the
+// service, e.g. by the scheduler.
+func (s *TaskServiceServer) NotifyTaskCreated(task *pb.Task) {
+	s.notifyCreated(task)
+}
+
+// NotifyTaskUpdated tells the listeners about a task updated outside of the
+// service, e.g. by the runner. The tasks waiting for it are resolved once it
+// is final.
+func (s *TaskServiceServer) NotifyTaskUpdated(task *pb.Task) {
+	s.notify(func(l TaskServiceListener) { l.OnTaskUpdated(task) }, func() {
+		if task.Status.IsFinal() {
+			s.resolveDependents(task.Id)
+		}
+	})
+	if task.Status.IsFinal() {
+		s.index.push(func() { s.indexOutput(task) })
+	}
+}
+
+// WatchTasks implements the WatchTasks gRPC method
+func (s *TaskServiceServer) WatchTasks(req *pb.WatchTasksRequest, stream pb.TaskService_WatchTasksServer) error {
+	sub, backlog, err := s.events.Subscribe(req.GetSinceSequence())
+	if err != nil {
+		log.Printf("WatchTasks: Failed to subscribe: %v", err)
+		var errRange *ErrSequenceOutOfRange
+		if errors.As(err, &errRange) {
+			return status.Error(codes.OutOfRange, err.Error())
+		}
+		return err
+	}
+	defer sub.Cancel()
+
+	for _, event := range backlog {
+		if !MatchTaskEvent(req, event) {
+			continue
+		}
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.Aborted, "watcher fell behind, resume from the last received sequence")
+			}
+			if !MatchTaskEvent(req, event) {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"internal/pb"
+	"internal/verifier"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SetVerifier sets the checks run before a task is created. Tasks are not
+// verified without one.
+func (s *TaskServiceServer) SetVerifier(verifier verifier.Verifier) {
+	s.verifier = verifier
+}
+
+func (s *TaskServiceServer) problems(task *pb.Task) []*pb.TaskProblem {
+	if s.verifier == nil {
+		return nil
+	}
+	return s.verifier.Verify(task)
+}
+
+// verifyTask returns an InvalidArgument error listing the problems of the
+// task as a BadRequest, or nil if it has none
+func (s *TaskServiceServer) verifyTask(task *pb.Task) error {
+	return problemsError(s.problems(task))
+}
+
+func problemsError(problems []*pb.TaskProblem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, 0, len(problems))
+	badRequest := &errdetails.BadRequest{}
+	for _, p := range problems {
+		descriptions = append(descriptions, fmt.Sprintf("%s: %s", p.Field, p.Description))
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       p.Field,
+			Description: p.Description,
+		})
+	}
+	st := status.New(codes.InvalidArgument, "task failed verification: "+strings.Join(descriptions, "; "))
+	if withDetails, err := st.WithDetails(badRequest); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// VerifyTask implements the VerifyTask gRPC method. It returns the problems
+// CreateTask would refuse the task for.
+func (s *TaskServiceServer) VerifyTask(ctx context.Context, req *pb.VerifyTaskRequest) (*pb.VerifyTaskResponse, error) {
+	if err := validateTask(req.GetTask()); err != nil {
+		return nil, err
+	}
+	return &pb.VerifyTaskResponse{Problems: s.problems(req.GetTask())}, nil
+}
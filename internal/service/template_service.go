@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"internal/db"
+	"internal/pb"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// placeholderPattern matches a {{placeholder}} in a template
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// TaskCreator creates the tasks run from templates
+type TaskCreator interface {
+	CreateTask(ctx context.Context, req *pb.CreateTaskRequest) (*pb.TaskResponse, error)
+}
+
+type TemplateServiceServer struct {
+	pb.UnimplementedTemplateServiceServer
+	taskDB db.TaskDatabase
+	tasks  TaskCreator
+}
+
+// NewTemplateServiceServer creates a new TemplateServiceServer that runs
+// templates with tasks
+func NewTemplateServiceServer(taskDB db.TaskDatabase, tasks TaskCreator) *TemplateServiceServer {
+	return &TemplateServiceServer{taskDB: taskDB, tasks: tasks}
+}
+
+// toTemplateStatusError turns database errors into gRPC status errors
+func toTemplateStatusError(err error) error {
+	if _, ok := err.(*db.ErrNoRows); ok {
+		return status.Error(codes.NotFound, "template not found")
+	}
+	return err
+}
+
+// templateStrings returns the strings of a task that may contain placeholders
+func templateStrings(task *pb.Task) []string {
+	strs := []string{task.GetCommandline(), task.GetWorkingDirectory()}
+	strs = append(strs, task.GetArgv()...)
+	for _, v := range task.GetEnv() {
+		strs = append(strs, v)
+	}
+	return strs
+}
+
+// templateParameters returns the sorted names of the placeholders in a task
+func templateParameters(task *pb.Task) []string {
+	found := make(map[string]bool)
+	for _, str := range templateStrings(task) {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(str, -1) {
+			found[match[1]] = true
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandTemplate returns a copy of the task with the placeholders replaced by
+// the parameters. Every placeholder must be given a value and every parameter
+// must be used.
+func expandTemplate(task *pb.Task, params map[string]string) (*pb.Task, error) {
+	var missing, unknown []string
+	used := make(map[string]bool)
+	for _, name := range templateParameters(task) {
+		used[name] = true
+		if _, ok := params[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	for name := range params {
+		if !used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing parameters: %s", strings.Join(missing, ", "))
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown parameters: %s", strings.Join(unknown, ", "))
+	}
+
+	expand := func(s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+			return params[placeholderPattern.FindStringSubmatch(placeholder)[1]]
+		})
+	}
+	expanded := proto.Clone(task).(*pb.Task)
+	expanded.Commandline = expand(expanded.Commandline)
+	expanded.WorkingDirectory = expand(expanded.WorkingDirectory)
+	for i, arg := range expanded.Argv {
+		expanded.Argv[i] = expand(arg)
+	}
+	for k, v := range expanded.Env {
+		expanded.Env[k] = expand(v)
+	}
+	return expanded, nil
+}
+
+// withParameters fills in the parameters of a template read from the
+// database
+func withParameters(template *pb.Template) *pb.Template {
+	template.Parameters = templateParameters(template.GetTask())
+	return template
+}
+
+func validateTemplate(template *pb.Template) error {
+	if len(template.GetName()) == 0 {
+		return status.Error(codes.InvalidArgument, "name is required")
+	}
+	if template.GetTask() == nil {
+		return status.Error(codes.InvalidArgument, "task is required")
+	}
+	return validateTask(template.GetTask())
+}
+
+// CreateTemplate implements the CreateTemplate gRPC method
+func (s *TemplateServiceServer) CreateTemplate(ctx context.Context, req *pb.CreateTemplateRequest) (*pb.TemplateResponse, error) {
+	template := req.GetTemplate()
+	if err := validateTemplate(template); err != nil {
+		return nil, err
+	}
+	if _, err := s.taskDB.GetTemplate(template.GetName()); err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "template %q already exists", template.GetName())
+	}
+
+	template, err := s.taskDB.CreateTemplate(template)
+	if err != nil {
+		log.Printf("CreateTemplate: Failed to create template: %v", err)
+		return nil, err
+	}
+
+	return &pb.TemplateResponse{Template: withParameters(template)}, nil
+}
+
+// ListTemplates implements the ListTemplates gRPC method
+func (s *TemplateServiceServer) ListTemplates(ctx context.Context, req *pb.ListTemplatesRequest) (*pb.ListTemplatesResponse, error) {
+	templates, err := s.taskDB.GetTemplates()
+	if err != nil {
+		log.Printf("ListTemplates: Failed to get templates: %v", err)
+		return nil, err
+	}
+	for _, template := range templates {
+		withParameters(template)
+	}
+	return &pb.ListTemplatesResponse{Templates: templates}, nil
+}
+
+// UpdateTemplate implements the UpdateTemplate gRPC method. The task of the
+// template with the same name is replaced.
+func (s *TemplateServiceServer) UpdateTemplate(ctx context.Context, req *pb.UpdateTemplateRequest) (*pb.TemplateResponse, error) {
+	template := req.GetTemplate()
+	if err := validateTemplate(template); err != nil {
+		return nil, err
+	}
+
+	template, err := s.taskDB.UpdateTemplate(template)
+	if err != nil {
+		log.Printf("UpdateTemplate: Failed to update template: %v", err)
+		return nil, toTemplateStatusError(err)
+	}
+
+	return &pb.TemplateResponse{Template: withParameters(template)}, nil
+}
+
+// DeleteTemplate implements the DeleteTemplate gRPC method. The tasks run from
+// the template are kept.
+func (s *TemplateServiceServer) DeleteTemplate(ctx context.Context, req *pb.DeleteTemplateRequest) (*pb.TemplateResponse, error) {
+	template, err := s.taskDB.GetTemplate(req.GetName())
+	if err != nil {
+		log.Printf("DeleteTemplate: Failed to get template: %v", err)
+		return nil, toTemplateStatusError(err)
+	}
+
+	err = s.taskDB.DeleteTemplate(req.GetName())
+	if err != nil {
+		log.Printf("DeleteTemplate: Failed to delete template: %v", err)
+		return nil, err
+	}
+
+	return &pb.TemplateResponse{Template: withParameters(template)}, nil
+}
+
+// RunTemplate implements the RunTemplate gRPC method. It creates a task from
+// the template with the placeholders replaced by the parameters.
+func (s *TemplateServiceServer) RunTemplate(ctx context.Context, req *pb.RunTemplateRequest) (*pb.TaskResponse, error) {
+	template, err := s.taskDB.GetTemplate(req.GetName())
+	if err != nil {
+		log.Printf("RunTemplate: Failed to get template: %v", err)
+		return nil, toTemplateStatusError(err)
+	}
+
+	task, err := expandTemplate(template.GetTask(), req.GetParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "template %q: %v", req.GetName(), err)
+	}
+	task.Status = pb.TaskStatus_NEW
+
+	return s.tasks.CreateTask(ctx, &pb.CreateTaskRequest{Task: task, Force: req.GetForce()})
+}
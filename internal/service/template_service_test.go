@@ -0,0 +1,122 @@
+package service_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"internal/db"
+	"internal/pb"
+	"service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeTaskCreator struct {
+	tasks []*pb.Task
+}
+
+func (c *fakeTaskCreator) CreateTask(ctx context.Context, req *pb.CreateTaskRequest) (*pb.TaskResponse, error) {
+	c.tasks = append(c.tasks, req.GetTask())
+	return &pb.TaskResponse{Task: req.GetTask()}, nil
+}
+
+func newTestTemplateService(t *testing.T) (*service.TemplateServiceServer, *fakeTaskCreator) {
+	taskDB, err := db.NewTaskDatabase(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("NewTaskDatabase() should not return error, but got %v", err)
+	}
+	if err := taskDB.Init(); err != nil {
+		t.Fatalf("Init() should not return error, but got %v", err)
+	}
+	t.Cleanup(func() { taskDB.Uninit() })
+
+	creator := &fakeTaskCreator{}
+	return service.NewTemplateServiceServer(taskDB, creator), creator
+}
+
+func TestRunTemplate(t *testing.T) {
+	s, creator := newTestTemplateService(t)
+	ctx := context.Background()
+
+	res, err := s.CreateTemplate(ctx, &pb.CreateTemplateRequest{Template: &pb.Template{
+		Name: "build",
+		Task: &pb.Task{
+			Commandline:      "git checkout {{branch}} && make {{ target }}",
+			WorkingDirectory: "/src/{{project}}",
+			Env:              map[string]string{"BRANCH": "{{branch}}"},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("CreateTemplate() should not return error, but got %v", err)
+	}
+	if got := res.Template.Parameters; len(got) != 3 || got[0] != "branch" || got[1] != "project" || got[2] != "target" {
+		t.Errorf("expect parameters [branch project target], but got %v", got)
+	}
+
+	_, err = s.CreateTemplate(ctx, &pb.CreateTemplateRequest{Template: &pb.Template{Name: "build", Task: &pb.Task{Commandline: "ls"}}})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expect AlreadyExists for a duplicate name, but got %v", err)
+	}
+
+	_, err = s.RunTemplate(ctx, &pb.RunTemplateRequest{Name: "build", Parameters: map[string]string{"branch": "main"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect InvalidArgument for missing parameters, but got %v", err)
+	}
+
+	params := map[string]string{"branch": "main", "target": "all", "project": "web"}
+	if _, err := s.RunTemplate(ctx, &pb.RunTemplateRequest{Name: "build", Parameters: params}); err != nil {
+		t.Fatalf("RunTemplate() should not return error, but got %v", err)
+	}
+	if len(creator.tasks) != 1 {
+		t.Fatalf("expect 1 task to be created, but got %d", len(creator.tasks))
+	}
+	task := creator.tasks[0]
+	if task.Commandline != "git checkout main && make all" || task.WorkingDirectory != "/src/web" || task.Env["BRANCH"] != "main" {
+		t.Errorf("expect the placeholders to be replaced, but got %v", task)
+	}
+
+	params["unused"] = "x"
+	_, err = s.RunTemplate(ctx, &pb.RunTemplateRequest{Name: "build", Parameters: params})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect InvalidArgument for unknown parameters, but got %v", err)
+	}
+
+	_, err = s.RunTemplate(ctx, &pb.RunTemplateRequest{Name: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expect NotFound for a missing template, but got %v", err)
+	}
+}
+
+func TestUpdateTemplate(t *testing.T) {
+	s, _ := newTestTemplateService(t)
+	ctx := context.Background()
+
+	_, err := s.UpdateTemplate(ctx, &pb.UpdateTemplateRequest{Template: &pb.Template{Name: "test", Task: &pb.Task{Commandline: "go test"}}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expect NotFound when updating a missing template, but got %v", err)
+	}
+
+	if _, err := s.CreateTemplate(ctx, &pb.CreateTemplateRequest{Template: &pb.Template{Name: "test", Task: &pb.Task{Commandline: "go test"}}}); err != nil {
+		t.Fatalf("CreateTemplate() should not return error, but got %v", err)
+	}
+	res, err := s.UpdateTemplate(ctx, &pb.UpdateTemplateRequest{Template: &pb.Template{Name: "test", Task: &pb.Task{Commandline: "go test {{pkg}}"}}})
+	if err != nil {
+		t.Fatalf("UpdateTemplate() should not return error, but got %v", err)
+	}
+	if res.Template.Task.Commandline != "go test {{pkg}}" || len(res.Template.Parameters) != 1 {
+		t.Errorf("expect the template to be replaced, but got %v", res.Template)
+	}
+
+	if _, err := s.DeleteTemplate(ctx, &pb.DeleteTemplateRequest{Name: "test"}); err != nil {
+		t.Fatalf("DeleteTemplate() should not return error, but got %v", err)
+	}
+	list, err := s.ListTemplates(ctx, &pb.ListTemplatesRequest{})
+	if err != nil {
+		t.Fatalf("ListTemplates() should not return error, but got %v", err)
+	}
+	if len(list.Templates) != 0 {
+		t.Errorf("expect no templates after deleting, but got %d", len(list.Templates))
+	}
+}
@@ -0,0 +1,410 @@
+package verifier
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"internal/pb"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Verifier checks a task before it is created and returns the problems it
+// found
+type Verifier interface {
+	Verify(task *pb.Task) []*pb.TaskProblem
+}
+
+// Func is a function used as a Verifier
+type Func func(task *pb.Task) []*pb.TaskProblem
+
+func (f Func) Verify(task *pb.Task) []*pb.TaskProblem {
+	return f(task)
+}
+
+// Chain runs every verifier and returns all the problems found
+type Chain []Verifier
+
+func (c Chain) Verify(task *pb.Task) []*pb.TaskProblem {
+	var problems []*pb.TaskProblem
+	for _, v := range c {
+		problems = append(problems, v.Verify(task)...)
+	}
+	return problems
+}
+
+// Default returns the verifiers run before a task is created
+func Default() Chain {
+	return Chain{
+		Func(WorkingDirectory),
+		Func(ShellSyntax),
+		Func(Executables),
+		Func(PathArguments),
+	}
+}
+
+func problem(field string, format string, args ...any) *pb.TaskProblem {
+	return &pb.TaskProblem{Field: field, Description: fmt.Sprintf(format, args...)}
+}
+
+// WorkingDirectory checks the working directory exists and is a directory
+func WorkingDirectory(task *pb.Task) []*pb.TaskProblem {
+	dir := task.GetWorkingDirectory()
+	if len(dir) == 0 {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return []*pb.TaskProblem{problem("working_directory", "%s does not exist", dir)}
+	}
+	if !info.IsDir() {
+		return []*pb.TaskProblem{problem("working_directory", "%s is not a directory", dir)}
+	}
+	return nil
+}
+
+// ShellSyntax checks the commandline parses in the shell of the task
+func ShellSyntax(task *pb.Task) []*pb.TaskProblem {
+	if task.GetShell() == pb.Shell_EXEC {
+		return nil
+	}
+	if _, err := parse(task); err != nil {
+		return []*pb.TaskProblem{problem("commandline", "syntax error: %v", err)}
+	}
+	return nil
+}
+
+// Executables checks the commands run by the task resolve on PATH, or exist
+// if they are paths
+func Executables(task *pb.Task) []*pb.TaskProblem {
+	commands, ok := commandsOf(task)
+	if !ok {
+		return nil
+	}
+
+	var problems []*pb.TaskProblem
+	seen := make(map[string]bool)
+	for _, command := range commands {
+		name := command.args[0]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if strings.Contains(name, "/") {
+			if path, ok := resolve(task, name, command.afterCd); ok && !isExecutable(path) && !command.writes(path) {
+				problems = append(problems, problem(command.field, "%s is not an executable file", name))
+			}
+			continue
+		}
+		if task.GetShell() != pb.Shell_EXEC && (shellBuiltins[name] || command.functions[name]) {
+			continue
+		}
+		if _, err := lookPath(task, name); err != nil {
+			problems = append(problems, problem(command.field, "%s not found on PATH", name))
+		}
+	}
+	return problems
+}
+
+// PathArguments checks the arguments that look like paths exist. An argument
+// looks like a path if it starts with /, ./, ../ or ~/, or if its first
+// component exists in the working directory. The files the script creates,
+// by redirecting output to them or with commands like mkdir or cp, need not
+// exist before it runs and are skipped.
+func PathArguments(task *pb.Task) []*pb.TaskProblem {
+	commands, ok := commandsOf(task)
+	if !ok {
+		return nil
+	}
+
+	var problems []*pb.TaskProblem
+	for _, command := range commands {
+		for _, arg := range command.args[1:] {
+			if !looksLikePath(task, arg) {
+				continue
+			}
+			path, ok := resolve(task, arg, command.afterCd)
+			if !ok || command.writes(path) {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				problems = append(problems, problem(command.field, "%s does not exist", arg))
+			}
+		}
+	}
+	return problems
+}
+
+// command is a simple command run by a task with its literal arguments
+type command struct {
+	// the field of the task the command comes from
+	field string
+	args  []string
+	// the functions defined by the script, which are not executables
+	functions map[string]bool
+	// whether the script changes directory, after which relative paths
+	// cannot be resolved
+	afterCd bool
+	// the resolved paths of the files the script creates
+	written map[string]bool
+}
+
+// writes reports whether the script creates the file or a directory it is in
+func (c command) writes(path string) bool {
+	for {
+		if c.written[path] {
+			return true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+}
+
+// commandsOf returns the commands of the task whose name is a literal. It
+// reports false if the commandline does not parse.
+func commandsOf(task *pb.Task) ([]command, bool) {
+	if task.GetShell() == pb.Shell_EXEC {
+		if len(task.GetArgv()) == 0 {
+			return nil, false
+		}
+		c := command{field: "argv", args: task.GetArgv(), written: make(map[string]bool)}
+		for _, arg := range createdBy(c.args) {
+			if path, ok := resolve(task, arg, false); ok {
+				c.written[path] = true
+			}
+		}
+		return []command{c}, true
+	}
+
+	file, err := parse(task)
+	if err != nil {
+		return nil, false
+	}
+
+	functions := make(map[string]bool)
+	changesDir := false
+	syntax.Walk(file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.FuncDecl:
+			functions[n.Name.Value] = true
+		case *syntax.CallExpr:
+			if len(n.Args) > 0 {
+				if name, ok := literal(n.Args[0]); ok && (name == "cd" || name == "pushd") {
+					changesDir = true
+				}
+			}
+		}
+		return true
+	})
+
+	var commands []command
+	written := make(map[string]bool)
+	addWritten := func(name string) {
+		if path, ok := resolve(task, name, changesDir); ok {
+			written[path] = true
+		}
+	}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.Stmt:
+			for _, redirect := range n.Redirs {
+				if !outputRedirects[redirect.Op] {
+					continue
+				}
+				if name, ok := literal(redirect.Word); ok {
+					addWritten(name)
+				}
+			}
+		case *syntax.CallExpr:
+			if len(n.Args) == 0 {
+				return true
+			}
+			name, ok := literal(n.Args[0])
+			if !ok {
+				return true
+			}
+			args := []string{name}
+			for _, word := range n.Args[1:] {
+				if arg, ok := literal(word); ok {
+					args = append(args, arg)
+				}
+			}
+			for _, arg := range createdBy(args) {
+				addWritten(arg)
+			}
+			commands = append(commands, command{
+				field:     "commandline",
+				args:      args,
+				functions: functions,
+				afterCd:   changesDir,
+				written:   written,
+			})
+		}
+		return true
+	})
+	return commands, true
+}
+
+// outputRedirects are the redirections that create the file they name
+var outputRedirects = map[syntax.RedirOperator]bool{
+	syntax.RdrOut:   true,
+	syntax.AppOut:   true,
+	syntax.RdrInOut: true,
+	syntax.ClbOut:   true,
+	syntax.RdrAll:   true,
+	syntax.AppAll:   true,
+}
+
+// createdBy returns the arguments naming the files a command creates: every
+// operand of mkdir, touch and tee, and the destination of cp, mv, ln and
+// install
+func createdBy(args []string) []string {
+	var operands []string
+	for _, arg := range args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			operands = append(operands, arg)
+		}
+	}
+	switch args[0] {
+	case "mkdir", "touch", "tee":
+		return operands
+	case "cp", "mv", "ln", "install":
+		if len(operands) > 1 {
+			return operands[len(operands)-1:]
+		}
+	}
+	return nil
+}
+
+func parse(task *pb.Task) (*syntax.File, error) {
+	lang := syntax.LangPOSIX
+	if task.GetShell() == pb.Shell_BASH {
+		lang = syntax.LangBash
+	}
+	parser := syntax.NewParser(syntax.Variant(lang))
+	return parser.Parse(strings.NewReader(task.GetCommandline()), "")
+}
+
+// literal returns the value of a word made of plain and quoted text only,
+// without expansions or globs
+func literal(word *syntax.Word) (string, bool) {
+	var sb strings.Builder
+	for _, part := range word.Parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			if strings.ContainsAny(p.Value, "*?[{\\") {
+				return "", false
+			}
+			sb.WriteString(p.Value)
+		case *syntax.SglQuoted:
+			if p.Dollar {
+				return "", false
+			}
+			sb.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			for _, qp := range p.Parts {
+				lit, ok := qp.(*syntax.Lit)
+				if !ok {
+					return "", false
+				}
+				sb.WriteString(lit.Value)
+			}
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), sb.Len() > 0
+}
+
+func looksLikePath(task *pb.Task, arg string) bool {
+	if strings.HasPrefix(arg, "-") || strings.Contains(arg, "://") || strings.Contains(arg, "=") {
+		return false
+	}
+	for _, prefix := range []string{"/", "./", "../", "~/"} {
+		if strings.HasPrefix(arg, prefix) {
+			return true
+		}
+	}
+	first, _, ok := strings.Cut(arg, "/")
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(task.GetWorkingDirectory(), first))
+	return err == nil
+}
+
+// resolve returns the path of a file named on the commandline. It reports
+// false if the path is relative and the directory it is relative to is not
+// known.
+func resolve(task *pb.Task, name string, afterCd bool) (string, bool) {
+	if rest, ok := strings.CutPrefix(name, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", false
+		}
+		return filepath.Join(home, rest), true
+	}
+	if filepath.IsAbs(name) {
+		return name, true
+	}
+	if afterCd || len(task.GetWorkingDirectory()) == 0 {
+		return "", false
+	}
+	return filepath.Join(task.GetWorkingDirectory(), name), true
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
+
+// defaultShellPath is the PATH sh and bash search when their environment
+// has none, e.g. when the task clears it
+const defaultShellPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// lookPath finds an executable on the PATH the task will run with. The
+// shell searches the PATH of the task; argv is searched in the PATH of the
+// server.
+func lookPath(task *pb.Task, name string) (string, error) {
+	path, ok := task.GetEnv()["PATH"]
+	switch {
+	case task.GetShell() == pb.Shell_EXEC || (!ok && !task.GetClearEnv()):
+		path = os.Getenv("PATH")
+	case !ok:
+		path = defaultShellPath
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if len(dir) == 0 {
+			dir = "."
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(task.GetWorkingDirectory(), dir)
+		}
+		if candidate := filepath.Join(dir, name); isExecutable(candidate) {
+			return candidate, nil
+		}
+	}
+	return "", exec.ErrNotFound
+}
+
+// shellBuiltins are the commands run by the shell itself
+var shellBuiltins = map[string]bool{
+	".": true, ":": true, "[": true, "[[": true, "alias": true, "bg": true,
+	"break": true, "builtin": true, "caller": true, "cd": true, "command": true,
+	"compgen": true, "complete": true, "continue": true, "declare": true,
+	"dirs": true, "disown": true, "echo": true, "enable": true, "eval": true,
+	"exec": true, "exit": true, "export": true, "false": true, "fg": true,
+	"getopts": true, "hash": true, "help": true, "history": true, "jobs": true,
+	"kill": true, "let": true, "local": true, "logout": true, "mapfile": true,
+	"popd": true, "printf": true, "pushd": true, "pwd": true, "read": true,
+	"readarray": true, "readonly": true, "return": true, "set": true,
+	"shift": true, "shopt": true, "source": true, "suspend": true, "test": true,
+	"times": true, "trap": true, "true": true, "type": true, "typeset": true,
+	"ulimit": true, "umask": true, "unalias": true, "unset": true, "wait": true,
+}
@@ -0,0 +1,61 @@
+package verifier
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"internal/pb"
+)
+
+func TestDefault(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "src", "main.go"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "src", "main.go")
+
+	tests := []struct {
+		name string
+		task *pb.Task
+		want []string
+	}{
+		{"ok", &pb.Task{WorkingDirectory: dir, Commandline: "ls src/main.go ./run.sh && echo done > out/log"}, nil},
+		{"builtins and functions", &pb.Task{WorkingDirectory: dir, Commandline: "f() { cd src; }; f; [ -d src ] && source ./run.sh"}, nil},
+		{"git ref is not a path", &pb.Task{WorkingDirectory: dir, Commandline: "git checkout origin/main"}, nil},
+		{"expansions are skipped", &pb.Task{WorkingDirectory: dir, Commandline: `$CMD ./$FILE "$HOME/x" ./*.txt`}, nil},
+		{"paths after cd are skipped", &pb.Task{WorkingDirectory: dir, Commandline: "cd src && cat ./main.go"}, nil},
+		{"missing directory", &pb.Task{WorkingDirectory: filepath.Join(dir, "missing"), Commandline: "true"}, []string{"working_directory"}},
+		{"not a directory", &pb.Task{WorkingDirectory: file, Commandline: "true"}, []string{"working_directory"}},
+		{"syntax error", &pb.Task{WorkingDirectory: dir, Commandline: "echo 'unterminated"}, []string{"commandline"}},
+		{"bash syntax in sh", &pb.Task{WorkingDirectory: dir, Commandline: "a=(src run.sh)"}, []string{"commandline"}},
+		{"bash syntax in bash", &pb.Task{WorkingDirectory: dir, Shell: pb.Shell_BASH, Commandline: "a=(src run.sh); [[ -d src ]]"}, nil},
+		{"unknown executable", &pb.Task{WorkingDirectory: dir, Commandline: "no-such-command-here --help"}, []string{"commandline"}},
+		{"not executable", &pb.Task{WorkingDirectory: dir, Commandline: "./src/main.go"}, []string{"commandline"}},
+		{"missing path", &pb.Task{WorkingDirectory: dir, Commandline: "cat ./missing.txt src/missing.go /no/such/file"}, []string{"commandline", "commandline", "commandline"}},
+		{"argv", &pb.Task{WorkingDirectory: dir, Shell: pb.Shell_EXEC, Argv: []string{"ls", "./missing"}}, []string{"argv"}},
+		{"task PATH", &pb.Task{WorkingDirectory: dir, Commandline: "ls", Env: map[string]string{"PATH": dir}}, []string{"commandline"}},
+		{"default PATH of the shell", &pb.Task{WorkingDirectory: dir, Commandline: "ls", ClearEnv: true}, nil},
+		{"files written by the script", &pb.Task{WorkingDirectory: dir, Commandline: "echo hi > ./out.txt; cat ./out.txt; mkdir -p build && cp src/main.go ./build && cat build/main.go"}, nil},
+		{"source of a copy", &pb.Task{WorkingDirectory: dir, Commandline: "cp ./missing.txt ./copy.txt"}, []string{"commandline"}},
+		{"argv writing a file", &pb.Task{WorkingDirectory: dir, Shell: pb.Shell_EXEC, Argv: []string{"touch", "./new.txt"}}, nil},
+	}
+	for _, test := range tests {
+		problems := Default().Verify(test.task)
+		if len(problems) != len(test.want) {
+			t.Errorf("%s: got problems %v, want problems in %v", test.name, problems, test.want)
+			continue
+		}
+		for i, p := range problems {
+			if p.Field != test.want[i] {
+				t.Errorf("%s: got a problem in %s, want %s: %v", test.name, p.Field, test.want[i], p)
+			}
+		}
+	}
+}